@@ -0,0 +1,277 @@
+/*
+ * 运维管理的http处理，可嵌入到已有的http服务中，以json返回：
+ * GET  {basePath}/servers                      服务端列表
+ * GET  {basePath}/channels?server={serverId}   服务端已接收的channel列表，server为空时返回所有服务端的
+ * GET  {basePath}/channel?id={chId}            channel详情
+ * POST {basePath}/channel/release?id={chId}    强制释放channel
+ * GET  {basePath}/loglevel                     获取日志级别
+ * POST {basePath}/loglevel?level={level}       修改日志级别
+ * GET  {basePath}/debugtarget                  获取按channel开启debug日志的目标
+ * POST {basePath}/debugtarget?kind={kind}&value={value}    添加开启debug日志的目标，kind见logger.Target_xxx
+ * DELETE {basePath}/debugtarget?kind={kind}&value={value}  移除开启debug日志的目标
+ * 本身不做鉴权，需由使用方在外层控制访问权限
+ * Author:slive
+ * DATE:2020/9/21
+ */
+package admin
+
+import (
+	"encoding/json"
+	gch "github.com/slive/gsfly/channel"
+	"github.com/slive/gsfly/engine"
+	logx "github.com/slive/gsfly/logger"
+	"github.com/slive/gsfly/socket"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerInfo 服务端信息
+type ServerInfo struct {
+	Id         string                    `json:"id"`
+	Network    string                    `json:"network"`
+	Addr       string                    `json:"addr"`
+	Closed     bool                      `json:"closed"`
+	ChannelNum int                       `json:"channelNum"`
+	Statis     gch.ChannelStatisSnapshot `json:"statis"`
+}
+
+// ChannelInfo channel信息，Age为创建至今的时长，单位秒
+type ChannelInfo struct {
+	Id           string                    `json:"id"`
+	ServerId     string                    `json:"serverId"`
+	Network      string                    `json:"network"`
+	LocalAddr    string                    `json:"localAddr"`
+	RemoteAddr   string                    `json:"remoteAddr"`
+	RelativePath string                    `json:"relativePath"`
+	State        string                    `json:"state"`
+	CreateTime   time.Time                 `json:"createTime"`
+	Age          float64                   `json:"age"`
+	AttachKeys   []string                  `json:"attachKeys"`
+	Statis       gch.ChannelStatisSnapshot `json:"statis"`
+}
+
+// AdminHandler 运维管理的http处理
+type AdminHandler struct {
+	basePath string
+	mux      *http.ServeMux
+	servers  []socket.IServerSocket
+	engines  []*engine.Engine
+	mut      sync.RWMutex
+}
+
+// NewAdminHandler 创建运维管理的http处理
+// basePath 基础路径，如/admin，为空时为根路径
+func NewAdminHandler(basePath string) *AdminHandler {
+	basePath = strings.TrimSuffix(basePath, "/")
+	h := &AdminHandler{basePath: basePath, mux: http.NewServeMux()}
+	h.mux.HandleFunc(basePath+"/servers", h.handleServers)
+	h.mux.HandleFunc(basePath+"/channels", h.handleChannels)
+	h.mux.HandleFunc(basePath+"/channel", h.handleChannel)
+	h.mux.HandleFunc(basePath+"/channel/release", h.handleRelease)
+	h.mux.HandleFunc(basePath+"/loglevel", h.handleLogLevel)
+	h.mux.HandleFunc(basePath+"/debugtarget", h.handleDebugTarget)
+	return h
+}
+
+// GetBasePath 获取基础路径
+func (h *AdminHandler) GetBasePath() string {
+	return h.basePath
+}
+
+// AddServer 添加需要管理的服务端
+func (h *AdminHandler) AddServer(serverSocket socket.IServerSocket) *AdminHandler {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.servers = append(h.servers, serverSocket)
+	return h
+}
+
+// AddEngine 添加需要管理的引擎，包括引擎当前所有的服务端
+func (h *AdminHandler) AddEngine(eng *engine.Engine) *AdminHandler {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.engines = append(h.engines, eng)
+	return h
+}
+
+func (h *AdminHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	h.mux.ServeHTTP(writer, req)
+}
+
+// fetchServers 获取所有服务端，包括引擎中的
+func (h *AdminHandler) fetchServers() []socket.IServerSocket {
+	h.mut.RLock()
+	defer h.mut.RUnlock()
+	servers := append([]socket.IServerSocket{}, h.servers...)
+	for _, eng := range h.engines {
+		servers = append(servers, eng.GetServers()...)
+	}
+	return servers
+}
+
+// findChannel 在所有服务端中查找channel
+func (h *AdminHandler) findChannel(id string) (socket.IServerSocket, gch.IChannel) {
+	for _, serverSocket := range h.fetchServers() {
+		ch := serverSocket.GetChannelMap().Get(id)
+		if ch != nil {
+			return serverSocket, ch
+		}
+	}
+	return nil, nil
+}
+
+func (h *AdminHandler) handleServers(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(writer, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	servers := h.fetchServers()
+	infos := make([]*ServerInfo, 0, len(servers))
+	for _, serverSocket := range servers {
+		conf := serverSocket.GetConf()
+		infos = append(infos, &ServerInfo{
+			Id:         serverSocket.GetId(),
+			Network:    conf.GetNetwork().String(),
+			Addr:       conf.GetAddrStr(),
+			Closed:     serverSocket.IsClosed(),
+			ChannelNum: serverSocket.GetChannelMap().Size(),
+			Statis:     serverSocket.GetChStatis().Snapshot(),
+		})
+	}
+	writeJson(writer, http.StatusOK, infos)
+}
+
+func (h *AdminHandler) handleChannels(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(writer, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	serverId := req.URL.Query().Get("server")
+	infos := make([]*ChannelInfo, 0)
+	for _, serverSocket := range h.fetchServers() {
+		if len(serverId) > 0 && serverSocket.GetId() != serverId {
+			continue
+		}
+		for _, ch := range serverSocket.GetChannelMap().Values() {
+			infos = append(infos, newChannelInfo(serverSocket, ch))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreateTime.Before(infos[j].CreateTime)
+	})
+	writeJson(writer, http.StatusOK, infos)
+}
+
+func (h *AdminHandler) handleChannel(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(writer, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	serverSocket, ch := h.findChannel(req.URL.Query().Get("id"))
+	if ch == nil {
+		writeError(writer, http.StatusNotFound, "channel not found")
+		return
+	}
+	writeJson(writer, http.StatusOK, newChannelInfo(serverSocket, ch))
+}
+
+func (h *AdminHandler) handleRelease(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(writer, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id := req.URL.Query().Get("id")
+	_, ch := h.findChannel(id)
+	if ch == nil {
+		writeError(writer, http.StatusNotFound, "channel not found")
+		return
+	}
+	logx.Warnf("force release channel by admin, chId:%v, remote:%v", id, req.RemoteAddr)
+	ch.Release()
+	writeJson(writer, http.StatusOK, map[string]string{"id": id, "state": ch.GetState().String()})
+}
+
+func (h *AdminHandler) handleLogLevel(writer http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		level := req.URL.Query().Get("level")
+		err := logx.SetLevel(level)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, err.Error())
+			return
+		}
+		logx.Warnf("change log level by admin, level:%v, remote:%v", level, req.RemoteAddr)
+	default:
+		writeError(writer, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJson(writer, http.StatusOK, map[string]string{"level": logx.GetLevel()})
+}
+
+func (h *AdminHandler) handleDebugTarget(writer http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	kind, value := query.Get("kind"), query.Get("value")
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if len(value) <= 0 {
+			writeError(writer, http.StatusBadRequest, "value is empty")
+			return
+		}
+		err := logx.AddDebugTarget(kind, value)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, err.Error())
+			return
+		}
+		logx.Warnf("add debug target by admin, kind:%v, value:%v, remote:%v", kind, value, req.RemoteAddr)
+	case http.MethodDelete:
+		logx.RemoveDebugTarget(kind, value)
+		logx.Warnf("remove debug target by admin, kind:%v, value:%v, remote:%v", kind, value, req.RemoteAddr)
+	default:
+		writeError(writer, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJson(writer, http.StatusOK, logx.GetDebugTargets())
+}
+
+func newChannelInfo(serverSocket socket.IServerSocket, ch gch.IChannel) *ChannelInfo {
+	keys := ch.GetAttachKeys()
+	sort.Strings(keys)
+	createTime := ch.GetCreateTime()
+	info := &ChannelInfo{
+		Id:           ch.GetId(),
+		ServerId:     serverSocket.GetId(),
+		Network:      ch.GetConf().GetNetwork().String(),
+		RelativePath: ch.GetRelativePath(),
+		State:        ch.GetState().String(),
+		CreateTime:   createTime,
+		Age:          time.Since(createTime).Seconds(),
+		AttachKeys:   keys,
+		Statis:       ch.GetChStatis().Snapshot(),
+	}
+	// 接口中的地址可能为nil
+	if addr := ch.LocalAddr(); addr != nil {
+		info.LocalAddr = addr.String()
+	}
+	if addr := ch.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	return info
+}
+
+func writeJson(writer http.ResponseWriter, status int, ret interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	err := json.NewEncoder(writer).Encode(ret)
+	if err != nil {
+		logx.Warn("write admin response error:", err)
+	}
+}
+
+func writeError(writer http.ResponseWriter, status int, msg string) {
+	writeJson(writer, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,110 @@
+/*
+ * Author:slive
+ * DATE:2020/9/21
+ */
+package admin
+
+import (
+	"encoding/json"
+	gch "github.com/slive/gsfly/channel"
+	logx "github.com/slive/gsfly/logger"
+	"github.com/slive/gsfly/socket"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func doRequest(h http.Handler, method string, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+	return recorder
+}
+
+func TestAdminHandler(t *testing.T) {
+	serverConf := socket.NewTcpServerConf("127.0.0.1", 19105)
+	serverSocket := socket.NewServerSocket(nil, serverConf, gch.NewDefChHandle(func(ctx gch.IChHandleContext) {}))
+	err := serverSocket.Listen()
+	if err != nil {
+		t.Fatalf("listen error:%v", err)
+	}
+	defer serverSocket.Close()
+
+	clientConf := socket.NewTcpClientConf("127.0.0.1", 19105)
+	clientSocket := socket.NewClientSocket(nil, clientConf, gch.NewDefChHandle(func(ctx gch.IChHandleContext) {}), nil)
+	err = clientSocket.Dial()
+	if err != nil {
+		t.Fatalf("dial error:%v", err)
+	}
+	defer clientSocket.Close()
+	for i := 0; i < 100 && serverSocket.GetChannelMap().Size() <= 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	h := NewAdminHandler("/admin/").AddServer(serverSocket)
+	recorder := doRequest(h, http.MethodGet, "/admin/servers")
+	var servers []*ServerInfo
+	json.Unmarshal(recorder.Body.Bytes(), &servers)
+	if len(servers) != 1 || servers[0].Id != serverSocket.GetId() || servers[0].ChannelNum != 1 {
+		t.Fatalf("unexpected servers:%v", recorder.Body.String())
+	}
+
+	recorder = doRequest(h, http.MethodGet, "/admin/channels?server="+url.QueryEscape(serverSocket.GetId()))
+	var channels []*ChannelInfo
+	json.Unmarshal(recorder.Body.Bytes(), &channels)
+	if len(channels) != 1 {
+		t.Fatalf("unexpected channels:%v", recorder.Body.String())
+	}
+	info := channels[0]
+	if info.Network != "tcp" || info.State != "Active" || info.RemoteAddr != clientSocket.GetChannel().LocalAddr().String() {
+		t.Fatalf("unexpected channel info:%+v", info)
+	}
+
+	chId := url.QueryEscape(info.Id)
+	if doRequest(h, http.MethodGet, "/admin/channel?id="+chId).Code != http.StatusOK {
+		t.Fatal("channel should be found.")
+	}
+	if doRequest(h, http.MethodGet, "/admin/channel/release?id="+chId).Code != http.StatusMethodNotAllowed {
+		t.Fatal("release should only allow post.")
+	}
+	if doRequest(h, http.MethodPost, "/admin/channel/release?id="+chId).Code != http.StatusOK {
+		t.Fatal("release channel error.")
+	}
+	if serverSocket.GetChannelMap().Size() != 0 {
+		t.Fatal("channel should be removed after release.")
+	}
+	if doRequest(h, http.MethodGet, "/admin/channel?id="+chId).Code != http.StatusNotFound {
+		t.Fatal("channel should not be found after release.")
+	}
+}
+
+func TestAdminDebugTarget(t *testing.T) {
+	defer logx.ClearDebugTargets()
+	h := NewAdminHandler("")
+	if doRequest(h, http.MethodPost, "/debugtarget?kind=unknown&value=a").Code != http.StatusBadRequest {
+		t.Fatal("unknown kind should be rejected.")
+	}
+	recorder := doRequest(h, http.MethodPost, "/debugtarget?kind="+logx.Target_RemoteAddr+"&value=10.0.0.1")
+	targets := make(map[string][]string)
+	json.Unmarshal(recorder.Body.Bytes(), &targets)
+	if recorder.Code != http.StatusOK || len(targets[logx.Target_RemoteAddr]) != 1 {
+		t.Fatalf("add debug target error:%v", recorder.Body.String())
+	}
+	doRequest(h, http.MethodDelete, "/debugtarget?kind="+logx.Target_RemoteAddr+"&value=10.0.0.1")
+	if len(logx.GetDebugTargets()) != 0 {
+		t.Fatal("debug target should be removed.")
+	}
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	defer logx.SetLevel(logx.Level_Debug)
+	h := NewAdminHandler("")
+	if doRequest(h, http.MethodPost, "/loglevel?level=unknown").Code != http.StatusBadRequest {
+		t.Fatal("unknown level should be rejected.")
+	}
+	recorder := doRequest(h, http.MethodPost, "/loglevel?level=warn")
+	if recorder.Code != http.StatusOK || logx.GetLevel() != logx.Level_Warn || logx.IsDebug() {
+		t.Fatalf("change level error:%v", recorder.Body.String())
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * 按大小分级的字节缓冲池，读取时从池中获取与实际数据大小匹配的缓冲，
+ * 包处理完后通过引用计数归还，避免每次读取都分配ReadBufSize大小的内存。
+ * Author:slive
+ * DATE:2020/7/25
+ */
+package channel
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// BUF_MIN_SIZE 最小分级大小(byte)
+	BUF_MIN_SIZE = 64
+	// BUF_MAX_SIZE 最大分级大小(byte)，超过该大小的缓冲不放入池中
+	BUF_MAX_SIZE = 1 << 20
+)
+
+// ByteBuf 带引用计数的缓冲，引用计数为0时归还缓冲池
+type ByteBuf struct {
+	data []byte
+	ref  int32
+	pool *BufPool
+	// 所属分级，-1表示不归还缓冲池
+	class int
+}
+
+// Bytes 获取数据
+func (buf *ByteBuf) Bytes() []byte {
+	return buf.data
+}
+
+// Len 数据长度
+func (buf *ByteBuf) Len() int {
+	return len(buf.data)
+}
+
+// Retain 增加引用计数，需对应调用Release
+func (buf *ByteBuf) Retain() {
+	atomic.AddInt32(&buf.ref, 1)
+}
+
+// Release 减少引用计数，为0时归还缓冲池，返回剩余的引用计数
+func (buf *ByteBuf) Release() int32 {
+	ref := atomic.AddInt32(&buf.ref, -1)
+	if ref == 0 && buf.pool != nil && buf.class >= 0 {
+		buf.data = buf.data[:0]
+		buf.pool.classes[buf.class].Put(buf)
+	}
+	return ref
+}
+
+// BufPool 按大小分级的缓冲池，分级大小从minSize开始每级翻倍，直到maxSize
+type BufPool struct {
+	classes []sync.Pool
+	sizes   []int
+}
+
+// NewBufPool 创建缓冲池
+// minSize 最小分级大小
+// maxSize 最大分级大小
+func NewBufPool(minSize int, maxSize int) *BufPool {
+	if minSize <= 0 {
+		minSize = BUF_MIN_SIZE
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+	p := &BufPool{}
+	for size := minSize; ; size <<= 1 {
+		p.sizes = append(p.sizes, size)
+		if size >= maxSize {
+			break
+		}
+	}
+	p.classes = make([]sync.Pool, len(p.sizes))
+	for index := range p.classes {
+		class := index
+		classSize := p.sizes[index]
+		p.classes[index].New = func() interface{} {
+			return &ByteBuf{data: make([]byte, 0, classSize), pool: p, class: class}
+		}
+	}
+	return p
+}
+
+// Get 获取长度为size的缓冲，引用计数为1，超过最大分级时直接分配
+func (p *BufPool) Get(size int) *ByteBuf {
+	class := p.classOf(size)
+	var buf *ByteBuf
+	if class < 0 {
+		buf = &ByteBuf{data: make([]byte, 0, size), class: -1}
+	} else {
+		buf = p.classes[class].Get().(*ByteBuf)
+	}
+	buf.data = buf.data[:size]
+	buf.ref = 1
+	return buf
+}
+
+// Copy 获取缓冲并复制data
+func (p *BufPool) Copy(data []byte) *ByteBuf {
+	buf := p.Get(len(data))
+	copy(buf.data, data)
+	return buf
+}
+
+// classOf 获取size对应的分级，超过最大分级返回-1
+func (p *BufPool) classOf(size int) int {
+	for index, classSize := range p.sizes {
+		if size <= classSize {
+			return index
+		}
+	}
+	return -1
+}
+
+// defBufPool 默认缓冲池
+var defBufPool = NewBufPool(BUF_MIN_SIZE, BUF_MAX_SIZE)
+
+// GetBuf 从默认缓冲池获取长度为size的缓冲
+func GetBuf(size int) *ByteBuf {
+	return defBufPool.Get(size)
+}
+
+// CopyBuf 从默认缓冲池获取缓冲并复制data
+func CopyBuf(data []byte) *ByteBuf {
+	return defBufPool.Copy(data)
+}
@@ -0,0 +1,83 @@
+/*
+ * Author:slive
+ * DATE:2020/7/25
+ */
+package channel
+
+import (
+	"testing"
+)
+
+func TestBufPoolClass(t *testing.T) {
+	pool := NewBufPool(64, 1024)
+	buf := pool.Get(100)
+	if buf.Len() != 100 || cap(buf.Bytes()) != 128 {
+		t.Fatalf("unexpected buf, len:%v, cap:%v", buf.Len(), cap(buf.Bytes()))
+	}
+	buf.Release()
+
+	// 超过最大分级直接分配
+	big := pool.Get(2048)
+	if big.Len() != 2048 || big.class != -1 {
+		t.Fatalf("unexpected big buf, len:%v, class:%v", big.Len(), big.class)
+	}
+	big.Release()
+}
+
+func TestPacketRetainRelease(t *testing.T) {
+	packet := &Packet{}
+	packet.SetBuf(CopyBuf([]byte("hello")))
+	packet.Retain()
+
+	// 仍被引用，数据不释放
+	packet.Release()
+	if packet.IsRelease() || string(packet.GetData()) != "hello" {
+		t.Fatal("packet should not be released when retained.")
+	}
+
+	packet.Release()
+	if !packet.IsRelease() {
+		t.Fatal("packet should be released.")
+	}
+}
+
+// BenchmarkReadNewBuf 原有方式，每次读取分配ReadBufSize大小的缓冲
+func BenchmarkReadNewBuf(b *testing.B) {
+	data := make([]byte, 256)
+	readBufSize := globalChannelConf.GetReadBufSize()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		readbf := make([]byte, readBufSize)
+		n := copy(readbf, data)
+		packet := &Packet{}
+		packet.SetData(readbf[0:n])
+		packet.Release()
+	}
+}
+
+// BenchmarkReadPoolBuf 复用读缓冲，按实际大小从缓冲池复制，处理完后归还
+func BenchmarkReadPoolBuf(b *testing.B) {
+	data := make([]byte, 256)
+	readbf := make([]byte, globalChannelConf.GetReadBufSize())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		n := copy(readbf, data)
+		packet := &Packet{}
+		packet.SetBuf(CopyBuf(readbf[0:n]))
+		packet.Release()
+	}
+}
+
+func BenchmarkReadPoolBufParallel(b *testing.B) {
+	data := make([]byte, 4096)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		readbf := make([]byte, globalChannelConf.GetReadBufSize())
+		for pb.Next() {
+			n := copy(readbf, data)
+			packet := &Packet{}
+			packet.SetBuf(CopyBuf(readbf[0:n]))
+			packet.Release()
+		}
+	})
+}
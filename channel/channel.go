@@ -9,20 +9,28 @@ import (
 	"fmt"
 	"github.com/slive/gsfly/common"
 	logx "github.com/slive/gsfly/logger"
+	"github.com/slive/gsfly/tracex"
 	"github.com/pkg/errors"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	// 错误相关的定义
-	ERR_READ     = "ERR_READ"
-	ERR_MSG      = "ERR_MSG"
-	ERR_WRITE    = "ERR_WRITE"
-	ERR_INACTIVE = "ERR_INACTIVE"
-	ERR_ACTIVE   = "ERR_ACTIVE"
-	ERR_REG      = "ERR_REG"
+	// 错误相关的定义，见common中的错误码
+	ERR_READ     = common.ERR_READ
+	ERR_MSG      = common.ERR_MSG
+	ERR_WRITE    = common.ERR_WRITE
+	ERR_INACTIVE = common.ERR_INACTIVE
+	ERR_ACTIVE   = common.ERR_ACTIVE
+	ERR_REG      = common.ERR_REG
+	ERR_TIMEOUT  = common.ERR_TIMEOUT
+	ERR_CLOSED   = common.ERR_CLOSED
+	ERR_AUTH     = common.ERR_AUTH
+	ERR_DECODE   = common.ERR_DECODE
+	ERR_OVERLOAD = common.ERR_OVERLOAD
 )
 
 // IChannel 通信通道接口
@@ -36,21 +44,36 @@ type IChannel interface {
 	// WriteByConn 通过conn写
 	WriteByConn(packet IPacket) error
 
+	// WriteBatchByConn 通过conn合并写多个包，如tcp的writev，udp的sendmmsg
+	WriteBatchByConn(packets []IPacket) error
+
 	// IsReadLoopContinued 读出错是是否继续
 	IsReadLoopContinued(err error) bool
 
 	// NewPacket 创建收发包
 	NewPacket() IPacket
 
-	// IsClosed 是否是关闭的
+	// IsClosed 是否是关闭的，非Active状态都视为关闭
 	IsClosed() bool
 
+	// GetState 获取生命周期状态
+	GetState() ChState
+
+	// GetCreateTime 获取创建时间
+	GetCreateTime() time.Time
+
 	// Read 读取方法
 	Read() (IPacket, error)
 
 	// Write 写入方法
 	Write(packet IPacket) error
 
+	// WriteBatch 批量写入，先缓存，调用Flush或者缓存达到上限时合并发送
+	WriteBatch(packets ...IPacket) error
+
+	// Flush 合并发送已缓存的包
+	Flush() error
+
 	// GetConf 获取通道配置
 	GetConf() IChannelConf
 
@@ -78,6 +101,9 @@ type IChannel interface {
 	// SetRelativePath 设置path
 	SetRelativePath(path string)
 
+	// IsDrained 是否已处理完所有待处理的读写，用于优雅关闭
+	IsDrained() bool
+
 	common.IAttact
 
 	common.IParent
@@ -94,11 +120,26 @@ type Channel struct {
 	ChannelStatis *ChannelStatis
 	Conn          net.Conn
 
-	conf      IChannelConf
-	readPool  *ReadPool
-	closed    bool
+	conf     IChannelConf
+	readPool *ReadPool
+	// 生命周期状态，见ChState
+	state      int32
+	createTime time.Time
 	closeExit chan bool
 	server    bool
+	// 已放入读协程池还未处理完的包数
+	pendingNum int32
+	// 复用的读缓冲
+	readBuf []byte
+
+	// 待合并发送的包
+	batch      []IPacket
+	batchBytes int
+	// 缓存的包对应的写入span，发送后结束
+	batchSpans []tracex.ISpan
+	batchMut   sync.Mutex
+	// 关闭后不再缓存，之后批量写入返回ErrChannelClosed
+	batchClosed bool
 
 	// 路径，根据各自需要定义
 	relativePath string
@@ -110,35 +151,84 @@ type Channel struct {
 	common.RunContext
 }
 
-// defReadPoolConf 初始化读协程池，全局配置，若不初始化，默认使用global配置
-var defReadPoolConf *ReadPoolConf
+// IReadPoolHolder 读协程池持有者，创建channel时未指定读协程池，则优先使用父节点的读协程池
+type IReadPoolHolder interface {
+	// GetReadPool 获取读协程池，可为nil
+	GetReadPool() *ReadPool
+}
+
+// defServerReadPoolConf 服务端默认读协程池配置，若不初始化，默认使用global配置
+var defServerReadPoolConf *ReadPoolConf
+
+// defClientReadPoolConf 客户端默认读协程池配置，若不初始化，默认使用global配置
+var defClientReadPoolConf *ReadPoolConf
+
+// defServerReadPool 服务端默认读线程池，服务端和客户端分开，互不影响
+var defServerReadPool *ReadPool
 
-// defReadPool 默认读线程池
-var defReadPool *ReadPool
+// defClientReadPool 客户端默认读线程池
+var defClientReadPool *ReadPool
+
+var defReadPoolMut sync.Mutex
 
 // 默认channel配置
 var defChannelConf IChannelConf
 
-func initDefChannelConfs(server bool) {
-	if defReadPool == nil {
-		if server {
-			// 默认使用global配置
-			defReadPoolConf = globalServerReadPoolConf
-		} else {
-			defReadPoolConf = globalClientReadPoolConf
-		}
-		defReadPool = NewReadPool(defReadPoolConf.MaxReadPoolSize, defReadPoolConf.MaxReadQueueSize)
-		logx.Info("init default readPoolConf:", defReadPoolConf)
-	}
-
+func initDefChannelConfs() {
 	if defChannelConf == nil {
 		defChannelConf = globalChannelConf
 		logx.Info("init default channelConf:", defChannelConf)
 	}
 }
 
+// getDefReadPool 获取服务端或者客户端的默认读协程池，未创建时按配置创建
+func getDefReadPool(server bool) *ReadPool {
+	defReadPoolMut.Lock()
+	defer defReadPoolMut.Unlock()
+	if server {
+		if defServerReadPool == nil {
+			if defServerReadPoolConf == nil {
+				// 默认使用global配置
+				defServerReadPoolConf = globalServerReadPoolConf
+			}
+			defServerReadPool = newDefReadPool(defServerReadPoolConf)
+			logx.Info("init default server readPoolConf:", defServerReadPoolConf)
+		}
+		return defServerReadPool
+	}
+
+	if defClientReadPool == nil {
+		if defClientReadPoolConf == nil {
+			defClientReadPoolConf = globalClientReadPoolConf
+		}
+		defClientReadPool = newDefReadPool(defClientReadPoolConf)
+		logx.Info("init default client readPoolConf:", defClientReadPoolConf)
+	}
+	return defClientReadPool
+}
+
+// LoadDefReadPool 获取服务端或者客户端的默认读协程池，未创建时返回nil
+func LoadDefReadPool(server bool) *ReadPool {
+	defReadPoolMut.Lock()
+	defer defReadPoolMut.Unlock()
+	if server {
+		return defServerReadPool
+	}
+	return defClientReadPool
+}
+
+// newDefReadPool 创建默认读协程池，配置有误时panic
+func newDefReadPool(conf *ReadPoolConf) *ReadPool {
+	readPool, err := NewReadPoolByConf(conf)
+	if err != nil {
+		logx.Panic("new default readPool error:", err)
+		panic(err)
+	}
+	return readPool
+}
+
 // InitDefChannelConf 自定义初始化默认的channel相关配置，如果该方法未调用，则调用默认初始化方法
-// readPoolConf 读线程池配置
+// readPoolConf 读线程池配置，服务端和客户端的默认读协程池都使用该配置，但各自独立
 // chConf channel相关配置
 func InitDefChannelConf(readPoolConf *ReadPoolConf, chConf *ChannelConf) {
 	if readPoolConf == nil {
@@ -153,17 +243,47 @@ func InitDefChannelConf(readPoolConf *ReadPoolConf, chConf *ChannelConf) {
 		panic(err)
 	}
 
-	defReadPoolConf = readPoolConf
-	defReadPool = NewReadPool(readPoolConf.MaxReadPoolSize, readPoolConf.MaxReadQueueSize)
+	err := InitDefReadPoolConf(readPoolConf, readPoolConf)
+	if err != nil {
+		logx.Panic("init readPoolConf error:", err)
+		panic(err)
+	}
 	defChannelConf = chConf
 }
 
+// InitDefReadPoolConf 分别初始化服务端和客户端的默认读协程池配置，为nil的保持原有配置，
+// 之后创建的channel按新配置重新创建默认读协程池，已有的channel继续使用原来的(空闲后工作协程自动回收)
+// serverConf 服务端读协程池配置
+// clientConf 客户端读协程池配置
+func InitDefReadPoolConf(serverConf *ReadPoolConf, clientConf *ReadPoolConf) error {
+	for _, conf := range []*ReadPoolConf{serverConf, clientConf} {
+		if conf != nil {
+			err := conf.Validate()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	defReadPoolMut.Lock()
+	defer defReadPoolMut.Unlock()
+	if serverConf != nil {
+		defServerReadPoolConf = serverConf
+		defServerReadPool = nil
+	}
+	if clientConf != nil {
+		defClientReadPoolConf = clientConf
+		defClientReadPool = nil
+	}
+	return nil
+}
+
 var initOnce sync.Once
 
 // NewDefChannel 创建默认基础通信通道
 func NewDefChannel(parent interface{}, chConf IChannelConf, chHandle *ChHandle, server bool) *Channel {
-	// 全局初始化一次
-	return NewChannel(parent, chConf, defReadPool, chHandle, server)
+	// 读协程池优先选用父节点的，否则选用默认
+	return NewChannel(parent, chConf, nil, chHandle, server)
 }
 
 // NewSimpleChannel 创建默认基础通信通道
@@ -177,7 +297,7 @@ func NewSimpleChannel(onReadHandler ChHandleFunc) *Channel {
 // NewChannel 创建channel
 // parent 父节点，可为nil
 // chConf channel配置，可为nil，如果为nil，则选用默认
-// readPool 读取消息池，可为nil，如果为nil，则选用默认
+// readPool 读取消息池，可为nil，如果为nil，则优先选用父节点的(父节点实现IReadPoolHolder)，否则选用默认
 // chHandle 处理handle，包括读写，注册等处理，不可为空
 // server 是否为服务端创建
 func NewChannel(parent interface{}, chConf IChannelConf, readPool *ReadPool, chHandle *ChHandle, server bool) *Channel {
@@ -189,38 +309,51 @@ func NewChannel(parent interface{}, chConf IChannelConf, readPool *ReadPool, chH
 
 	// 如果未初始化一些必要配置，则默认初始化
 	initOnce.Do(func() {
-		// 默认初始化logger
-		logx.InitDefLogger()
 		// 默认初始化channelConf的配置
-		initDefChannelConfs(server)
+		initDefChannelConfs()
 	})
 
 	if chConf == nil {
 		// 选用默认配置
 		chConf = defChannelConf
 	}
+	if readPool == nil {
+		holder, ok := parent.(IReadPoolHolder)
+		if ok {
+			// 选用父节点的协程池
+			readPool = holder.GetReadPool()
+		}
+	}
 	if readPool == nil {
 		// 选用默认协程池
-		readPool = defReadPool
+		readPool = getDefReadPool(server)
+	}
+
+	// 统计同时汇总到父节点和协议类型
+	var parentStatis *ChannelStatis
+	statisHolder, ok := parent.(IChStatisHolder)
+	if ok {
+		parentStatis = statisHolder.GetChStatis()
 	}
+	chStatis := NewChStatis(parentStatis, GetNetworkStatis(chConf.GetNetwork()))
 
 	channel := &Channel{
 		ChannelHandle: chHandle,
-		ChannelStatis: NewChStatis(),
+		ChannelStatis: chStatis,
 		conf:          chConf,
 		readPool:      readPool,
 		closeExit:     make(chan bool, 1),
+		createTime:    time.Now(),
 		server:        server,
 		relativePath:  "",
 	}
 
-	channel.SetClosed(true)
 	channel.Attact = *common.NewAttact()
 	channel.Id = *common.NewId()
 	channel.Parent = *common.NewParent(parent)
 
-	// 设置上下文runcontext
-	channel.RunContext = *common.NewRunContextByParent(parent)
+	// 设置上下文runcontext，释放或者父节点(如socket)关闭时取消
+	channel.RunContext = *common.NewCancelRunContextByParent(parent)
 	logx.InfoTracef(channel, "create base channel, chConf:%+v", chConf)
 	return channel
 }
@@ -234,6 +367,8 @@ func (ch *Channel) SetId(id string) {
 		id = "client#" + id
 	}
 	ch.AddTrace(id)
+	ch.AddValue(common.Key_ChId, id)
+	ch.AddValue(common.Key_Network, ch.conf.GetNetwork().String())
 	ch.Id.SetId(id)
 }
 
@@ -245,13 +380,20 @@ func (ch *Channel) Release() {
 	ch.StopChannel(ch)
 }
 
+// StartChannel 打开channel，状态由Created经Opening变为Active，只能打开一次
 func (ch *Channel) StartChannel(channel IChannel) error {
 	id := ch.GetId()
-	if !channel.IsClosed() {
-		logx.ErrorTracef(ch, "channel had open.")
+	// 远端地址作为日志的结构化字段
+	if addr := channel.RemoteAddr(); addr != nil {
+		ch.AddValue(common.Key_RemoteAddr, addr.String())
+	}
+	if !ch.casState(NewChHandleContext(channel, nil), CH_STATE_CREATED, CH_STATE_OPENING) {
+		logx.ErrorTracef(ch, "channel had open, state:%v", ch.GetState())
 		return errors.New("channel had open, chId:" + id)
 	}
 
+	// 连接的span，之后的span默认为其子span，处理上下文需在其之后创建
+	span := startConnectSpan(ch)
 	ctx := NewChHandleContext(channel, nil)
 	defer func() {
 		rec := recover()
@@ -262,12 +404,20 @@ func (ch *Channel) StartChannel(channel IChannel) error {
 				// 捕获处理消息异常
 				NotifyErrorHandle(ctx, err, ERR_ACTIVE)
 			}
+			endSpan(span, err)
 			channel.Release()
 		}
 	}()
+	// 打开过程中可能已被释放
+	if !ch.casState(ctx, CH_STATE_OPENING, CH_STATE_ACTIVE) {
+		logx.WarnTracef(ch, "channel released while opening, state:%v", ch.GetState())
+		err := errors.New("channel released while opening, chId:" + id)
+		endSpan(span, err)
+		return err
+	}
+	endSpan(span, nil)
 	go ch.startReadLoop(channel)
-
-	ch.SetClosed(false)
+	go ch.watchContext(channel, ch.GetContext().Done())
 	logx.InfoTrace(ch, "finish to start channel.")
 	return nil
 }
@@ -276,10 +426,22 @@ func (ch *Channel) NewReadBuf() []byte {
 	return make([]byte, ch.conf.GetReadBufSize())
 }
 
-func NotifyErrorHandle(ctx IChHandleContext, err error, errMsg string) {
-	chHandle := ctx.GetChannel().GetChHandle()
-	errorHandler := chHandle.GetOnError()
-	ctx.SetError(common.NewError1(errMsg, err))
+// GetReadBuf 获取channel复用的读缓冲，只在读协程中使用，读取后的数据需复制(如CopyBuf)后再交给packet
+func (ch *Channel) GetReadBuf() []byte {
+	if ch.readBuf == nil {
+		ch.readBuf = ch.NewReadBuf()
+	}
+	return ch.readBuf
+}
+
+// NotifyErrorHandle 通知错误处理方法，错误码见ToChError
+// defCode 默认的错误码，如ERR_READ
+func NotifyErrorHandle(ctx IChHandleContext, err error, defCode string) {
+	channel := ctx.GetChannel()
+	errorHandler := channel.GetChHandle().GetOnError()
+	gerr := ToChError(channel, err, defCode)
+	ctx.SetError(gerr)
+	recordChError(channel, gerr.GetErrCode())
 	errorHandler(ctx)
 }
 
@@ -287,12 +449,14 @@ func (ch *Channel) NewPacket() IPacket {
 	panic("implement me")
 }
 
-func (ch *Channel) IsClosed() bool {
-	return ch.closed
+// GetCreateTime 获取创建时间
+func (ch *Channel) GetCreateTime() time.Time {
+	return ch.createTime
 }
 
-func (ch *Channel) SetClosed(closed bool) {
-	ch.closed = closed
+// IsClosed 是否是关闭的，非Active状态都视为关闭
+func (ch *Channel) IsClosed() bool {
+	return ch.GetState() != CH_STATE_ACTIVE
 }
 
 func (ch *Channel) Read() (packet IPacket, err error) {
@@ -300,11 +464,11 @@ func (ch *Channel) Read() (packet IPacket, err error) {
 }
 
 func (ch *Channel) Write(datapacket IPacket) error {
+	channel := datapacket.GetChannel()
 	if ch.IsClosed() {
-		return errors.New("wschannel had closed, chId:" + ch.GetId())
+		return NewWriteErrorByKind(channel, ErrChannelClosed, nil)
 	}
 
-	channel := datapacket.GetChannel()
 	ctx := NewChHandleContext(channel, datapacket)
 	chHandle := channel.GetChHandle()
 	defer func() {
@@ -323,6 +487,14 @@ func (ch *Channel) Write(datapacket IPacket) error {
 	}()
 
 	if datapacket.IsPrepare() {
+		// 先发送已缓存的批量包，保证顺序
+		err := ch.Flush()
+		if err != nil {
+			return err
+		}
+
+		// 在发送前的处理之前注入traceparent，以便编码
+		span := startWriteSpan(channel, datapacket, false)
 		// 发送前的处理
 		onWriteHandle := chHandle.preWrite
 		if onWriteHandle != nil {
@@ -330,14 +502,16 @@ func (ch *Channel) Write(datapacket IPacket) error {
 			err := ctx.gerr
 			if err != nil {
 				logx.Error("onWriteHandle error:", err)
+				endSpan(span, err)
 				return err
 			}
 		}
 
 		// 发送
-		err := channel.WriteByConn(datapacket)
+		err = channel.WriteByConn(datapacket)
+		endSpan(span, err)
 		if err != nil {
-			return err
+			return handleWriteError(channel, []IPacket{datapacket}, err)
 		}
 
 		SendStatis(datapacket, true)
@@ -353,6 +527,146 @@ func (ch *Channel) WriteByConn(datapacket IPacket) error {
 	panic("implement me")
 }
 
+const (
+	// MAX_WRITE_BATCH_NUM 批量写入缓存的最大包数，达到后自动发送
+	MAX_WRITE_BATCH_NUM = 64
+)
+
+// WriteBatch 批量写入，执行发送前的处理后缓存，调用Flush或者缓存的包数超过MAX_WRITE_BATCH_NUM、
+// 字节数超过WriteBufSize时合并发送，关闭时先尝试发送，仍未发送的包释放
+func (ch *Channel) WriteBatch(datapackets ...IPacket) error {
+	if ch.IsClosed() {
+		var channel IChannel
+		if len(datapackets) > 0 {
+			channel = datapackets[0].GetChannel()
+		}
+		return NewWriteErrorByKind(channel, ErrChannelClosed, nil)
+	}
+
+	for _, datapacket := range datapackets {
+		if !datapacket.IsPrepare() {
+			logx.Warn("datapacket is not prepare.")
+			continue
+		}
+
+		// 发送前的处理
+		channel := datapacket.GetChannel()
+		span := startWriteSpan(channel, datapacket, true)
+		onWriteHandle := channel.GetChHandle().preWrite
+		if onWriteHandle != nil {
+			ctx := NewChHandleContext(channel, datapacket)
+			onWriteHandle(ctx)
+			err := ctx.gerr
+			if err != nil {
+				logx.Error("onWriteHandle error:", err)
+				endSpan(span, err)
+				return err
+			}
+		}
+
+		ch.batchMut.Lock()
+		if ch.batchClosed {
+			// 与开始时已关闭一样，未缓存的包仍由调用者持有
+			ch.batchMut.Unlock()
+			err := NewWriteErrorByKind(channel, ErrChannelClosed, nil)
+			endSpan(span, err)
+			return err
+		}
+		ch.batch = append(ch.batch, datapacket)
+		ch.batchSpans = append(ch.batchSpans, span)
+		ch.batchBytes += len(datapacket.GetData())
+		full := len(ch.batch) >= MAX_WRITE_BATCH_NUM || ch.batchBytes >= ch.conf.GetWriteBufSize()
+		ch.batchMut.Unlock()
+		if full {
+			err := ch.Flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush 合并发送已缓存的包，发送失败时按写入异常策略处理，并释放这些包
+func (ch *Channel) Flush() error {
+	ch.batchMut.Lock()
+	if len(ch.batch) <= 0 {
+		ch.batchMut.Unlock()
+		return nil
+	}
+	datapackets := ch.batch
+	spans := ch.batchSpans
+	ch.batch = nil
+	ch.batchSpans = nil
+	ch.batchBytes = 0
+	channel := datapackets[0].GetChannel()
+	// 持有锁发送，保证多次Flush之间的顺序
+	err := channel.WriteBatchByConn(datapackets)
+	ch.batchMut.Unlock()
+
+	if err != nil {
+		err = handleWriteError(channel, datapackets, err)
+		endSpans(spans, err)
+		// 缓存的包由channel持有，发送失败后释放
+		for _, datapacket := range datapackets {
+			datapacket.Release()
+		}
+		return err
+	}
+	for _, datapacket := range datapackets {
+		SendStatis(datapacket, true)
+	}
+	endSpans(spans, nil)
+	return nil
+}
+
+// discardBatch 关闭时丢弃未发送的批量包，统计为发送失败并释放，之后不再缓存
+func (ch *Channel) discardBatch() {
+	ch.batchMut.Lock()
+	datapackets := ch.batch
+	spans := ch.batchSpans
+	ch.batch = nil
+	ch.batchSpans = nil
+	ch.batchBytes = 0
+	ch.batchClosed = true
+	ch.batchMut.Unlock()
+	if len(datapackets) <= 0 {
+		return
+	}
+	logx.WarnTracef(ch, "discard unsent batch packets, num:%v", len(datapackets))
+	endSpans(spans, NewWriteErrorByKind(datapackets[0].GetChannel(), ErrChannelClosed, nil))
+	for _, datapacket := range datapackets {
+		SendStatis(datapacket, false)
+		datapacket.Release()
+	}
+}
+
+// handleWriteError 处理写入异常：统计失败，通知错误处理方法，按策略决定是否关闭channel，返回WriteError
+func handleWriteError(channel IChannel, datapackets []IPacket, err error) error {
+	writeErr := NewWriteError(channel, err)
+	logx.Errorf("write error:%v", writeErr)
+	for _, datapacket := range datapackets {
+		SendStatis(datapacket, false)
+	}
+	NotifyErrorHandle(NewChHandleContext(channel, datapackets[0]), writeErr, ERR_WRITE)
+	policy := channel.GetChHandle().GetWriteErrorPolicy()
+	if policy(channel, writeErr) {
+		channel.Release()
+	}
+	return writeErr
+}
+
+// WriteBatchByConn 默认逐个通过WriteByConn发送，各协议可实现更高效的合并发送
+func (ch *Channel) WriteBatchByConn(datapackets []IPacket) error {
+	for _, datapacket := range datapackets {
+		err := datapacket.GetChannel().WriteByConn(datapacket)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (ch *Channel) GetConf() IChannelConf {
 	return ch.conf
 }
@@ -367,7 +681,7 @@ func (ch *Channel) GetConn() net.Conn {
 
 func (ch *Channel) IsReadLoopContinued(err error) bool {
 	// 读取超过一定失败次数后，不再继续执行
-	return ch.GetChStatis().RevStatics.FailTimes < (int64)(ch.conf.GetCloseRevFailTime())
+	return ch.GetChStatis().RevStatics.GetFailTimes() < (int64)(ch.conf.GetCloseRevFailTime())
 }
 
 func (ch *Channel) GetChHandle() *ChHandle {
@@ -387,18 +701,47 @@ func (ch *Channel) GetRelativePath() string {
 // SetRelativePath 设置相对path，根据各自业务需要进行定义
 func (ch *Channel) SetRelativePath(relativePath string) {
 	ch.relativePath = relativePath
+	ch.AddValue(common.Key_RelativePath, relativePath)
 }
 
 func (ch *Channel) StopChannel(channel IChannel) {
-	// 关闭状态不再执行后面的内容
+	ch.StopChannelWithContext(NewChHandleContext(channel, nil))
+}
+
+// IsDrained 是否已处理完所有待处理的读写，默认判断读协程池中是否还有未处理的包，以及是否还有未发送的批量包
+func (ch *Channel) IsDrained() bool {
+	if atomic.LoadInt32(&ch.pendingNum) > 0 {
+		return false
+	}
+	ch.batchMut.Lock()
+	defer ch.batchMut.Unlock()
+	return len(ch.batch) <= 0
+}
+
+// addPending 增加待处理的包数
+func (ch *Channel) addPending(delta int32) {
+	atomic.AddInt32(&ch.pendingNum, delta)
+}
+
+// iPending 用于读协程池处理完后减少待处理的包数
+type iPending interface {
+	addPending(delta int32)
+}
+
+// StopChannelWithContext 关闭channel，ctx会传递给onRelease等处理方法，可预先在ctx中设置附件，
+// 状态由Opening或者Active经Closing变为Closed，重复调用时直接返回
+func (ch *Channel) StopChannelWithContext(ctx IChHandleContext) {
+	channel := ctx.GetChannel()
 	id := ch.GetId()
-	if channel.IsClosed() {
-		logx.Info("channel is closed, chId:", id)
+	// 只有一个调用者可以变更为Closing，其他状态不再执行后面的内容
+	if !ch.casState(ctx, CH_STATE_ACTIVE, CH_STATE_CLOSING) &&
+		!ch.casState(ctx, CH_STATE_OPENING, CH_STATE_CLOSING) {
+		logx.Infof("channel is not active, chId:%v, state:%v", id, ch.GetState())
 		return
 	}
 
-	ctx := NewChHandleContext(channel, nil)
 	handle := channel.GetChHandle()
+	span := startCloseSpan(ch)
 	defer func() {
 		rec := recover()
 		if rec != nil {
@@ -407,24 +750,44 @@ func (ch *Channel) StopChannel(channel IChannel) {
 			if ok {
 				// 捕获处理消息异常
 				NotifyErrorHandle(ctx, err, ERR_INACTIVE)
+				if span != nil {
+					span.SetError(err)
+				}
 			}
 		}
 
-		// 执行关闭后的方法
-		closeFunc := handle.onRelease
-		if closeFunc != nil {
-			closeFunc(ctx)
+		// 关闭期间仍未发送的批量包不再发送，直接释放
+		ch.discardBatch()
+		release := func() {
+			// 执行关闭后的方法
+			closeFunc := handle.onRelease
+			if closeFunc != nil {
+				closeFunc(ctx)
+			}
+			// 附件在onRelease中仍可用，之后再清除并执行附件的释放回调
+			ch.Clear()
+			ch.casState(ctx, CH_STATE_CLOSING, CH_STATE_CLOSED)
+			endSpan(span, nil)
+			logx.Info("finish to close channel, chId:", id)
+		}
+		if ch.readPool != nil {
+			// 移除读协程池中对应的队列，剩余的包处理完后再执行关闭后的方法，避免处理中的包使用已清除的附件
+			ch.readPool.Remove(id, release)
+		} else {
+			release()
 		}
-		logx.Info("finish to close channel, chId:", id)
-
 	}()
 
 	logx.Info("start to close channel, chId:", id)
-	// 清理关闭相关
-	ch.SetClosed(true)
-	ch.Clear()
-	ch.closeExit <- true
+	// 关闭conn前尽量发送已缓存的批量包，失败时由discardBatch释放
+	err := ch.Flush()
+	if err != nil {
+		logx.WarnTracef(ch, "flush batch on close error:%v", err)
+	}
+	// 清理关闭相关，只有变更为Closing的调用者会执行，closeExit只会关闭一次
 	close(ch.closeExit)
+	// 取消上下文，使用该上下文的下游调用随之结束
+	ch.Cancel()
 
 	// TODO udpchannel没必要关闭，待定，关闭conn不应该channel来管理？
 	conn := channel.GetConn()
@@ -433,6 +796,19 @@ func (ch *Channel) StopChannel(channel IChannel) {
 	}
 }
 
+// watchContext 父节点的上下文取消(如socket关闭)时释放channel，读协程阻塞在读取时也能及时结束
+func (ch *Channel) watchContext(channel IChannel, done <-chan struct{}) {
+	select {
+	case <-ch.closeExit:
+	case <-done:
+		// 释放时先关闭closeExit，状态已不是Active
+		if ch.GetState() == CH_STATE_ACTIVE {
+			logx.InfoTracef(ch, "release channel by context done.")
+			channel.Release()
+		}
+	}
+}
+
 // StartReadLoop 启动循环读取，读取到数据包后，放入#ReadQueue中，等待处理
 func (ch *Channel) startReadLoop(channel IChannel) {
 	ctx := NewChHandleContext(channel, nil)
@@ -445,7 +821,7 @@ func (ch *Channel) startReadLoop(channel IChannel) {
 				// 捕获处理消息异常
 				NotifyErrorHandle(ctx, err, ERR_READ)
 			}
-			ch.Release()
+			channel.Release()
 		}
 	}()
 	logx.InfoTrace(ch, "start to readloop.")
@@ -459,8 +835,14 @@ func (ch *Channel) startReadLoop(channel IChannel) {
 			return
 		default:
 			rev, err := channel.Read()
-			logx.InfoTracef(ch, "rev:%v", rev)
+			logx.SampleInfoTracef(logx.GetHotPathSampler(), "rev", ch, "rev:%v", rev)
 			if err != nil {
+				if errors.Is(err, ErrNormalClosure) {
+					// 对端正常关闭，不视为读取异常
+					logx.InfoTracef(ch, "stop read loop by normal closure.")
+					channel.Release()
+					return
+				}
 				switch err {
 				case io.EOF, io.ErrClosedPipe, io.ErrUnexpectedEOF:
 					// io的异常直接结束
@@ -476,14 +858,24 @@ func (ch *Channel) startReadLoop(channel IChannel) {
 			}
 
 			if rev != nil && rev.IsPrepare() {
+				traceRead(channel, rev)
 				readPool := ch.readPool
 				if readPool != nil {
 					// 放入读取协程池等待处理
-					readPool.Cache(rev)
+					ch.addPending(1)
+					err := readPool.Cache(rev)
+					if err != nil {
+						logx.WarnTracef(ch, "cache packet error:%v", err)
+						if err != ErrReadPoolClosed && err != ErrChannelClosed {
+							// 读队列已满被拒绝，错误码为ERR_OVERLOAD
+							NotifyErrorHandle(NewChHandleContext(channel, nil), err, ERR_OVERLOAD)
+						}
+					}
 				} else {
 					// 否则默认直接处理
 					context := NewChHandleContext(channel, rev)
 					channel.GetChHandle().onInnerRead(context)
+					rev.Release()
 				}
 			}}
 	}
@@ -496,7 +888,7 @@ func HandleOnConnnect(ctx IChHandleContext) {
 		activeFunc(ctx)
 		gerr := ctx.GetError()
 		if gerr != nil {
-			NotifyErrorHandle(ctx, gerr.GetErr(), ERR_READ)
+			NotifyErrorHandle(ctx, gerr, ERR_ACTIVE)
 		}
 	}
 }
@@ -204,8 +204,16 @@ func (addr *AddrConf) GetAddrStr() string {
 
 // ReadPoolConf 读资源池配置
 type ReadPoolConf struct {
-	MaxReadPoolSize  int
+	// MaxReadPoolSize 最大工作协程数，需大于0
+	MaxReadPoolSize int
+	// MaxReadQueueSize 每个channel读队列可缓冲包数，需大于0
 	MaxReadQueueSize int
+	// MinReadPoolSize 最少工作协程数，常驻不回收，<=0时不保留
+	MinReadPoolSize int
+	// KeepAliveTime 多余的空闲工作协程存活时间，如30 * time.Second，<=0时取READ_POOL_KEEPALIVE_TIME秒
+	KeepAliveTime time.Duration
+	// RejectPolicy 读队列已满时的拒绝策略，默认阻塞
+	RejectPolicy RejectPolicy
 }
 
 const (
@@ -213,17 +221,83 @@ const (
 	MAX_READ_QUEUE_SIZE = 100
 	// 对应每个cpu的读线程池最大数
 	MAX_READ_POOL_EVERY_CPU = 1000
+	// 空闲工作协程默认存活时间，单位秒
+	READ_POOL_KEEPALIVE_TIME = 60
+	// 最大工作协程数的上限
+	MAX_READ_POOL_LIMIT = 1 << 20
+	// 每个队列读缓冲数的上限
+	MAX_READ_QUEUE_LIMIT = 1 << 20
 )
 
 // NewReadPoolConf 初始化资源池
+// maxReadPoolSize 最大工作协程数，<=0时取cpu数
+// maxReadQueueSize 每个channel读队列可缓冲包数，<=0时取MAX_READ_QUEUE_SIZE
 func NewReadPoolConf(maxReadPoolSize, maxReadQueueSize int) *ReadPoolConf {
+	if maxReadPoolSize <= 0 {
+		maxReadPoolSize = runtime.NumCPU()
+	}
+	if maxReadQueueSize <= 0 {
+		maxReadQueueSize = MAX_READ_QUEUE_SIZE
+	}
 	r := &ReadPoolConf{
 		MaxReadQueueSize: maxReadQueueSize,
 		MaxReadPoolSize:  maxReadPoolSize,
+		KeepAliveTime:    READ_POOL_KEEPALIVE_TIME * time.Second,
+		RejectPolicy:     REJECT_BLOCK,
 	}
 	return r
 }
 
+// Validate 校验配置，不修改配置，默认值由NewReadPoolConf设置，
+// 最大工作协程数和队列缓冲数需大于0且不超过上限，最少工作协程数不超过最大工作协程数
+func (conf *ReadPoolConf) Validate() error {
+	if conf.MaxReadPoolSize <= 0 || conf.MaxReadPoolSize > MAX_READ_POOL_LIMIT {
+		return fmt.Errorf("invalid MaxReadPoolSize:%v, should be in (0, %v]", conf.MaxReadPoolSize, MAX_READ_POOL_LIMIT)
+	}
+	if conf.MaxReadQueueSize <= 0 || conf.MaxReadQueueSize > MAX_READ_QUEUE_LIMIT {
+		return fmt.Errorf("invalid MaxReadQueueSize:%v, should be in (0, %v]", conf.MaxReadQueueSize, MAX_READ_QUEUE_LIMIT)
+	}
+	if conf.MinReadPoolSize > conf.MaxReadPoolSize {
+		return fmt.Errorf("invalid MinReadPoolSize:%v, should not exceed %v", conf.MinReadPoolSize, conf.MaxReadPoolSize)
+	}
+	if conf.RejectPolicy < REJECT_BLOCK || conf.RejectPolicy > REJECT_ABORT {
+		return fmt.Errorf("invalid RejectPolicy:%v", int(conf.RejectPolicy))
+	}
+	return nil
+}
+
+// GetMaxReadPoolSize 最大工作协程数
+func (conf *ReadPoolConf) GetMaxReadPoolSize() int {
+	return conf.MaxReadPoolSize
+}
+
+// GetMinReadPoolSize 最少工作协程数，不超过最大工作协程数
+func (conf *ReadPoolConf) GetMinReadPoolSize() int {
+	ret := conf.MinReadPoolSize
+	if ret < 0 {
+		ret = 0
+	}
+	max := conf.GetMaxReadPoolSize()
+	if ret > max {
+		ret = max
+	}
+	return ret
+}
+
+// GetMaxReadQueueSize 每个channel读队列可缓冲包数
+func (conf *ReadPoolConf) GetMaxReadQueueSize() int {
+	return conf.MaxReadQueueSize
+}
+
+// GetKeepAliveTime 空闲工作协程存活时间，<=0时取READ_POOL_KEEPALIVE_TIME秒
+func (conf *ReadPoolConf) GetKeepAliveTime() time.Duration {
+	ret := conf.KeepAliveTime
+	if ret <= 0 {
+		ret = READ_POOL_KEEPALIVE_TIME * time.Second
+	}
+	return ret
+}
+
 // globalChannelConf 全局的channel配置，当都没用初始化ChannelConf配置时，使用该配置
 var globalChannelConf *ChannelConf
 
@@ -238,10 +312,10 @@ func init() {
 	globalChannelConf = newGlobalChannelConf()
 	log.Println("init global channelConf:", globalChannelConf)
 
-	globalServerReadPoolConf = NewReadPoolConf(MAX_READ_QUEUE_SIZE, runtime.NumCPU()*MAX_READ_POOL_EVERY_CPU)
+	globalServerReadPoolConf = NewReadPoolConf(runtime.NumCPU()*MAX_READ_POOL_EVERY_CPU, MAX_READ_QUEUE_SIZE)
 	log.Println("init global server readPoolConf:", globalServerReadPoolConf)
 
-	globalClientReadPoolConf = NewReadPoolConf(MAX_READ_QUEUE_SIZE, runtime.NumCPU())
+	globalClientReadPoolConf = NewReadPoolConf(runtime.NumCPU(), MAX_READ_QUEUE_SIZE)
 	log.Println("init global client readPoolConf:", globalClientReadPoolConf)
 }
 
@@ -0,0 +1,52 @@
+/*
+ * Author:slive
+ * DATE:2020/7/25
+ */
+package channel
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestReadPoolConfValidate(t *testing.T) {
+	// 默认值由NewReadPoolConf设置，校验不修改配置
+	conf := NewReadPoolConf(0, 0)
+	origin := *conf
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("default conf should be valid, err:%v", err)
+	}
+	if *conf != origin {
+		t.Fatalf("validate should not modify conf:%+v", conf)
+	}
+	if conf.GetMaxReadPoolSize() != runtime.NumCPU() || conf.GetMaxReadQueueSize() != MAX_READ_QUEUE_SIZE ||
+		conf.GetMinReadPoolSize() != 0 || conf.GetKeepAliveTime() != READ_POOL_KEEPALIVE_TIME*time.Second {
+		t.Fatalf("unexpected default conf:%+v", conf)
+	}
+
+	// 存活时间按Duration原样使用
+	conf.KeepAliveTime = 30 * time.Second
+	if conf.GetKeepAliveTime() != 30*time.Second {
+		t.Fatalf("unexpected keep alive time:%v", conf.GetKeepAliveTime())
+	}
+
+	invalids := []*ReadPoolConf{
+		{},
+		{MaxReadPoolSize: 1},
+		{MaxReadQueueSize: 1},
+		{MaxReadPoolSize: -1, MaxReadQueueSize: 1},
+		{MaxReadPoolSize: MAX_READ_POOL_LIMIT + 1, MaxReadQueueSize: 1},
+		{MaxReadPoolSize: 1, MaxReadQueueSize: MAX_READ_QUEUE_LIMIT + 1},
+		{MaxReadPoolSize: 2, MaxReadQueueSize: 1, MinReadPoolSize: 3},
+		{MaxReadPoolSize: 1, MaxReadQueueSize: 1, RejectPolicy: REJECT_ABORT + 1},
+	}
+	for _, invalid := range invalids {
+		if invalid.Validate() == nil {
+			t.Fatalf("should be invalid:%+v", invalid)
+		}
+	}
+	if _, err := NewReadPoolByConf(&ReadPoolConf{}); err == nil {
+		t.Fatal("new read pool with zero size should fail.")
+	}
+}
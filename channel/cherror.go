@@ -0,0 +1,172 @@
+/*
+ * 收发相关的异常定义，写入失败时返回WriteError，可通过errors.Is判断异常类型，
+ * errors.As获取WriteError及原始异常，通知错误处理方法时统一转为带错误码和channel信息的GError
+ * Author:slive
+ * DATE:2020/8/7
+ */
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/slive/gsfly/common"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrWriteTimeout 写超时
+	ErrWriteTimeout = errors.New("write timeout")
+	// ErrChannelClosed channel或者连接已关闭
+	ErrChannelClosed = errors.New("channel closed")
+	// ErrPeerReset 对端重置或者已断开连接
+	ErrPeerReset = errors.New("connection reset by peer")
+	// ErrMsgTooLarge 消息超过协议允许的大小
+	ErrMsgTooLarge = errors.New("message too large")
+	// ErrNormalClosure 对端正常关闭(如ws的1000和1001关闭帧)，读协程直接结束并释放channel，不通知onError
+	ErrNormalClosure = errors.New("channel closed normally")
+)
+
+// WriteError 写入异常
+type WriteError struct {
+	// Kind 异常类型，为ErrWriteTimeout等之一，未能识别时为nil
+	Kind error
+	// ChId 对应的channel id
+	ChId string
+	// Network 协议类型
+	Network Network
+	// Err 原始异常
+	Err error
+}
+
+// NewWriteError 创建写入异常，根据原始异常自动识别异常类型
+// channel 对应的channel
+// err 原始异常
+func NewWriteError(channel IChannel, err error) *WriteError {
+	return NewWriteErrorByKind(channel, ClassifyWriteError(err), err)
+}
+
+// NewWriteErrorByKind 创建指定类型的写入异常
+// channel 对应的channel
+// kind 异常类型，如ErrMsgTooLarge
+// err 原始异常，可为nil
+func NewWriteErrorByKind(channel IChannel, kind error, err error) *WriteError {
+	writeErr, ok := err.(*WriteError)
+	if ok {
+		return writeErr
+	}
+	ret := &WriteError{Kind: kind, Err: err}
+	if channel != nil {
+		ret.ChId = channel.GetId()
+		ret.Network = channel.GetConf().GetNetwork()
+	}
+	return ret
+}
+
+func (e *WriteError) Error() string {
+	var msg string
+	if e.Kind != nil {
+		msg = e.Kind.Error()
+	} else {
+		msg = "write error"
+	}
+	if e.Err != nil && e.Err != e.Kind {
+		msg += ": " + e.Err.Error()
+	}
+	return fmt.Sprintf("%v, chId:%v, network:%v", msg, e.ChId, e.Network)
+}
+
+// Unwrap 获取原始异常
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// Is 判断异常类型，如errors.Is(err, ErrWriteTimeout)
+func (e *WriteError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// ClassifyWriteError 识别原始异常的类型，未能识别时返回nil
+func ClassifyWriteError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, kind := range []error{ErrWriteTimeout, ErrChannelClosed, ErrPeerReset, ErrMsgTooLarge} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrWriteTimeout
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ECONNABORTED):
+		return ErrPeerReset
+	case errors.Is(err, syscall.EMSGSIZE):
+		return ErrMsgTooLarge
+	case errors.Is(err, io.ErrClosedPipe), errors.Is(err, io.EOF):
+		return ErrChannelClosed
+	}
+	// go1.16之前没有net.ErrClosed，只能通过内容判断
+	if strings.Contains(err.Error(), "use of closed network connection") {
+		return ErrChannelClosed
+	}
+	return nil
+}
+
+// ClassifyErrCode 识别异常的错误码，错误链中有错误码的GError时为其错误码，超时为ERR_TIMEOUT，
+// 已关闭或者对端重置为ERR_CLOSED，读队列已满为ERR_OVERLOAD，未能识别时为defCode
+func ClassifyErrCode(err error, defCode string) string {
+	var gerr common.GError
+	if errors.As(err, &gerr) && len(gerr.GetErrCode()) > 0 {
+		return gerr.GetErrCode()
+	}
+	if errors.Is(err, ErrReadQueueFull) {
+		return ERR_OVERLOAD
+	}
+	switch ClassifyWriteError(err) {
+	case ErrWriteTimeout:
+		return ERR_TIMEOUT
+	case ErrChannelClosed, ErrPeerReset:
+		return ERR_CLOSED
+	}
+	return defCode
+}
+
+// ToChError 转为带channel信息的GError，错误码见ClassifyErrCode，并附加channel id和网络类型字段，
+// 返回新的GError，不修改原有的GError，原有的字段一并复制
+// channel 对应的channel
+// err 原始异常
+// defCode 未能识别时的错误码
+func ToChError(channel IChannel, err error, defCode string) common.GError {
+	cause := err
+	var gerr common.GError
+	found := errors.As(err, &gerr)
+	if found && gerr == err {
+		// 本身为GError时包装其原始异常，避免错误码重复
+		cause = gerr.GetErr()
+	}
+	ret := common.NewError1(ClassifyErrCode(err, defCode), cause)
+	if found {
+		for key, val := range gerr.GetFields() {
+			ret.WithField(key, val)
+		}
+	}
+	if channel != nil {
+		ret.WithField(common.Key_ChId, channel.GetId())
+		ret.WithField(common.Key_Network, channel.GetConf().GetNetwork().String())
+	}
+	return ret
+}
+
+// WriteErrorPolicy 写入异常时是否关闭channel的策略，返回true时关闭
+type WriteErrorPolicy func(channel IChannel, err error) bool
+
+// DefWriteErrorPolicy 默认策略，消息过大只丢弃该消息，其他异常都关闭channel
+func DefWriteErrorPolicy(channel IChannel, err error) bool {
+	return !errors.Is(err, ErrMsgTooLarge)
+}
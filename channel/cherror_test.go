@@ -0,0 +1,125 @@
+/*
+ * Author:slive
+ * DATE:2020/8/7
+ */
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/slive/gsfly/common"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestClassifyWriteError(t *testing.T) {
+	opErr := func(err error) error {
+		return &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", err)}
+	}
+	cases := []struct {
+		err  error
+		kind error
+	}{
+		{&net.OpError{Op: "write", Net: "tcp", Err: timeoutErr{}}, ErrWriteTimeout},
+		{opErr(syscall.ECONNRESET), ErrPeerReset},
+		{opErr(syscall.EPIPE), ErrPeerReset},
+		{opErr(syscall.EMSGSIZE), ErrMsgTooLarge},
+		{io.ErrClosedPipe, ErrChannelClosed},
+		{errors.New("write tcp 127.0.0.1:1->127.0.0.1:2: use of closed network connection"), ErrChannelClosed},
+		{errors.New("unknown"), nil},
+	}
+	for _, c := range cases {
+		kind := ClassifyWriteError(c.err)
+		if kind != c.kind {
+			t.Fatalf("classify %v, expect:%v, actual:%v", c.err, c.kind, kind)
+		}
+	}
+}
+
+func TestWriteErrorIsAs(t *testing.T) {
+	origin := &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ECONNRESET)}
+	var err error = NewWriteError(nil, origin)
+	if !errors.Is(err, ErrPeerReset) || errors.Is(err, ErrWriteTimeout) {
+		t.Fatalf("unexpected kind, err:%v", err)
+	}
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatal("should unwrap to origin error.")
+	}
+	var writeErr *WriteError
+	if !errors.As(err, &writeErr) || writeErr.Err != origin {
+		t.Fatal("should be WriteError.")
+	}
+
+	if DefWriteErrorPolicy(nil, err) != true {
+		t.Fatal("peer reset should close channel.")
+	}
+	if DefWriteErrorPolicy(nil, NewWriteErrorByKind(nil, ErrMsgTooLarge, nil)) != false {
+		t.Fatal("msg too large should not close channel.")
+	}
+}
+
+func TestNotifyErrorCode(t *testing.T) {
+	var gerr common.GError
+	handle := NewDefChHandle(func(ctx IChHandleContext) {})
+	handle.SetOnError(func(ctx IChHandleContext) {
+		gerr = ctx.GetError()
+	})
+	ch := newStateChannel(handle)
+	cases := []struct {
+		err  error
+		code string
+	}{
+		{NewWriteErrorByKind(ch, ErrWriteTimeout, nil), ERR_TIMEOUT},
+		{NewWriteError(ch, io.ErrClosedPipe), ERR_CLOSED},
+		{errors.New("unknown"), ERR_WRITE},
+		{common.NewError2(ERR_AUTH, "token expired"), ERR_AUTH},
+		{common.NewError0(errors.New("no code")), ERR_WRITE},
+		{fmt.Errorf("wrap:%w", common.NewError2(ERR_AUTH, "token expired")), ERR_AUTH},
+		{ErrReadQueueFull, ERR_OVERLOAD},
+	}
+	for _, c := range cases {
+		NotifyErrorHandle(NewChHandleContext(ch, nil), c.err, ERR_WRITE)
+		if gerr.GetErrCode() != c.code || !common.IsErrCode(gerr, c.code) {
+			t.Fatalf("%v expect code %v, but %v", c.err, c.code, gerr.GetErrCode())
+		}
+		if gerr.GetFields()[common.Key_ChId] != ch.GetId() || gerr.GetFields()[common.Key_Network] != ch.GetConf().GetNetwork().String() {
+			t.Fatalf("unexpected fields:%v", gerr.GetFields())
+		}
+	}
+	if ch.GetChStatis().GetErrorNums()[ERR_WRITE] != 2 {
+		t.Fatalf("unexpected error nums:%v", ch.GetChStatis().GetErrorNums())
+	}
+
+	// 原始异常可通过errors.Is/As获取
+	NotifyErrorHandle(NewChHandleContext(ch, nil), NewWriteErrorByKind(ch, ErrWriteTimeout, nil), ERR_WRITE)
+	var writeErr *WriteError
+	if !errors.Is(gerr, ErrWriteTimeout) || !errors.As(gerr, &writeErr) {
+		t.Fatalf("should unwrap to write error:%v", gerr)
+	}
+}
+
+func TestToChErrorNotModify(t *testing.T) {
+	ch := newStateChannel(NewDefChHandle(func(ctx IChHandleContext) {}))
+	src := common.NewError2(ERR_AUTH, "token expired")
+	src.WithField("user", "u1")
+	gerr := ToChError(ch, src, ERR_WRITE)
+	if gerr == src || len(src.GetFields()) != 1 {
+		t.Fatalf("should not modify source error, fields:%v", src.GetFields())
+	}
+	fields := gerr.GetFields()
+	if gerr.GetErrCode() != ERR_AUTH || fields["user"] != "u1" || fields[common.Key_ChId] != ch.GetId() {
+		t.Fatalf("unexpected error:%v, fields:%v", gerr, fields)
+	}
+	if gerr.GetErr() != src.GetErr() {
+		t.Fatalf("should wrap source cause:%v", gerr.GetErr())
+	}
+}
@@ -5,6 +5,7 @@
 package channel
 
 import (
+	"fmt"
 	"github.com/slive/gsfly/common"
 	logx "github.com/slive/gsfly/logger"
 )
@@ -119,6 +120,21 @@ type IChHandle interface {
 	GetOnError() ChHandleFunc
 	// SetOnError 设置错误后的处理方法
 	SetOnError(onError ChHandleFunc)
+
+	// GetWriteErrorPolicy 获取写入异常时是否关闭channel的策略
+	GetWriteErrorPolicy() WriteErrorPolicy
+	// SetWriteErrorPolicy 设置写入异常时是否关闭channel的策略，为nil时使用DefWriteErrorPolicy
+	SetWriteErrorPolicy(policy WriteErrorPolicy)
+
+	// GetOnStateChange 获取状态变更后的处理方法
+	GetOnStateChange() ChStateFunc
+	// SetOnStateChange 设置状态变更后的处理方法
+	SetOnStateChange(onStateChange ChStateFunc)
+
+	// GetTraceCodec 获取在包中传递traceparent的编解码
+	GetTraceCodec() ITraceCodec
+	// SetTraceCodec 设置在包中传递traceparent的编解码，为nil时使用AttachTraceCodec
+	SetTraceCodec(traceCodec ITraceCodec)
 }
 
 // ChHandle channel(通信通道)处理集，针对如开始，关闭和收到消息的方法
@@ -129,6 +145,43 @@ type ChHandle struct {
 	onRead      ChHandleFunc
 	preWrite    ChHandleFunc
 	onError     ChHandleFunc
+
+	writeErrorPolicy WriteErrorPolicy
+	onStateChange    ChStateFunc
+	traceCodec       ITraceCodec
+}
+
+// GetTraceCodec 获取在包中传递traceparent的编解码
+func (c *ChHandle) GetTraceCodec() ITraceCodec {
+	return c.traceCodec
+}
+
+// SetTraceCodec 设置在包中传递traceparent的编解码，为nil时使用AttachTraceCodec
+func (c *ChHandle) SetTraceCodec(traceCodec ITraceCodec) {
+	c.traceCodec = traceCodec
+}
+
+// GetOnStateChange 获取状态变更后的处理方法
+func (c *ChHandle) GetOnStateChange() ChStateFunc {
+	return c.onStateChange
+}
+
+// SetOnStateChange 设置状态变更后的处理方法
+func (c *ChHandle) SetOnStateChange(onStateChange ChStateFunc) {
+	c.onStateChange = onStateChange
+}
+
+// GetWriteErrorPolicy 获取写入异常时是否关闭channel的策略，未设置时为DefWriteErrorPolicy
+func (c *ChHandle) GetWriteErrorPolicy() WriteErrorPolicy {
+	if c.writeErrorPolicy == nil {
+		return DefWriteErrorPolicy
+	}
+	return c.writeErrorPolicy
+}
+
+// SetWriteErrorPolicy 设置写入异常时是否关闭channel的策略
+func (c *ChHandle) SetWriteErrorPolicy(policy WriteErrorPolicy) {
+	c.writeErrorPolicy = policy
 }
 
 // SetOnRead 设置读到数据后处理方法
@@ -204,11 +257,14 @@ func (c *ChHandle) onWapperReadHandler(ctx IChHandleContext) {
 	handleFunc := c.GetOnRead()
 	packet := ctx.GetPacket()
 	if handleFunc != nil {
-		handleFunc(ctx)
+		handleWithSpan(ctx, handleFunc)
 		err := ctx.GetError()
 		// 记录统计相关信息
 		if err != nil {
 			HandleMsgStatis(packet, false)
+			err = ToChError(ctx.GetChannel(), err, ERR_MSG)
+			ctx.SetError(err)
+			recordChError(ctx.GetChannel(), err.GetErrCode())
 			errHandler := c.GetOnError()
 			errHandler(ctx)
 		} else {
@@ -220,11 +276,34 @@ func (c *ChHandle) onWapperReadHandler(ctx IChHandleContext) {
 	}
 }
 
+// handleWithSpan 在处理的span中执行处理方法，结束时记录ctx中的错误，
+// 处理方法panic时同样结束span并记录该异常，之后继续抛出
+func handleWithSpan(ctx IChHandleContext, handleFunc ChHandleFunc) {
+	span := startHandleSpan(ctx)
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			endSpan(span, ctx.GetError())
+			return
+		}
+		err, ok := rec.(error)
+		if !ok {
+			err = fmt.Errorf("%v", rec)
+		}
+		endSpan(span, err)
+		panic(rec)
+	}()
+	handleFunc(ctx)
+}
+
 func CopyChHandle(handle IChHandle) *ChHandle {
 	newHandle := NewDefChHandle(handle.GetOnRead())
 	newHandle.SetOnConnect(handle.GetOnConnect())
 	newHandle.SetOnRelease(handle.GetOnRelease())
 	newHandle.SetOnError(handle.GetOnError())
 	newHandle.SetPreWrite(handle.GetPreWrite())
+	newHandle.SetWriteErrorPolicy(handle.GetWriteErrorPolicy())
+	newHandle.SetOnStateChange(handle.GetOnStateChange())
+	newHandle.SetTraceCodec(handle.GetTraceCodec())
 	return newHandle
 }
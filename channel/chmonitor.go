@@ -1,5 +1,6 @@
 /*
- * 监控收发信息等
+ * 监控收发信息等，统计数据都通过原子操作更新，可在收发协程中并发调用，
+ * 通过Snapshot获取某一时刻的快照
  * Author:slive
  * DATE:2020/7/29
  */
@@ -8,9 +9,30 @@ package channel
 import (
 	"encoding/json"
 	logx "github.com/slive/gsfly/logger"
+	"math"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
+const (
+	// STATIS_WINDOW_SIZE 统计速率的滑动窗口大小，单位秒
+	STATIS_WINDOW_SIZE = 10
+)
+
+// LATENCY_BUCKETS 耗时直方图的区间上限，超过最后一个区间的计入+Inf
+var LATENCY_BUCKETS = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
 type StatisUnit struct {
 	// 字节数
 	ByteNum   int64     `json:"byteNum"`
@@ -23,80 +45,443 @@ func newStatisUnit() *StatisUnit {
 	return &StatisUnit{Time: time.Now(), SpendTime: 0, IsOk: false, ByteNum: 0}
 }
 
+// rateBucket 滑动窗口中每一秒的统计
+type rateBucket struct {
+	sec       int64
+	byteNum   int64
+	packetNum int64
+}
+
+// RateWindow 按秒分桶的滑动窗口，统计最近STATIS_WINDOW_SIZE秒的收发速率，
+// 换桶时并发写入的少量数据可能丢失，结果为近似值
+type RateWindow struct {
+	buckets [STATIS_WINDOW_SIZE]rateBucket
+}
+
+// Add 记录一次收发
+func (w *RateWindow) Add(now time.Time, byteNum int64) {
+	sec := now.Unix()
+	bucket := &w.buckets[sec%STATIS_WINDOW_SIZE]
+	old := atomic.LoadInt64(&bucket.sec)
+	if old != sec && atomic.CompareAndSwapInt64(&bucket.sec, old, sec) {
+		// 过期的桶，重置后复用
+		atomic.StoreInt64(&bucket.byteNum, 0)
+		atomic.StoreInt64(&bucket.packetNum, 0)
+	}
+	atomic.AddInt64(&bucket.byteNum, byteNum)
+	atomic.AddInt64(&bucket.packetNum, 1)
+}
+
+// Rate 获取最近STATIS_WINDOW_SIZE秒(不含当前秒)的平均速率
+// 返回每秒字节数和每秒包数
+func (w *RateWindow) Rate(now time.Time) (byteRate float64, packetRate float64) {
+	sec := now.Unix()
+	var byteNum, packetNum int64
+	for i := range w.buckets {
+		bucket := &w.buckets[i]
+		bsec := atomic.LoadInt64(&bucket.sec)
+		if bsec < sec && bsec >= sec-STATIS_WINDOW_SIZE {
+			byteNum += atomic.LoadInt64(&bucket.byteNum)
+			packetNum += atomic.LoadInt64(&bucket.packetNum)
+		}
+	}
+	return float64(byteNum) / STATIS_WINDOW_SIZE, float64(packetNum) / STATIS_WINDOW_SIZE
+}
+
+// LatencyHistogram 耗时直方图，区间见LATENCY_BUCKETS
+type LatencyHistogram struct {
+	// 最后一个为+Inf
+	counts [len(LATENCY_BUCKETS) + 1]int64
+	count  int64
+	// 总耗时，单位纳秒
+	sum int64
+}
+
+// Observe 记录一次耗时
+func (h *LatencyHistogram) Observe(spend time.Duration) {
+	index := len(LATENCY_BUCKETS)
+	for i, bound := range LATENCY_BUCKETS {
+		if spend <= bound {
+			index = i
+			break
+		}
+	}
+	atomic.AddInt64(&h.counts[index], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(spend))
+}
+
+// Snapshot 获取直方图快照
+func (h *LatencyHistogram) Snapshot() HistogramSnapshot {
+	snapshot := HistogramSnapshot{
+		Bounds: make([]float64, len(LATENCY_BUCKETS)),
+		Counts: make([]int64, len(h.counts)),
+		Count:  atomic.LoadInt64(&h.count),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum)).Seconds(),
+	}
+	for i, bound := range LATENCY_BUCKETS {
+		snapshot.Bounds[i] = bound.Seconds()
+	}
+	for i := range h.counts {
+		snapshot.Counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	return snapshot
+}
+
+// HistogramSnapshot 直方图快照，耗时单位为秒
+type HistogramSnapshot struct {
+	// Bounds 各区间上限，Counts比Bounds多一个+Inf区间
+	Bounds []float64 `json:"bounds"`
+	// Counts 各区间(非累计)的次数
+	Counts []int64 `json:"counts"`
+	// Count 总次数
+	Count int64 `json:"count"`
+	// Sum 总耗时
+	Sum float64 `json:"sum"`
+}
+
+// Quantile 按区间估算分位数，如0.99，取第ceil(q*Count)(最少为1)次所在区间的上限，
+// 落在+Inf区间时返回最后一个区间上限
+func (h HistogramSnapshot) Quantile(q float64) float64 {
+	if h.Count <= 0 || len(h.Bounds) <= 0 {
+		return 0
+	}
+	// 减去误差，避免如0.07*100=7.000000000000001向上取整为8
+	rank := int64(math.Ceil(q*float64(h.Count) - 1e-9))
+	if rank < 1 {
+		rank = 1
+	}
+	var acc int64
+	for i, count := range h.Counts {
+		acc += count
+		if acc >= rank && i < len(h.Bounds) {
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
 // Statis 统计相关收发包
 type Statis struct {
 	// 总字节数
-	TotalByteNum int64 `json:"totalByteNum"`
+	totalByteNum int64
 	// 总包数
-	TotalPacketNum int64 `json:"totalPacketNum"`
-
-	// 当前操作记录
-	Current *StatisUnit `json:"current"`
-	// 上次操作记录
-	Last *StatisUnit `json:"last"`
-
+	totalPacketNum int64
 	// 总失败字节数
-	TotalFailByteNum int64 `json:"totalFailByteNum"`
+	totalFailByteNum int64
 	// 总失败包数
-	TotalFailPacketNum int64 `json:"totalFailPacketNum"`
-
+	totalFailPacketNum int64
 	// 连续失败次数
-	FailTimes int64 `json:"failTimes"`
+	failTimes int64
+
+	// 当前操作记录，*StatisUnit
+	current unsafe.Pointer
+	// 上次操作记录，*StatisUnit
+	last unsafe.Pointer
+
+	window  RateWindow
+	latency LatencyHistogram
+
+	// 汇总的统计，如所属ServerSocket和协议类型的统计
+	aggs []*Statis
 }
 
 func newStatis() *Statis {
 	return &Statis{
-		TotalByteNum:       0,
-		TotalPacketNum:     0,
-		Current:            newStatisUnit(),
-		Last:               newStatisUnit(),
-		TotalFailByteNum:   0,
-		TotalFailPacketNum: 0,
-		FailTimes:          0,
+		current: unsafe.Pointer(newStatisUnit()),
+		last:    unsafe.Pointer(newStatisUnit()),
+	}
+}
+
+// StatisSnapshot 统计快照
+type StatisSnapshot struct {
+	TotalByteNum       int64             `json:"totalByteNum"`
+	TotalPacketNum     int64             `json:"totalPacketNum"`
+	TotalFailByteNum   int64             `json:"totalFailByteNum"`
+	TotalFailPacketNum int64             `json:"totalFailPacketNum"`
+	FailTimes          int64             `json:"failTimes"`
+	Current            StatisUnit        `json:"current"`
+	Last               StatisUnit        `json:"last"`
+	ByteRate           float64           `json:"byteRate"`
+	PacketRate         float64           `json:"packetRate"`
+	Latency            HistogramSnapshot `json:"latency"`
+}
+
+// GetTotalByteNum 获取总字节数
+func (s *Statis) GetTotalByteNum() int64 {
+	return atomic.LoadInt64(&s.totalByteNum)
+}
+
+// GetTotalPacketNum 获取总包数
+func (s *Statis) GetTotalPacketNum() int64 {
+	return atomic.LoadInt64(&s.totalPacketNum)
+}
+
+// GetTotalFailByteNum 获取总失败字节数
+func (s *Statis) GetTotalFailByteNum() int64 {
+	return atomic.LoadInt64(&s.totalFailByteNum)
+}
+
+// GetTotalFailPacketNum 获取总失败包数
+func (s *Statis) GetTotalFailPacketNum() int64 {
+	return atomic.LoadInt64(&s.totalFailPacketNum)
+}
+
+// GetFailTimes 获取连续失败次数
+func (s *Statis) GetFailTimes() int64 {
+	return atomic.LoadInt64(&s.failTimes)
+}
+
+// GetCurrent 获取当前操作记录
+func (s *Statis) GetCurrent() StatisUnit {
+	return *(*StatisUnit)(atomic.LoadPointer(&s.current))
+}
+
+// GetLast 获取上次操作记录
+func (s *Statis) GetLast() StatisUnit {
+	return *(*StatisUnit)(atomic.LoadPointer(&s.last))
+}
+
+// GetRate 获取最近的每秒字节数和每秒包数
+func (s *Statis) GetRate() (byteRate float64, packetRate float64) {
+	return s.window.Rate(time.Now())
+}
+
+// GetLatency 获取耗时直方图
+func (s *Statis) GetLatency() *LatencyHistogram {
+	return &s.latency
+}
+
+// Snapshot 获取统计快照
+func (s *Statis) Snapshot() StatisSnapshot {
+	byteRate, packetRate := s.GetRate()
+	return StatisSnapshot{
+		TotalByteNum:       s.GetTotalByteNum(),
+		TotalPacketNum:     s.GetTotalPacketNum(),
+		TotalFailByteNum:   s.GetTotalFailByteNum(),
+		TotalFailPacketNum: s.GetTotalFailPacketNum(),
+		FailTimes:          s.GetFailTimes(),
+		Current:            s.GetCurrent(),
+		Last:               s.GetLast(),
+		ByteRate:           byteRate,
+		PacketRate:         packetRate,
+		Latency:            s.latency.Snapshot(),
+	}
+}
+
+// String 实现fmt.Stringer，日志中输出时才格式化
+func (s *Statis) String() string {
+	return s.ToString()
+}
+
+// MarshalJSON 按快照输出，字段与StatisSnapshot一致
+func (s *Statis) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Snapshot())
+}
+
+func (s *Statis) ToString() string {
+	marshal, err := json.Marshal(s.Snapshot())
+	if err == nil {
+		return string(marshal)
+	}
+	return ""
+}
+
+// record 记录一次操作，包括汇总的统计
+// dataLen 字节数，失败的读取为0
+// spend 耗时
+// isOk 是否成功
+// counted 是否计入总包数等计数，读取失败时只记录失败次数
+func (s *Statis) record(now time.Time, dataLen int64, spend time.Duration, isOk bool, counted bool) {
+	unit := &StatisUnit{Time: now, SpendTime: spend.Seconds(), IsOk: isOk}
+	if isOk {
+		unit.ByteNum = dataLen
+	}
+	s.recordUnit(unit, dataLen, spend, counted)
+}
+
+// recordUnit 记录一次操作，unit创建后不再修改，汇总的统计共用同一个unit
+func (s *Statis) recordUnit(unit *StatisUnit, dataLen int64, spend time.Duration, counted bool) {
+	now, isOk := unit.Time, unit.IsOk
+	old := atomic.SwapPointer(&s.current, unsafe.Pointer(unit))
+	atomic.StorePointer(&s.last, old)
+
+	if counted {
+		atomic.AddInt64(&s.totalByteNum, dataLen)
+		atomic.AddInt64(&s.totalPacketNum, 1)
+		s.latency.Observe(spend)
+	}
+	if isOk {
+		atomic.StoreInt64(&s.failTimes, 0)
+		s.window.Add(now, dataLen)
+	} else {
+		if counted {
+			atomic.AddInt64(&s.totalFailByteNum, dataLen)
+			atomic.AddInt64(&s.totalFailPacketNum, 1)
+		}
+		atomic.AddInt64(&s.failTimes, 1)
+	}
+
+	for _, agg := range s.aggs {
+		agg.recordUnit(unit, dataLen, spend, counted)
 	}
 }
 
 // ChannelStatis 统计相关，比如收发包数目，收发次数
 type ChannelStatis struct {
-	SendStatics      *Statis `json:"send"`
-	RevStatics       *Statis `json:"rev"`
-	HandleMsgStatics *Statis `json:"handleMsg"`
+	SendStatics      *Statis
+	RevStatics       *Statis
+	HandleMsgStatics *Statis
+
+	// 变为Active的channel数
+	openNum int64
+	// 由Active开始关闭的channel数
+	closeNum int64
+	// 错误码对应的错误数，*int64
+	errorNums sync.Map
+
+	// 汇总的统计
+	aggs []*ChannelStatis
 }
 
 // NewChStatis 新建channel统计
-func NewChStatis() *ChannelStatis {
-	return &ChannelStatis{
+// aggs 汇总的统计，channel的统计同时计入这些统计中
+func NewChStatis(aggs ...*ChannelStatis) *ChannelStatis {
+	s := &ChannelStatis{
 		SendStatics:      newStatis(),
 		RevStatics:       newStatis(),
 		HandleMsgStatics: newStatis(),
 	}
+	for _, agg := range aggs {
+		if agg == nil {
+			continue
+		}
+		s.SendStatics.aggs = append(s.SendStatics.aggs, agg.SendStatics)
+		s.RevStatics.aggs = append(s.RevStatics.aggs, agg.RevStatics)
+		s.HandleMsgStatics.aggs = append(s.HandleMsgStatics.aggs, agg.HandleMsgStatics)
+		s.aggs = append(s.aggs, agg)
+	}
+	return s
 }
 
-func (s *Statis) ToString() string {
-	marshal, err := json.Marshal(s)
-	if err == nil {
-		return string(marshal)
+// GetOpenNum 获取已打开(变为Active)的channel数
+func (s *ChannelStatis) GetOpenNum() int64 {
+	return atomic.LoadInt64(&s.openNum)
+}
+
+// GetCloseNum 获取已关闭的channel数，只统计打开过的
+func (s *ChannelStatis) GetCloseNum() int64 {
+	return atomic.LoadInt64(&s.closeNum)
+}
+
+// GetActiveNum 获取当前Active的channel数
+func (s *ChannelStatis) GetActiveNum() int64 {
+	return s.GetOpenNum() - s.GetCloseNum()
+}
+
+// GetErrorNums 获取各错误码对应的错误数
+func (s *ChannelStatis) GetErrorNums() map[string]int64 {
+	ret := make(map[string]int64)
+	s.errorNums.Range(func(key, value interface{}) bool {
+		ret[key.(string)] = atomic.LoadInt64(value.(*int64))
+		return true
+	})
+	return ret
+}
+
+// MarshalJSON 按快照输出，字段与ChannelStatisSnapshot一致
+func (s *ChannelStatis) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Snapshot())
+}
+
+// recordState 记录状态变更，包括汇总的统计
+func (s *ChannelStatis) recordState(oldState ChState, newState ChState) {
+	if newState == CH_STATE_ACTIVE {
+		atomic.AddInt64(&s.openNum, 1)
+	} else if oldState == CH_STATE_ACTIVE && newState == CH_STATE_CLOSING {
+		atomic.AddInt64(&s.closeNum, 1)
+	}
+	for _, agg := range s.aggs {
+		agg.recordState(oldState, newState)
+	}
+}
+
+// recordError 记录错误码，包括汇总的统计
+func (s *ChannelStatis) recordError(errCode string) {
+	num, ok := s.errorNums.Load(errCode)
+	if !ok {
+		num, _ = s.errorNums.LoadOrStore(errCode, new(int64))
+	}
+	atomic.AddInt64(num.(*int64), 1)
+	for _, agg := range s.aggs {
+		agg.recordError(errCode)
+	}
+}
+
+// ChannelStatisSnapshot channel统计快照
+type ChannelStatisSnapshot struct {
+	Send      StatisSnapshot   `json:"send"`
+	Rev       StatisSnapshot   `json:"rev"`
+	HandleMsg StatisSnapshot   `json:"handleMsg"`
+	OpenNum   int64            `json:"openNum"`
+	CloseNum  int64            `json:"closeNum"`
+	ErrorNums map[string]int64 `json:"errorNums"`
+}
+
+// Snapshot 获取channel统计快照
+func (s *ChannelStatis) Snapshot() ChannelStatisSnapshot {
+	return ChannelStatisSnapshot{
+		Send:      s.SendStatics.Snapshot(),
+		Rev:       s.RevStatics.Snapshot(),
+		HandleMsg: s.HandleMsgStatics.Snapshot(),
+		OpenNum:   s.GetOpenNum(),
+		CloseNum:  s.GetCloseNum(),
+		ErrorNums: s.GetErrorNums(),
 	}
-	return ""
 }
 
-func copyStaticunit(current *StatisUnit, last *StatisUnit) {
-	last.Time = current.Time
-	last.IsOk = current.IsOk
-	last.SpendTime = current.SpendTime
-	last.ByteNum = current.ByteNum
+// IChStatisHolder 统计持有者，创建channel时父节点实现该接口，则channel的统计同时汇总到父节点，
+// 如ServerSocket汇总其所有channel的统计
+type IChStatisHolder interface {
+	GetChStatis() *ChannelStatis
+}
+
+// networkStatis 按协议类型汇总的统计
+var networkStatis sync.Map
+
+// GetNetworkStatis 获取某协议类型汇总的统计
+func GetNetworkStatis(network Network) *ChannelStatis {
+	statis, ok := networkStatis.Load(network)
+	if !ok {
+		statis, _ = networkStatis.LoadOrStore(network, NewChStatis())
+	}
+	return statis.(*ChannelStatis)
+}
+
+// GetNetworkStatisSnapshots 获取所有协议类型汇总的统计快照
+func GetNetworkStatisSnapshots() map[Network]ChannelStatisSnapshot {
+	snapshots := make(map[Network]ChannelStatisSnapshot)
+	networkStatis.Range(func(key, value interface{}) bool {
+		snapshots[key.(Network)] = value.(*ChannelStatis).Snapshot()
+		return true
+	})
+	return snapshots
+}
+
+// recordChError 记录channel的错误码
+func recordChError(channel IChannel, errCode string) {
+	chStatis := channel.GetChStatis()
+	if chStatis != nil {
+		chStatis.recordError(errCode)
+	}
 }
 
 // RevStatisFail 度统计失败
 func RevStatisFail(channel IChannel, initTime time.Time) {
 	statis := channel.GetChStatis().RevStatics
-	copyStaticunit(statis.Current, statis.Last)
-	statis.Current.IsOk = false
 	now := time.Now()
-	statis.Current.Time = now
-	statis.Current.SpendTime = time.Since(initTime).Seconds()
-	statis.FailTimes += 1
-	logx.InfoTracef(channel, "receive fail statis:%v", statis.ToString())
+	statis.record(now, 0, now.Sub(initTime), false, false)
+	logx.SampleInfoTracef(logx.GetHotPathSampler(), "receive fail statis", channel, "receive fail statis:%v", statis)
 }
 
 // RevStatis 读取统计
@@ -104,30 +489,16 @@ func RevStatis(packet IPacket, isOk bool) {
 	channel := packet.GetChannel()
 	statis := channel.GetChStatis().RevStatics
 	handleStatis(statis, packet, isOk)
-	if logx.IsDebug() {
+	if logx.IsDebugTrace(packet) {
 		logx.DebugTracef(packet, "receive msg:%v", string(packet.GetData()))
 	}
-	logx.InfoTracef(packet, "receive statis:%v", statis.ToString())
+	logx.SampleInfoTracef(logx.GetHotPathSampler(), "receive statis", packet, "receive statis:%v", statis)
 }
 
 // handleStatis 通用的统计
 func handleStatis(statis *Statis, packet IPacket, isOk bool) {
-	copyStaticunit(statis.Current, statis.Last)
-	dataLen := int64(len(packet.GetData()))
-	statis.TotalByteNum += dataLen
-	statis.TotalPacketNum += 1
-	statis.Current.IsOk = isOk
 	now := time.Now()
-	statis.Current.Time = now
-	statis.Current.SpendTime = time.Since(packet.GetInitTime()).Seconds()
-	if !isOk {
-		statis.TotalFailByteNum += dataLen
-		statis.TotalFailPacketNum += 1
-		statis.FailTimes += 1
-	} else {
-		statis.FailTimes = 0
-		statis.Current.ByteNum += dataLen
-	}
+	statis.record(now, int64(len(packet.GetData())), now.Sub(packet.GetInitTime()), isOk, true)
 }
 
 // SendStatis 写统计
@@ -135,10 +506,10 @@ func SendStatis(packet IPacket, isOk bool) {
 	channel := packet.GetChannel()
 	statis := channel.GetChStatis().SendStatics
 	handleStatis(statis, packet, isOk)
-	if logx.IsDebug() {
+	if logx.IsDebugTrace(packet) {
 		logx.DebugTracef(packet, "write msg:%v", string(packet.GetData()))
 	}
-	logx.InfoTracef(packet, "write statis:%v", statis.ToString())
+	logx.SampleInfoTracef(logx.GetHotPathSampler(), "write statis", packet, "write statis:%v", statis)
 }
 
 // HandleMsgStatis 读统计
@@ -146,8 +517,8 @@ func HandleMsgStatis(packet IPacket, isOk bool) {
 	channel := packet.GetChannel()
 	statis := channel.GetChStatis().HandleMsgStatics
 	handleStatis(statis, packet, isOk)
-	if logx.IsDebug() {
+	if logx.IsDebugTrace(packet) {
 		logx.DebugTracef(packet, "handle msg:%v", string(packet.GetData()))
 	}
-	logx.InfoTracef(packet, " handle statis:%v", statis.ToString())
+	logx.SampleInfoTracef(logx.GetHotPathSampler(), "handle statis", packet, "handle statis:%v", statis)
 }
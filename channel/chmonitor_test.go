@@ -0,0 +1,138 @@
+/*
+ * Author:slive
+ * DATE:2020/7/29
+ */
+package channel
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatisConcurrentAggregate(t *testing.T) {
+	agg := NewChStatis()
+	network := GetNetworkStatis(NETWORK_KCP)
+	base := network.SendStatics.GetTotalPacketNum()
+	chStatis := NewChStatis(agg, nil, network)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				now := time.Now()
+				chStatis.SendStatics.record(now, 10, time.Millisecond, j%10 != 0, true)
+				chStatis.SendStatics.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+
+	snapshot := chStatis.Snapshot().Send
+	if snapshot.TotalPacketNum != 800 || snapshot.TotalByteNum != 8000 {
+		t.Fatalf("unexpected total, packetNum:%v, byteNum:%v", snapshot.TotalPacketNum, snapshot.TotalByteNum)
+	}
+	if snapshot.TotalFailPacketNum != 80 || snapshot.TotalFailByteNum != 800 {
+		t.Fatalf("unexpected fail total, packetNum:%v, byteNum:%v", snapshot.TotalFailPacketNum, snapshot.TotalFailByteNum)
+	}
+	if snapshot.Latency.Count != 800 || snapshot.Latency.Counts[0] != 800 {
+		t.Fatalf("unexpected latency:%+v", snapshot.Latency)
+	}
+	if agg.SendStatics.GetTotalPacketNum() != 800 {
+		t.Fatalf("unexpected aggregate packetNum:%v", agg.SendStatics.GetTotalPacketNum())
+	}
+	if network.SendStatics.GetTotalPacketNum()-base != 800 {
+		t.Fatalf("unexpected network packetNum:%v", network.SendStatics.GetTotalPacketNum()-base)
+	}
+	if _, ok := GetNetworkStatisSnapshots()[NETWORK_KCP]; !ok {
+		t.Fatal("network snapshot should exist.")
+	}
+}
+
+func TestStatisMarshalJSON(t *testing.T) {
+	agg := NewChStatis()
+	chStatis := NewChStatis(agg)
+	chStatis.SendStatics.record(time.Now(), 10, time.Millisecond, true, true)
+	chStatis.recordError(ERR_WRITE)
+	// 汇总的统计与channel的统计共用同一条操作记录
+	if chStatis.SendStatics.current != agg.SendStatics.current {
+		t.Fatal("aggregate should share the statis unit.")
+	}
+
+	data, err := json.Marshal(chStatis)
+	if err != nil {
+		t.Fatalf("marshal error:%v", err)
+	}
+	var ret ChannelStatisSnapshot
+	if err := json.Unmarshal(data, &ret); err != nil {
+		t.Fatalf("unmarshal error:%v", err)
+	}
+	if ret.Send.TotalByteNum != 10 || ret.Send.TotalPacketNum != 1 || ret.ErrorNums[ERR_WRITE] != 1 {
+		t.Fatalf("unexpected json:%v", string(data))
+	}
+	data, _ = json.Marshal(chStatis.SendStatics)
+	if string(data) != chStatis.SendStatics.ToString() {
+		t.Fatalf("unexpected statis json:%v", string(data))
+	}
+}
+
+func TestRateWindow(t *testing.T) {
+	w := &RateWindow{}
+	now := time.Unix(1000, 0)
+	for i := 0; i < STATIS_WINDOW_SIZE; i++ {
+		w.Add(now.Add(time.Duration(i)*time.Second), 100)
+	}
+	// 当前秒不计入
+	byteRate, packetRate := w.Rate(now.Add(STATIS_WINDOW_SIZE * time.Second))
+	if byteRate != 100 || packetRate != 1 {
+		t.Fatalf("unexpected rate, byteRate:%v, packetRate:%v", byteRate, packetRate)
+	}
+	// 过期的桶不计入
+	byteRate, _ = w.Rate(now.Add(3 * STATIS_WINDOW_SIZE * time.Second))
+	if byteRate != 0 {
+		t.Fatalf("expired rate should be 0, actual:%v", byteRate)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	h := &LatencyHistogram{}
+	for i := 0; i < 90; i++ {
+		h.Observe(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.Observe(time.Minute)
+	}
+	snapshot := h.Snapshot()
+	if snapshot.Quantile(0.5) != time.Millisecond.Seconds() {
+		t.Fatalf("unexpected p50:%v", snapshot.Quantile(0.5))
+	}
+	if snapshot.Counts[len(snapshot.Counts)-1] != 10 {
+		t.Fatalf("unexpected +Inf count:%v", snapshot.Counts)
+	}
+	// 第90次仍在1ms区间，第91次落在+Inf区间
+	if snapshot.Quantile(0.9) != time.Millisecond.Seconds() || snapshot.Quantile(0.91) != snapshot.Bounds[len(snapshot.Bounds)-1] {
+		t.Fatalf("unexpected p90:%v, p91:%v", snapshot.Quantile(0.9), snapshot.Quantile(0.91))
+	}
+
+	// 向上取整，3次中的第2次在5ms区间
+	h = &LatencyHistogram{}
+	h.Observe(time.Millisecond)
+	h.Observe(3 * time.Millisecond)
+	h.Observe(3 * time.Millisecond)
+	snapshot = h.Snapshot()
+	if snapshot.Quantile(0.5) != (5 * time.Millisecond).Seconds() {
+		t.Fatalf("p50 should round up, actual:%v", snapshot.Quantile(0.5))
+	}
+	// 最少取第1次，跳过前面为空的区间
+	h = &LatencyHistogram{}
+	h.Observe(3 * time.Millisecond)
+	if q := h.Snapshot().Quantile(0); q != (5 * time.Millisecond).Seconds() {
+		t.Fatalf("p0 should be the first observed bucket, actual:%v", q)
+	}
+	if (HistogramSnapshot{}).Quantile(0.5) != 0 {
+		t.Fatal("empty snapshot should return 0.")
+	}
+}
@@ -21,6 +21,8 @@ const (
 	NETWORK_WS      Network = "ws"
 	NETWORK_UDP     Network = "udp"
 	NETWORK_KCP     Network = "kcp"
+	NETWORK_SSE     Network = "sse"
+	NETWORK_POLL    Network = "poll"
 	NETWORK_UNKNOWN Network = ""
 )
 
@@ -37,6 +39,10 @@ func (p Network) String() string {
 		return "udp"
 	case NETWORK_KCP:
 		return "kcp"
+	case NETWORK_SSE:
+		return "sse"
+	case NETWORK_POLL:
+		return "poll"
 	default:
 		return "unknown"
 	}
@@ -54,6 +60,10 @@ func ToNetwork(network string) Network {
 		return NETWORK_UDP
 	case "kcp":
 		return NETWORK_KCP
+	case "sse":
+		return NETWORK_SSE
+	case "poll":
+		return NETWORK_POLL
 	default:
 		return NETWORK_UNKNOWN
 	}
@@ -67,9 +77,13 @@ type IPacket interface {
 	// IsPrepare 是否准备好可以进行收发后续处理
 	IsPrepare() bool
 
-	// 释放资源
+	// 释放资源，数据来自缓冲池时减少引用计数，为0时归还缓冲池，
+	// 读取的包在处理完后会自动释放
 	Release()
 
+	// Retain 增加数据缓冲的引用计数，处理完后仍需使用数据时调用，需对应调用Release
+	Retain()
+
 	// 是否释放
 	IsRelease() bool
 
@@ -85,6 +99,9 @@ type IPacket interface {
 	// SetData 设置收发数据
 	SetData(data []byte)
 
+	// SetBuf 设置来自缓冲池的数据
+	SetBuf(buf *ByteBuf)
+
 	// GetInitTime 初始化时间
 	GetInitTime() time.Time
 
@@ -98,6 +115,7 @@ type Packet struct {
 	channel  IChannel
 	network  Network
 	data     []byte
+	buf      *ByteBuf
 	initTime time.Time
 	common.Attact
 	common.RunContext
@@ -108,9 +126,26 @@ func (packet *Packet) GetChannel() IChannel {
 	return packet.channel
 }
 
-// Release 释放资源
+// Release 释放资源，数据缓冲仍被引用时不释放
 func (packet *Packet) Release() {
+	buf := packet.buf
+	if buf != nil {
+		if buf.Release() > 0 {
+			return
+		}
+		packet.buf = nil
+	}
 	packet.data = nil
+	// 释放SetDeadline产生的资源
+	packet.Cancel()
+}
+
+// Retain 增加数据缓冲的引用计数
+func (packet *Packet) Retain() {
+	buf := packet.buf
+	if buf != nil {
+		buf.Retain()
+	}
 }
 
 // IsRelease 是否以释放
@@ -138,6 +173,12 @@ func (packet *Packet) SetData(data []byte) {
 	packet.data = data
 }
 
+// SetBuf 设置来自缓冲池的数据，由packet负责释放
+func (packet *Packet) SetBuf(buf *ByteBuf) {
+	packet.buf = buf
+	packet.data = buf.Bytes()
+}
+
 // IsPrepare 数据是否已准备好
 func (packet *Packet) IsPrepare() bool {
 	return len(packet.data) > 0
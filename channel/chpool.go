@@ -1,147 +1,641 @@
 /*
  * 协程池管理类
+ * 每个channel对应一个有序的读队列，同一个channel的包按顺序处理，不同channel之间互不阻塞；
+ * 有待处理包的队列放入就绪列表，由弹性伸缩的工作协程(最少minReadPoolSize，最多maxReadPoolSize)获取处理，
+ * 空闲超过keepAliveTime的多余工作协程自动退出。
  * Author:slive
  * DATE:2020/7/25
  */
 package channel
 
 import (
+	"errors"
 	logx "github.com/slive/gsfly/logger"
-	"hash/crc32"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
+	"time"
 )
 
-// ReadQueue 每个协程可缓冲的读队列
+// RejectPolicy 读队列已满时的拒绝策略
+type RejectPolicy int
+
+const (
+	// REJECT_BLOCK 阻塞读协程，直到队列有空间
+	REJECT_BLOCK RejectPolicy = iota
+	// REJECT_DROP_NEWEST 丢弃新到的包
+	REJECT_DROP_NEWEST
+	// REJECT_DROP_OLDEST 丢弃队列中最早的包
+	REJECT_DROP_OLDEST
+	// REJECT_CALLER_RUNS 由读协程接管队列直接处理，先处理完队列中已有的包，保证有序
+	REJECT_CALLER_RUNS
+	// REJECT_ABORT 丢弃新到的包并返回错误
+	REJECT_ABORT
+)
+
+func (p RejectPolicy) String() string {
+	switch p {
+	case REJECT_BLOCK:
+		return "block"
+	case REJECT_DROP_NEWEST:
+		return "dropNewest"
+	case REJECT_DROP_OLDEST:
+		return "dropOldest"
+	case REJECT_CALLER_RUNS:
+		return "callerRuns"
+	case REJECT_ABORT:
+		return "abort"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// READ_POOL_BATCH_SIZE 工作协程每次从一个队列连续处理的最大包数，避免单个channel长期占用
+	READ_POOL_BATCH_SIZE = 32
+)
+
+var (
+	// ErrReadPoolClosed 读协程池已关闭
+	ErrReadPoolClosed = errors.New("read pool had closed")
+	// ErrReadQueueFull 读队列已满，包被丢弃
+	ErrReadQueueFull = errors.New("read queue is full")
+)
+
+// readItem 队列中的包及入队时间
+type readItem struct {
+	packet IPacket
+	time   time.Time
+}
+
+// ReadQueue 每个channel对应的有序读队列
 type ReadQueue struct {
-	readChan chan IPacket
-	id       int
+	id    string
+	items []readItem
+	// 是否已在就绪列表中或正在被处理，保证同一时刻只有一个工作协程处理
+	scheduled bool
+	// 是否正在被工作协程或者读协程处理
+	running bool
+	// 等待接管队列的读协程数，大于0时工作协程处理完当前包后让出队列
+	takeover int
+	// 对应的channel已释放，处理完后移除
+	removed bool
+	// 移除后执行的回调
+	onRemoved []func()
+
+	// 统计
+	totalNum   int64
+	handledNum int64
+	dropNum    int64
+	totalWait  time.Duration
+	maxWait    time.Duration
+}
+
+// ReadQueueStatis 读队列统计
+type ReadQueueStatis struct {
+	Id string
+	// 当前待处理包数
+	Depth int
+	// 入队总数
+	TotalNum int64
+	// 已处理数
+	HandledNum int64
+	// 丢弃数
+	DropNum int64
+	// 平均排队时间
+	AvgWait time.Duration
+	// 最大排队时间
+	MaxWait time.Duration
+}
+
+// ReadPoolStatis 读协程池统计
+type ReadPoolStatis struct {
+	// 工作协程数
+	WorkerNum int
+	// 空闲的工作协程数
+	IdleNum int
+	// 队列数
+	QueueNum int
+	// 所有队列待处理包数
+	Depth int
+	// 入队总数
+	TotalNum int64
+	// 已处理数
+	HandledNum int64
+	// 丢弃数
+	DropNum int64
+	// 拒绝策略生效的次数
+	RejectNum int64
+	// 平均排队时间
+	AvgWait time.Duration
+	// 最大排队时间
+	MaxWait time.Duration
+	// 各队列统计
+	Queues []*ReadQueueStatis
 }
 
 // ReadPool 读协程池主要作用，用于控制读取的数据包处理个数，避免读取协程过大。
-// 包含了每个协程读队列，最大支持协程数，每个协程缓冲队列可缓冲包数
+// 每个channel一个有序队列，工作协程数在[minReadPoolSize, maxReadPoolSize]之间伸缩
 type ReadPool struct {
-	// 协程读队列列表
-	readQueue map[int]*ReadQueue
+	// channel id对应的读队列
+	queues map[string]*ReadQueue
+	// 待处理的就绪队列
+	readyList []*ReadQueue
 	mut       sync.Mutex
-	// 最大支持协程数
+	// 队列有空间时唤醒阻塞的读协程
+	notFull *sync.Cond
+	// 有就绪队列时唤醒空闲工作协程
+	notify chan struct{}
+	// 关闭时唤醒所有空闲工作协程
+	closeExit chan struct{}
+	closed    bool
+
+	// 最少工作协程数
+	minReadPoolSize int
+	// 最大工作协程数
 	maxReadPoolSize int
-	// 每个协程缓冲队列可缓冲包数
+	// 每个channel队列可缓冲包数
 	maxReadQueueSize int
+	// 多余的空闲工作协程的存活时间
+	keepAliveTime time.Duration
+	rejectPolicy  RejectPolicy
+
+	workerNum int
+	idleNum   int
+	rejectNum int64
+	// 已移除队列的统计
+	totalNum   int64
+	handledNum int64
+	dropNum    int64
+	totalWait  time.Duration
+	maxWait    time.Duration
 }
 
-// NewReadPool创建协程池
-func NewReadPool(maxReadPoolSize int, maxReadQueueSize int) *ReadPool {
-	r := &ReadPool{
-		readQueue:        make(map[int]*ReadQueue, maxReadQueueSize),
-		maxReadPoolSize:  maxReadPoolSize,
-		maxReadQueueSize: maxReadQueueSize,
+// NewReadPool 创建协程池，其他配置取默认值
+func NewReadPool(maxReadPoolSize int, maxReadQueueSize int) (*ReadPool, error) {
+	return NewReadPoolByConf(NewReadPoolConf(maxReadPoolSize, maxReadQueueSize))
+}
+
+// NewReadPoolByConf 根据配置创建协程池，并启动最少数量的工作协程，配置校验不通过时返回错误
+func NewReadPoolByConf(conf *ReadPoolConf) (*ReadPool, error) {
+	if conf == nil {
+		return nil, errors.New("ReadPoolConf is nil")
 	}
-	go func() {
-		n := make(chan os.Signal, 1)
-		signal.Notify(n, os.Kill, os.Interrupt, syscall.SIGABRT, syscall.SIGTERM)
-		select {
-		case s := <-n:
-			// 结束时释放所有chan
-			logx.Info("signal:", s)
-			r.Close()
-		}
-		logx.Info("release all readchan.")
-	}()
-	return r
+	err := conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+	p := &ReadPool{
+		queues:           make(map[string]*ReadQueue),
+		notify:           make(chan struct{}, 1),
+		closeExit:        make(chan struct{}),
+		minReadPoolSize:  conf.GetMinReadPoolSize(),
+		maxReadPoolSize:  conf.GetMaxReadPoolSize(),
+		maxReadQueueSize: conf.GetMaxReadQueueSize(),
+		keepAliveTime:    conf.GetKeepAliveTime(),
+		rejectPolicy:     conf.RejectPolicy,
+	}
+	p.notFull = sync.NewCond(&p.mut)
+	p.mut.Lock()
+	for i := 0; i < p.minReadPoolSize; i++ {
+		p.startWorker()
+	}
+	p.mut.Unlock()
+	logx.Infof("new read pool, min:%v, max:%v, queueSize:%v, keepAlive:%v, reject:%v",
+		p.minReadPoolSize, p.maxReadPoolSize, p.maxReadQueueSize, p.keepAliveTime, p.rejectPolicy)
+	return p, nil
 }
 
-// Cache 放入缓冲区进行处理
-func (p *ReadPool) Cache(pack IPacket) {
-	id := pack.GetChannel().GetId()
-	// hash方式进行分配
-	key := hashCode(id) % p.maxReadPoolSize
-	queue := p.fetchReadQueue(key)
-	queue.readChan <- pack
+// Cache 放入对应channel的队列等待处理，队列已满时按拒绝策略处理，
+// 被丢弃或者拒绝时返回错误，channel已关闭且队列已移除时返回ErrChannelClosed，此时包已释放
+func (p *ReadPool) Cache(pack IPacket) error {
+	p.mut.Lock()
+	if p.closed {
+		p.mut.Unlock()
+		donePacket(pack)
+		return ErrReadPoolClosed
+	}
+
+	queue := p.fetchReadQueue(pack.GetChannel())
+	if queue == nil {
+		p.mut.Unlock()
+		donePacket(pack)
+		return ErrChannelClosed
+	}
+	for len(queue.items) >= p.maxReadQueueSize {
+		p.rejectNum++
+		switch p.rejectPolicy {
+		case REJECT_DROP_NEWEST, REJECT_ABORT:
+			queue.dropNum++
+			p.mut.Unlock()
+			channel := pack.GetChannel()
+			donePacket(pack)
+			if p.rejectPolicy == REJECT_ABORT {
+				return ErrReadQueueFull
+			}
+			logx.WarnTracef(channel, "read queue is full, drop newest packet.")
+			notifyOverload(channel)
+			return nil
+		case REJECT_DROP_OLDEST:
+			oldest := queue.items[0].packet
+			queue.items[0] = readItem{}
+			queue.items = queue.items[1:]
+			queue.dropNum++
+			p.enqueue(queue, pack)
+			p.mut.Unlock()
+			logx.WarnTracef(pack.GetChannel(), "read queue is full, drop oldest packet.")
+			donePacket(oldest)
+			notifyOverload(pack.GetChannel())
+			return nil
+		case REJECT_CALLER_RUNS:
+			return p.callerRuns(queue, pack)
+		default:
+			p.notFull.Wait()
+			if p.closed {
+				p.mut.Unlock()
+				donePacket(pack)
+				return ErrReadPoolClosed
+			}
+			// 等待期间队列可能已被移除，重新获取
+			queue = p.fetchReadQueue(pack.GetChannel())
+			if queue == nil {
+				p.mut.Unlock()
+				donePacket(pack)
+				return ErrChannelClosed
+			}
+		}
+	}
+	p.enqueue(queue, pack)
+	p.mut.Unlock()
+	return nil
 }
 
-// fetchReadQueue 获取ReadQueue，如果没有则创建
-func (p *ReadPool) fetchReadQueue(key int) *ReadQueue {
-	// 加锁避免获取出现问题
+// Remove channel释放时移除对应的队列，队列中剩余的包处理完后再移除，
+// 移除后执行onRemoved，可能在工作协程中执行，为nil时忽略
+func (p *ReadPool) Remove(id string, onRemoved func()) {
 	p.mut.Lock()
-	defer p.mut.Unlock()
-	readQueue := p.get(key)
-	if readQueue == nil {
-		readQueue = &ReadQueue{readChan: make(chan IPacket, p.maxReadQueueSize),
-			id: key}
-		p.readQueue[key] = readQueue
-		logx.Info("start read queue, key:", key)
-		// 协程运行
-		go handelReadQueue(readQueue)
+	queue, ok := p.queues[id]
+	if !ok {
+		p.mut.Unlock()
+		runRemoved([]func(){onRemoved})
+		return
+	}
+	queue.removed = true
+	if onRemoved != nil {
+		queue.onRemoved = append(queue.onRemoved, onRemoved)
+	}
+	var removed []func()
+	if !queue.scheduled && len(queue.items) <= 0 {
+		removed = p.removeQueue(queue)
+	}
+	p.mut.Unlock()
+	runRemoved(removed)
+}
+
+// fetchReadQueue 获取channel对应的ReadQueue，如果没有则创建，需持有锁，
+// channel已在关闭中时不再创建，返回nil，避免Remove之后到达的包重新创建的队列无法移除
+func (p *ReadPool) fetchReadQueue(channel IChannel) *ReadQueue {
+	id := channel.GetId()
+	queue, ok := p.queues[id]
+	if !ok {
+		if channel.GetState() >= CH_STATE_CLOSING {
+			return nil
+		}
+		queue = &ReadQueue{id: id}
+		p.queues[id] = queue
+	}
+	return queue
+}
+
+// removeQueue 移除队列并合并统计，返回移除后的回调，需持有锁，回调在释放锁后执行
+func (p *ReadPool) removeQueue(queue *ReadQueue) []func() {
+	delete(p.queues, queue.id)
+	p.totalNum += queue.totalNum
+	p.handledNum += queue.handledNum
+	p.dropNum += queue.dropNum
+	p.totalWait += queue.totalWait
+	if queue.maxWait > p.maxWait {
+		p.maxWait = queue.maxWait
+	}
+	removed := queue.onRemoved
+	queue.onRemoved = nil
+	return removed
+}
+
+// runRemoved 执行队列移除后的回调，回调异常不影响工作协程
+func runRemoved(removed []func()) {
+	for _, onRemoved := range removed {
+		if onRemoved == nil {
+			continue
+		}
+		func() {
+			defer func() {
+				rec := recover()
+				if rec != nil {
+					logx.Errorf("run removed func error:%v", rec)
+				}
+			}()
+			onRemoved()
+		}()
+	}
+}
+
+// enqueue 入队，并在队列未调度时放入就绪列表，需持有锁
+func (p *ReadPool) enqueue(queue *ReadQueue, pack IPacket) {
+	queue.items = append(queue.items, readItem{packet: pack, time: time.Now()})
+	queue.totalNum++
+	if !queue.scheduled {
+		queue.scheduled = true
+		p.schedule(queue)
+	}
+}
+
+// schedule 放入就绪列表，没有空闲的工作协程时按需扩容，需持有锁
+func (p *ReadPool) schedule(queue *ReadQueue) {
+	p.readyList = append(p.readyList, queue)
+	if p.idleNum <= 0 && p.workerNum < p.maxReadPoolSize {
+		p.startWorker()
 	}
-	return readQueue
+	p.signal()
+}
+
+// signal 唤醒一个空闲的工作协程
+func (p *ReadPool) signal() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
 
+// startWorker 启动工作协程，需持有锁
+func (p *ReadPool) startWorker() {
+	p.workerNum++
+	go p.work()
 }
 
-// handelReadQueue 处理读队列
-func handelReadQueue(queue *ReadQueue) {
+// work 工作协程，循环获取就绪队列处理，空闲超时且超过最少协程数时退出
+func (p *ReadPool) work() {
+	timer := time.NewTimer(p.keepAliveTime)
+	defer timer.Stop()
 	for {
-		select {
-		case packet, isOpened := <-queue.readChan:
-			if packet != nil {
-				channel := packet.GetChannel()
-				context := NewChHandleContext(channel, packet)
-				func() {
-					handle := channel.GetChHandle()
-					// 有错误可以继续执行
-					defer func() {
-						rec := recover()
-						if rec != nil {
-							logx.ErrorTracef(context, "handle message error:%v", rec)
-							err, ok := rec.(error)
-							if ok {
-								// 捕获处理消息异常
-								NotifyErrorHandle(context, err, ERR_MSG)
-							}
-						}
-						// 释放资源
-						packet.Clear()
-					}()
-					// 交给handle处理
-					handler := handle.GetOnRead()
-					handler(context)
-				}()
-
-				// 管道关闭后的操作
-				if !isOpened {
-					logx.InfoTracef(context, "queue handle end, queueId:%v", queue.id)
-					return
+		p.mut.Lock()
+		if len(p.readyList) > 0 {
+			queue := p.readyList[0]
+			p.readyList[0] = nil
+			p.readyList = p.readyList[1:]
+			if len(p.readyList) > 0 {
+				// 还有就绪队列，没有空闲协程时按需扩容，继续唤醒其他协程
+				if p.idleNum <= 0 && p.workerNum < p.maxReadPoolSize {
+					p.startWorker()
 				}
+				p.signal()
 			}
+			p.mut.Unlock()
+			p.handleQueue(queue)
+			continue
+		}
+
+		if p.closed {
+			p.workerNum--
+			p.mut.Unlock()
+			return
 		}
+		p.idleNum++
+		p.mut.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(p.keepAliveTime)
+		exit := false
+		select {
+		case <-p.notify:
+		case <-timer.C:
+			exit = true
+		case <-p.closeExit:
+		}
+
+		p.mut.Lock()
+		p.idleNum--
+		if exit && len(p.readyList) <= 0 && p.workerNum > p.minReadPoolSize {
+			p.workerNum--
+			p.mut.Unlock()
+			return
+		}
+		p.mut.Unlock()
 	}
 }
 
-func (p *ReadPool) get(key int) *ReadQueue {
-	return p.readQueue[key]
+// handleQueue 连续处理队列中的包，最多READ_POOL_BATCH_SIZE个，还有剩余时重新放入就绪列表末尾，
+// 有读协程等待接管时让出队列
+func (p *ReadPool) handleQueue(queue *ReadQueue) {
+	p.mut.Lock()
+	queue.running = true
+	for i := 0; i < READ_POOL_BATCH_SIZE && len(queue.items) > 0 && queue.takeover <= 0; i++ {
+		item := p.dequeue(queue)
+		p.mut.Unlock()
+		handlePacket(item.packet)
+		p.mut.Lock()
+	}
+	queue.running = false
+	var removed []func()
+	if queue.takeover > 0 {
+		// 交给读协程处理，保持scheduled避免重复调度
+		p.notFull.Broadcast()
+	} else if len(queue.items) > 0 {
+		p.schedule(queue)
+	} else {
+		queue.scheduled = false
+		if queue.removed {
+			removed = p.removeQueue(queue)
+		}
+	}
+	p.mut.Unlock()
+	runRemoved(removed)
 }
 
-// Release 关闭
-func (p *ReadPool) Close() {
+// dequeue 出队并统计排队时间，需持有锁
+func (p *ReadPool) dequeue(queue *ReadQueue) readItem {
+	item := queue.items[0]
+	queue.items[0] = readItem{}
+	queue.items = queue.items[1:]
+	wait := time.Since(item.time)
+	queue.handledNum++
+	queue.totalWait += wait
+	if wait > queue.maxWait {
+		queue.maxWait = wait
+	}
+	p.notFull.Broadcast()
+	return item
+}
+
+// callerRuns 由读协程接管队列，等待正在处理的协程让出后，先处理完队列中已有的包，再处理新到的包，保证有序，
+// 需持有锁，返回时已释放锁
+func (p *ReadPool) callerRuns(queue *ReadQueue, pack IPacket) error {
+	queue.takeover++
+	for queue.running && !p.closed {
+		p.notFull.Wait()
+	}
+	queue.takeover--
+	var err error
+	if p.closed {
+		err = ErrReadPoolClosed
+		if queue.running {
+			p.mut.Unlock()
+			donePacket(pack)
+			return err
+		}
+		// 工作协程可能已让出队列，接管处理完已入队的包，新到的包不再处理
+		defer donePacket(pack)
+		pack = nil
+	}
+	if queue.scheduled {
+		// 在就绪列表中等待处理，从中移除
+		p.unschedule(queue)
+	}
+	queue.scheduled = true
+	queue.running = true
+	for {
+		if len(queue.items) > 0 {
+			item := p.dequeue(queue)
+			p.mut.Unlock()
+			handlePacket(item.packet)
+			p.mut.Lock()
+			continue
+		}
+		if pack == nil {
+			break
+		}
+		queue.totalNum++
+		queue.handledNum++
+		p.mut.Unlock()
+		handlePacket(pack)
+		pack = nil
+		p.mut.Lock()
+	}
+	queue.running = false
+	queue.scheduled = false
+	var removed []func()
+	if queue.removed {
+		removed = p.removeQueue(queue)
+	}
+	// 唤醒其他等待接管或者等待空间的读协程
+	p.notFull.Broadcast()
+	p.mut.Unlock()
+	runRemoved(removed)
+	return err
+}
+
+// unschedule 从就绪列表中移除队列，需持有锁
+func (p *ReadPool) unschedule(queue *ReadQueue) {
+	for i, q := range p.readyList {
+		if q == queue {
+			copy(p.readyList[i:], p.readyList[i+1:])
+			p.readyList[len(p.readyList)-1] = nil
+			p.readyList = p.readyList[:len(p.readyList)-1]
+			return
+		}
+	}
+}
+
+// handlePacket 交给handle处理，处理完后释放包
+func handlePacket(packet IPacket) {
+	if packet == nil {
+		return
+	}
+	channel := packet.GetChannel()
+	context := NewChHandleContext(channel, packet)
+	handle := channel.GetChHandle()
+	// 有错误可以继续执行
+	defer func() {
+		rec := recover()
+		if rec != nil {
+			logx.ErrorTracef(context, "handle message error:%v", rec)
+			err, ok := rec.(error)
+			if ok {
+				// 捕获处理消息异常
+				NotifyErrorHandle(context, err, ERR_MSG)
+			}
+		}
+		donePacket(packet)
+	}()
+	// 交给handle处理，与直接处理时一致，经内部代理记录统计和错误
+	handler := handle.onInnerRead
+	if handler == nil {
+		handler = handle.GetOnRead()
+	}
+	handler(context)
+}
+
+// notifyOverload 队列已满丢包时通知channel的异常处理，错误码为ERR_OVERLOAD
+func notifyOverload(channel IChannel) {
+	NotifyErrorHandle(NewChHandleContext(channel, nil), ErrReadQueueFull, ERR_OVERLOAD)
+}
+
+// donePacket 释放包资源，并减少channel待处理数
+func donePacket(packet IPacket) {
+	packet.Clear()
+	packet.Release()
+	pending, ok := packet.GetChannel().(iPending)
+	if ok {
+		pending.addPending(-1)
+	}
+}
+
+// GetStatis 获取协程池及各个队列的统计
+func (p *ReadPool) GetStatis() *ReadPoolStatis {
 	p.mut.Lock()
 	defer p.mut.Unlock()
-	for k, queue := range p.readQueue {
-		close(queue.readChan)
-		delete(p.readQueue, k)
+	ret := &ReadPoolStatis{
+		WorkerNum:  p.workerNum,
+		IdleNum:    p.idleNum,
+		QueueNum:   len(p.queues),
+		TotalNum:   p.totalNum,
+		HandledNum: p.handledNum,
+		DropNum:    p.dropNum,
+		RejectNum:  p.rejectNum,
+		MaxWait:    p.maxWait,
+		Queues:     make([]*ReadQueueStatis, 0, len(p.queues)),
+	}
+	totalWait := p.totalWait
+	for _, queue := range p.queues {
+		qs := &ReadQueueStatis{
+			Id:         queue.id,
+			Depth:      len(queue.items),
+			TotalNum:   queue.totalNum,
+			HandledNum: queue.handledNum,
+			DropNum:    queue.dropNum,
+			MaxWait:    queue.maxWait,
+		}
+		if queue.handledNum > 0 {
+			qs.AvgWait = queue.totalWait / time.Duration(queue.handledNum)
+		}
+		ret.Queues = append(ret.Queues, qs)
+		ret.Depth += qs.Depth
+		ret.TotalNum += queue.totalNum
+		ret.HandledNum += queue.handledNum
+		ret.DropNum += queue.dropNum
+		totalWait += queue.totalWait
+		if queue.maxWait > ret.MaxWait {
+			ret.MaxWait = queue.maxWait
+		}
 	}
+	if ret.HandledNum > 0 {
+		ret.AvgWait = totalWait / time.Duration(ret.HandledNum)
+	}
+	return ret
 }
 
-// hashCode 哈希计算
-func hashCode(s string) int {
-	v := int(crc32.ChecksumIEEE([]byte(s)))
-	if v >= 0 {
-		return v
-	}
-	if -v >= 0 {
-		return -v
+// Close 关闭，不再接收新的包，已入队的包处理完后工作协程退出
+func (p *ReadPool) Close() {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.closed {
+		return
 	}
-	// v == MinInt
-	return 0
+	p.closed = true
+	p.notFull.Broadcast()
+	close(p.closeExit)
+	logx.Info("close read pool.")
 }
@@ -0,0 +1,409 @@
+/*
+ * Author:slive
+ * DATE:2020/7/25
+ */
+package channel
+
+import (
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// poolRecorder 记录各channel处理的包，可阻塞处理用于构造队列已满的场景
+type poolRecorder struct {
+	mut     sync.Mutex
+	handled map[string][]int
+	// 处理时是否在读协程中
+	callers map[int]bool
+	// 不为nil时，处理前通知entered并等待gate
+	gate    chan struct{}
+	entered chan int
+}
+
+func newPoolRecorder(block bool) *poolRecorder {
+	r := &poolRecorder{handled: make(map[string][]int), callers: make(map[int]bool)}
+	if block {
+		r.gate = make(chan struct{})
+		r.entered = make(chan int, 100)
+	}
+	return r
+}
+
+func (r *poolRecorder) newChannel(id string) *stateChannel {
+	ch := newStateChannel(NewDefChHandle(func(ctx IChHandleContext) {
+		seq, _ := strconv.Atoi(string(ctx.GetPacket().GetData()))
+		if r.gate != nil {
+			r.entered <- seq
+			<-r.gate
+		}
+		r.mut.Lock()
+		defer r.mut.Unlock()
+		r.handled[ctx.GetChannel().GetId()] = append(r.handled[ctx.GetChannel().GetId()], seq)
+		r.callers[seq] = strings.Contains(string(debug.Stack()), "(*ReadPool).callerRuns")
+	}))
+	ch.SetId(id)
+	return ch
+}
+
+func (r *poolRecorder) getHandled(id string) []int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return append([]int{}, r.handled[id]...)
+}
+
+func (r *poolRecorder) waitEntered(t *testing.T, expect int) {
+	select {
+	case seq := <-r.entered:
+		if seq != expect {
+			t.Fatalf("expect enter:%v, actual:%v", expect, seq)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("wait enter %v timeout.", expect)
+	}
+}
+
+func newPoolPacket(ch IChannel, seq int) IPacket {
+	packet := NewPacket(ch, NETWORK_TCP)
+	packet.SetData([]byte(strconv.Itoa(seq)))
+	return packet
+}
+
+func newTestReadPool(t *testing.T, conf *ReadPoolConf) *ReadPool {
+	pool, err := NewReadPoolByConf(conf)
+	if err != nil {
+		t.Fatalf("new read pool error:%v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func waitPool(t *testing.T, msg string, cond func() bool) {
+	for i := 0; i < 300; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("wait %v timeout.", msg)
+}
+
+func equalSeqs(actual []int, expect ...int) bool {
+	if len(actual) != len(expect) {
+		return false
+	}
+	for i := range expect {
+		if actual[i] != expect[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReadPoolFifo(t *testing.T) {
+	recorder := newPoolRecorder(false)
+	pool := newTestReadPool(t, NewReadPoolConf(4, 8))
+	chNum, packNum := 8, 500
+	var wg sync.WaitGroup
+	ids := make([]string, 0, chNum)
+	for i := 0; i < chNum; i++ {
+		ch := recorder.newChannel("fifo" + strconv.Itoa(i))
+		ids = append(ids, ch.GetId())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := 0; seq < packNum; seq++ {
+				if err := pool.Cache(newPoolPacket(ch, seq)); err != nil {
+					t.Errorf("cache error:%v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, id := range ids {
+		waitPool(t, "handle "+id, func() bool {
+			return len(recorder.getHandled(id)) >= packNum
+		})
+		for seq, handled := range recorder.getHandled(id) {
+			if seq != handled {
+				t.Fatalf("packets of %v should be in order, index:%v, seq:%v", id, seq, handled)
+			}
+		}
+	}
+	statis := pool.GetStatis()
+	if statis.HandledNum != int64(chNum*packNum) || statis.DropNum != 0 || statis.WorkerNum > 4 {
+		t.Fatalf("unexpected statis:%+v", statis)
+	}
+}
+
+// fillReadPool 只有1个工作协程且队列只能缓冲1个包，包1处理中，包2在队列中，此时队列已满
+func fillReadPool(t *testing.T, policy RejectPolicy) (*ReadPool, *poolRecorder, *stateChannel) {
+	conf := NewReadPoolConf(1, 1)
+	conf.RejectPolicy = policy
+	pool := newTestReadPool(t, conf)
+	recorder := newPoolRecorder(true)
+	ch := recorder.newChannel(policy.String())
+	if err := pool.Cache(newPoolPacket(ch, 1)); err != nil {
+		t.Fatalf("cache error:%v", err)
+	}
+	recorder.waitEntered(t, 1)
+	if err := pool.Cache(newPoolPacket(ch, 2)); err != nil {
+		t.Fatalf("cache error:%v", err)
+	}
+	return pool, recorder, ch
+}
+
+// cacheAsync 在其他协程中放入包，返回结果的通道
+func cacheAsync(pool *ReadPool, packet IPacket) chan error {
+	ret := make(chan error, 1)
+	go func() {
+		ret <- pool.Cache(packet)
+	}()
+	return ret
+}
+
+func TestReadPoolRejectPolicy(t *testing.T) {
+	cases := []struct {
+		policy   RejectPolicy
+		err      error
+		expect   []int
+		overload int64
+	}{
+		{REJECT_DROP_NEWEST, nil, []int{1, 2}, 1},
+		{REJECT_DROP_OLDEST, nil, []int{1, 3}, 1},
+		// 返回错误时由读协程通知
+		{REJECT_ABORT, ErrReadQueueFull, []int{1, 2}, 0},
+	}
+	for _, c := range cases {
+		pool, recorder, ch := fillReadPool(t, c.policy)
+		// 队列已满时立即返回
+		if err := pool.Cache(newPoolPacket(ch, 3)); err != c.err {
+			t.Fatalf("unexpected error, policy:%v, err:%v", c.policy, err)
+		}
+		// 丢包时通知异常处理，错误码为ERR_OVERLOAD
+		if num := ch.GetChStatis().GetErrorNums()[ERR_OVERLOAD]; num != c.overload {
+			t.Fatalf("unexpected overload num, policy:%v, num:%v", c.policy, num)
+		}
+		close(recorder.gate)
+		id := ch.GetId()
+		waitPool(t, "handle "+id, func() bool {
+			return len(recorder.getHandled(id)) >= len(c.expect)
+		})
+		time.Sleep(50 * time.Millisecond)
+		if handled := recorder.getHandled(id); !equalSeqs(handled, c.expect...) {
+			t.Fatalf("unexpected handled, policy:%v, handled:%v", c.policy, handled)
+		}
+		statis := pool.GetStatis()
+		if statis.DropNum != 1 || statis.RejectNum != 1 {
+			t.Fatalf("unexpected statis, policy:%v, statis:%+v", c.policy, statis)
+		}
+	}
+}
+
+func TestReadPoolRejectBlock(t *testing.T) {
+	pool, recorder, ch := fillReadPool(t, REJECT_BLOCK)
+	ret := cacheAsync(pool, newPoolPacket(ch, 3))
+	select {
+	case <-ret:
+		t.Fatal("cache should block when queue is full.")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(recorder.gate)
+	if err := <-ret; err != nil {
+		t.Fatalf("cache error:%v", err)
+	}
+	id := ch.GetId()
+	waitPool(t, "handle "+id, func() bool {
+		return len(recorder.getHandled(id)) >= 3
+	})
+	if handled := recorder.getHandled(id); !equalSeqs(handled, 1, 2, 3) {
+		t.Fatalf("unexpected handled:%v", handled)
+	}
+}
+
+func TestReadPoolRejectCallerRuns(t *testing.T) {
+	pool, recorder, ch := fillReadPool(t, REJECT_CALLER_RUNS)
+	ret := cacheAsync(pool, newPoolPacket(ch, 3))
+	// 等待工作协程处理完包1后接管队列，包2和包3在读协程中处理，且不超过队列缓冲数
+	time.Sleep(50 * time.Millisecond)
+	if depth := pool.GetStatis().Depth; depth > 1 {
+		t.Fatalf("queue should not exceed max size, depth:%v", depth)
+	}
+	close(recorder.gate)
+	select {
+	case err := <-ret:
+		if err != nil {
+			t.Fatalf("cache error:%v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("caller runs timeout.")
+	}
+	if handled := recorder.getHandled(ch.GetId()); !equalSeqs(handled, 1, 2, 3) {
+		t.Fatalf("unexpected handled:%v", handled)
+	}
+	recorder.mut.Lock()
+	defer recorder.mut.Unlock()
+	if recorder.callers[1] || !recorder.callers[2] || !recorder.callers[3] {
+		t.Fatalf("packets after full should run in caller, callers:%v", recorder.callers)
+	}
+	statis := pool.GetStatis()
+	if statis.QueueNum != 1 || statis.HandledNum != 3 || statis.TotalNum != 3 {
+		t.Fatalf("unexpected statis:%+v", statis)
+	}
+}
+
+func TestReadPoolWorkerKeepAlive(t *testing.T) {
+	conf := NewReadPoolConf(3, 10)
+	conf.MinReadPoolSize = 1
+	conf.KeepAliveTime = time.Second
+	pool := newTestReadPool(t, conf)
+	if pool.GetStatis().WorkerNum != 1 {
+		t.Fatalf("should start min workers, statis:%+v", pool.GetStatis())
+	}
+
+	recorder := newPoolRecorder(true)
+	for i := 0; i < 4; i++ {
+		ch := recorder.newChannel("worker" + strconv.Itoa(i))
+		if err := pool.Cache(newPoolPacket(ch, i)); err != nil {
+			t.Fatalf("cache error:%v", err)
+		}
+	}
+	// 不超过最大工作协程数，第4个channel的包等待处理
+	for i := 0; i < 3; i++ {
+		<-recorder.entered
+	}
+	statis := pool.GetStatis()
+	if statis.WorkerNum != 3 || statis.Depth != 1 {
+		t.Fatalf("workers should grow to max, statis:%+v", statis)
+	}
+	close(recorder.gate)
+	waitPool(t, "handle all", func() bool {
+		return pool.GetStatis().HandledNum == 4
+	})
+
+	// 空闲超过keepAliveTime后回收到最少工作协程数
+	waitPool(t, "reap workers", func() bool {
+		return pool.GetStatis().WorkerNum == 1
+	})
+}
+
+func TestReadPoolRemove(t *testing.T) {
+	pool := newTestReadPool(t, NewReadPoolConf(1, 10))
+	recorder := newPoolRecorder(true)
+	ch := recorder.newChannel("remove")
+	for i := 1; i <= 3; i++ {
+		if err := pool.Cache(newPoolPacket(ch, i)); err != nil {
+			t.Fatalf("cache error:%v", err)
+		}
+	}
+	recorder.waitEntered(t, 1)
+	// 剩余的包处理完后再执行移除回调
+	removed := make(chan []int, 1)
+	pool.Remove(ch.GetId(), func() {
+		removed <- recorder.getHandled(ch.GetId())
+	})
+	if statis := pool.GetStatis(); statis.QueueNum != 1 || statis.Depth != 2 {
+		t.Fatalf("queue should remain until drained, statis:%+v", statis)
+	}
+	select {
+	case <-removed:
+		t.Fatal("removed func should run after drained.")
+	default:
+	}
+	close(recorder.gate)
+	select {
+	case handled := <-removed:
+		if !equalSeqs(handled, 1, 2, 3) {
+			t.Fatalf("removed func run before drained, handled:%v", handled)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait removed func timeout.")
+	}
+	if statis := pool.GetStatis(); statis.QueueNum != 0 {
+		t.Fatalf("queue should be removed, statis:%+v", statis)
+	}
+	if handled := recorder.getHandled(ch.GetId()); !equalSeqs(handled, 1, 2, 3) {
+		t.Fatalf("remaining packets should be handled, handled:%v", handled)
+	}
+	// 移除后的统计合并到协程池
+	if statis := pool.GetStatis(); statis.HandledNum != 3 || statis.TotalNum != 3 {
+		t.Fatalf("unexpected statis:%+v", statis)
+	}
+
+	// 没有队列时直接执行
+	done := false
+	pool.Remove("none", func() {
+		done = true
+	})
+	if !done {
+		t.Fatal("removed func should run directly without queue.")
+	}
+}
+
+func TestReadPoolCacheAfterRemove(t *testing.T) {
+	pool := newTestReadPool(t, NewReadPoolConf(1, 10))
+	recorder := newPoolRecorder(false)
+	ch := recorder.newChannel("late")
+	if err := ch.Open(); err != nil {
+		t.Fatalf("open error:%v", err)
+	}
+	if err := pool.Cache(newPoolPacket(ch, 1)); err != nil {
+		t.Fatalf("cache error:%v", err)
+	}
+	ch.Release()
+	pool.Remove(ch.GetId(), nil)
+	waitPool(t, "remove queue", func() bool {
+		return pool.GetStatis().QueueNum == 0
+	})
+
+	// 移除后到达的包不再创建队列，直接释放
+	packet := newPoolPacket(ch, 2)
+	if err := pool.Cache(packet); err != ErrChannelClosed {
+		t.Fatalf("cache after remove should fail, err:%v", err)
+	}
+	pool.mut.Lock()
+	num := len(pool.queues)
+	pool.mut.Unlock()
+	if num != 0 || !packet.IsRelease() {
+		t.Fatalf("queue should not be recreated, queues:%v", num)
+	}
+	if handled := recorder.getHandled(ch.GetId()); !equalSeqs(handled, 1) {
+		t.Fatalf("unexpected handled:%v", handled)
+	}
+}
+
+func TestReadPoolCloseBlocked(t *testing.T) {
+	for _, policy := range []RejectPolicy{REJECT_BLOCK, REJECT_CALLER_RUNS} {
+		pool, recorder, ch := fillReadPool(t, policy)
+		ret := cacheAsync(pool, newPoolPacket(ch, 3))
+		time.Sleep(50 * time.Millisecond)
+		pool.Close()
+		select {
+		case err := <-ret:
+			if err != ErrReadPoolClosed {
+				t.Fatalf("unexpected error, policy:%v, err:%v", policy, err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("close should wake blocked producer, policy:%v", policy)
+		}
+		if err := pool.Cache(newPoolPacket(ch, 4)); err != ErrReadPoolClosed {
+			t.Fatalf("cache after close should fail, err:%v", err)
+		}
+		// 已入队的包仍然处理完
+		close(recorder.gate)
+		id := ch.GetId()
+		waitPool(t, "handle "+id, func() bool {
+			return len(recorder.getHandled(id)) >= 2
+		})
+		waitPool(t, "workers exit", func() bool {
+			return pool.GetStatis().WorkerNum == 0
+		})
+		if handled := recorder.getHandled(id); !equalSeqs(handled, 1, 2) {
+			t.Fatalf("unexpected handled, policy:%v, handled:%v", policy, handled)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * channel生命周期状态，状态只能按以下顺序变更：
+ * Created -> Opening -> Active -> Closing -> Closed
+ * Opening也可以直接变更为Closing(打开过程中出错或被释放)
+ * Author:slive
+ * DATE:2020/8/7
+ */
+package channel
+
+import (
+	logx "github.com/slive/gsfly/logger"
+	"sync/atomic"
+)
+
+// ChState channel状态
+type ChState int32
+
+const (
+	// CH_STATE_CREATED 已创建，未打开
+	CH_STATE_CREATED ChState = iota
+	// CH_STATE_OPENING 打开中
+	CH_STATE_OPENING
+	// CH_STATE_ACTIVE 已打开，可收发消息
+	CH_STATE_ACTIVE
+	// CH_STATE_CLOSING 关闭中
+	CH_STATE_CLOSING
+	// CH_STATE_CLOSED 已关闭
+	CH_STATE_CLOSED
+)
+
+func (s ChState) String() string {
+	switch s {
+	case CH_STATE_CREATED:
+		return "Created"
+	case CH_STATE_OPENING:
+		return "Opening"
+	case CH_STATE_ACTIVE:
+		return "Active"
+	case CH_STATE_CLOSING:
+		return "Closing"
+	case CH_STATE_CLOSED:
+		return "Closed"
+	default:
+		return "Unknown"
+	}
+}
+
+// ChStateFunc channel状态变更后的处理方法
+// ctx 上下文
+// oldState 变更前的状态
+// newState 变更后的状态
+type ChStateFunc func(ctx IChHandleContext, oldState ChState, newState ChState)
+
+// GetState 获取channel当前状态
+func (ch *Channel) GetState() ChState {
+	return ChState(atomic.LoadInt32(&ch.state))
+}
+
+// casState 状态从oldState变更为newState，成功时通知handle的onStateChange
+func (ch *Channel) casState(ctx IChHandleContext, oldState ChState, newState ChState) bool {
+	if !atomic.CompareAndSwapInt32(&ch.state, int32(oldState), int32(newState)) {
+		return false
+	}
+	notifyStateChange(ctx, oldState, newState)
+	return true
+}
+
+// notifyStateChange 通知状态变更，处理方法的异常不影响状态变更
+func notifyStateChange(ctx IChHandleContext, oldState ChState, newState ChState) {
+	logx.InfoTracef(ctx, "channel state change, %v->%v", oldState, newState)
+	channel := ctx.GetChannel()
+	chStatis := channel.GetChStatis()
+	if chStatis != nil {
+		chStatis.recordState(oldState, newState)
+	}
+	handle := channel.GetChHandle()
+	if handle == nil {
+		return
+	}
+	stateFunc := handle.GetOnStateChange()
+	if stateFunc == nil {
+		return
+	}
+	defer func() {
+		rec := recover()
+		if rec != nil {
+			logx.WarnTracef(ctx, "onStateChange error:%v", rec)
+		}
+	}()
+	stateFunc(ctx, oldState, newState)
+}
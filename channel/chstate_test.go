@@ -0,0 +1,225 @@
+/*
+ * Author:slive
+ * DATE:2020/8/7
+ */
+package channel
+
+import (
+	"context"
+	"errors"
+	"github.com/slive/gsfly/common"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stateChannel 用于测试的channel，读阻塞直到释放，写只计数
+type stateChannel struct {
+	Channel
+	done     chan struct{}
+	doneOnce sync.Once
+	writeNum int32
+	// 写入时返回的错误，为nil时写入成功
+	writeErr error
+}
+
+func newStateChannel(handle *ChHandle) *stateChannel {
+	return newStateChannelByParent(nil, handle)
+}
+
+func newStateChannelByParent(parent interface{}, handle *ChHandle) *stateChannel {
+	ch := &stateChannel{done: make(chan struct{})}
+	ch.Channel = *NewChannel(parent, nil, nil, handle, false)
+	ch.SetId("state")
+	return ch
+}
+
+func (ch *stateChannel) Open() error {
+	return ch.StartChannel(ch)
+}
+
+func (ch *stateChannel) Release() {
+	ch.StopChannel(ch)
+	ch.doneOnce.Do(func() {
+		close(ch.done)
+	})
+}
+
+func (ch *stateChannel) Read() (IPacket, error) {
+	<-ch.done
+	return nil, io.EOF
+}
+
+func (ch *stateChannel) Write(datapack IPacket) error {
+	return ch.Channel.Write(datapack)
+}
+
+func (ch *stateChannel) WriteByConn(datapack IPacket) error {
+	atomic.AddInt32(&ch.writeNum, 1)
+	return ch.writeErr
+}
+
+func (ch *stateChannel) NewPacket() IPacket {
+	return NewPacket(ch, NETWORK_TCP)
+}
+
+func TestChannelStateChange(t *testing.T) {
+	var mut sync.Mutex
+	var states []ChState
+	var releaseNum int32
+	handle := NewDefChHandle(func(ctx IChHandleContext) {})
+	handle.SetOnStateChange(func(ctx IChHandleContext, oldState ChState, newState ChState) {
+		mut.Lock()
+		defer mut.Unlock()
+		states = append(states, newState)
+	})
+	handle.SetOnRelease(func(ctx IChHandleContext) {
+		atomic.AddInt32(&releaseNum, 1)
+	})
+
+	ch := newStateChannel(handle)
+	if ch.GetState() != CH_STATE_CREATED || !ch.IsClosed() {
+		t.Fatalf("unexpected init state:%v", ch.GetState())
+	}
+	if err := ch.Open(); err != nil {
+		t.Fatalf("open error:%v", err)
+	}
+	if ch.GetState() != CH_STATE_ACTIVE || ch.IsClosed() {
+		t.Fatalf("unexpected open state:%v", ch.GetState())
+	}
+	if ch.Open() == nil {
+		t.Fatal("open twice should fail.")
+	}
+
+	// 并发释放只执行一次
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ch.Release()
+		}()
+	}
+	wg.Wait()
+	if ch.GetState() != CH_STATE_CLOSED {
+		t.Fatalf("unexpected release state:%v", ch.GetState())
+	}
+	if atomic.LoadInt32(&releaseNum) != 1 {
+		t.Fatalf("onRelease should be called once, actual:%v", releaseNum)
+	}
+	if ch.Open() == nil {
+		t.Fatal("open after release should fail.")
+	}
+
+	mut.Lock()
+	defer mut.Unlock()
+	expect := []ChState{CH_STATE_OPENING, CH_STATE_ACTIVE, CH_STATE_CLOSING, CH_STATE_CLOSED}
+	if len(states) != len(expect) {
+		t.Fatalf("unexpected states:%v", states)
+	}
+	for i, state := range expect {
+		if states[i] != state {
+			t.Fatalf("unexpected states:%v", states)
+		}
+	}
+}
+
+func TestChannelConcurrentWriteRelease(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		ch := newStateChannel(NewDefChHandle(func(ctx IChHandleContext) {}))
+		if err := ch.Open(); err != nil {
+			t.Fatalf("open error:%v", err)
+		}
+		wg := sync.WaitGroup{}
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for k := 0; k < 50; k++ {
+					packet := ch.NewPacket()
+					packet.SetData([]byte("hello"))
+					err := ch.Write(packet)
+					if err != nil && !errors.Is(err, ErrChannelClosed) {
+						t.Errorf("unexpected write error:%v", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Add(2)
+		for j := 0; j < 2; j++ {
+			go func() {
+				defer wg.Done()
+				ch.Release()
+			}()
+		}
+		wg.Wait()
+		if ch.GetState() != CH_STATE_CLOSED {
+			t.Fatalf("unexpected state:%v", ch.GetState())
+		}
+		packet := ch.NewPacket()
+		packet.SetData([]byte("hello"))
+		if !errors.Is(ch.Write(packet), ErrChannelClosed) {
+			t.Fatal("write after release should return ErrChannelClosed.")
+		}
+	}
+}
+
+func TestChannelReleaseAttach(t *testing.T) {
+	var order []string
+	key := common.NewAttachKeyWithRelease("session", "", func(val interface{}) {
+		order = append(order, "attach:"+val.(string))
+	})
+	handle := NewDefChHandle(func(ctx IChHandleContext) {})
+	handle.SetOnRelease(func(ctx IChHandleContext) {
+		// onRelease中附件仍可用
+		order = append(order, "onRelease:"+ctx.GetChannel().GetTypedAttach(key).(string))
+	})
+
+	ch := newStateChannel(handle)
+	if err := ch.Open(); err != nil {
+		t.Fatalf("open error:%v", err)
+	}
+	ch.AddTypedAttach(key, "s1")
+	ch.Release()
+	if len(order) != 2 || order[0] != "onRelease:s1" || order[1] != "attach:s1" {
+		t.Fatalf("unexpected release order:%v", order)
+	}
+	if ch.GetTypedAttach(key) != nil {
+		t.Fatal("attach should be cleared after release.")
+	}
+}
+
+func TestChannelContextCancel(t *testing.T) {
+	parent := common.NewCancelRunContext(context.TODO())
+	ch := newStateChannelByParent(parent, NewDefChHandle(func(ctx IChHandleContext) {}))
+	if err := ch.Open(); err != nil {
+		t.Fatalf("open error:%v", err)
+	}
+	packet := ch.NewPacket()
+	packet.SetDeadline(time.Now().Add(time.Hour))
+
+	// 父上下文取消时释放channel，channel和packet的上下文随之取消
+	parent.Cancel()
+	for i := 0; i < 100 && ch.GetState() != CH_STATE_CLOSED; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ch.GetState() != CH_STATE_CLOSED {
+		t.Fatalf("channel should be released, state:%v", ch.GetState())
+	}
+	if ch.GetContext().Err() == nil || packet.GetContext().Err() == nil {
+		t.Fatal("channel and packet context should be done.")
+	}
+
+	// 释放channel时取消上下文
+	ch = newStateChannel(NewDefChHandle(func(ctx IChHandleContext) {}))
+	if err := ch.Open(); err != nil {
+		t.Fatalf("open error:%v", err)
+	}
+	ch.Release()
+	if ch.GetContext().Err() != context.Canceled {
+		t.Fatalf("unexpected err:%v", ch.GetContext().Err())
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * channel的追踪，对连接，读取，处理，写入和关闭生成span，未开启追踪(见tracex.SetTracer)时不做处理
+ * Author:slive
+ * DATE:2020/9/25
+ */
+package channel
+
+import (
+	"github.com/slive/gsfly/tracex"
+)
+
+// ITraceCodec 在包中注入和提取traceparent的编解码，如在自定义协议的包头中携带，
+// 读取时在放入读协程池前提取，写入时在写之前的处理(preWrite)前注入
+type ITraceCodec interface {
+	// Inject 注入traceparent
+	Inject(packet IPacket, traceparent string)
+
+	// Extract 提取traceparent，没有时返回空字符串
+	Extract(packet IPacket) string
+}
+
+// AttachTraceCodec 通过包的附件(key为tracex.TRACEPARENT)传递traceparent，为默认的编解码，
+// 可在解码时设置附件，编码时读取附件
+type AttachTraceCodec struct {
+}
+
+func (codec *AttachTraceCodec) Inject(packet IPacket, traceparent string) {
+	packet.AddAttach(tracex.TRACEPARENT, traceparent)
+}
+
+func (codec *AttachTraceCodec) Extract(packet IPacket) string {
+	traceparent, _ := packet.GetAttach(tracex.TRACEPARENT).(string)
+	return traceparent
+}
+
+var defTraceCodec = &AttachTraceCodec{}
+
+// getTraceCodec 获取channel的编解码，未设置时使用AttachTraceCodec
+func getTraceCodec(channel IChannel) ITraceCodec {
+	handle := channel.GetChHandle()
+	if handle != nil {
+		codec := handle.GetTraceCodec()
+		if codec != nil {
+			return codec
+		}
+	}
+	return defTraceCodec
+}
+
+// startConnectSpan 开始连接的span，channel的上下文设置为该span，之后的span默认为其子span
+func startConnectSpan(ch *Channel) tracex.ISpan {
+	if !tracex.IsEnabled() {
+		return nil
+	}
+	spanCtx, span := tracex.StartSpan(ch.GetContext(), tracex.Span_Connect)
+	ch.SetContext(spanCtx)
+	return span
+}
+
+// traceRead 记录读取的span，包中有traceparent时为其子span，包的上下文设置为该span，以便处理的span为其子span
+func traceRead(channel IChannel, packet IPacket) {
+	if !tracex.IsEnabled() {
+		return
+	}
+	ctx := tracex.ExtractTraceparent(packet.GetContext(), getTraceCodec(channel).Extract(packet))
+	spanCtx, span := tracex.StartSpanAt(ctx, tracex.Span_Read, packet.GetInitTime())
+	span.SetAttr(tracex.Attr_Size, len(packet.GetData()))
+	span.End()
+	packet.SetContext(spanCtx)
+}
+
+// startHandleSpan 开始处理的span，处理上下文设置为该span，处理方法中可将其传递给下游调用
+func startHandleSpan(ctx IChHandleContext) tracex.ISpan {
+	if !tracex.IsEnabled() {
+		return nil
+	}
+	spanCtx, span := tracex.StartSpan(ctx.GetContext(), tracex.Span_Handle)
+	ctx.SetContext(spanCtx)
+	return span
+}
+
+// startWriteSpan 开始写入的span，并在包中注入该span的traceparent，
+// 回复的包可设置为处理上下文(packet.SetContext(ctx.GetContext()))，以便为处理的子span
+func startWriteSpan(channel IChannel, packet IPacket, batch bool) tracex.ISpan {
+	if !tracex.IsEnabled() {
+		return nil
+	}
+	_, span := tracex.StartSpan(packet.GetContext(), tracex.Span_Write)
+	span.SetAttr(tracex.Attr_Size, len(packet.GetData()))
+	if batch {
+		span.SetAttr(tracex.Attr_Batch, true)
+	}
+	getTraceCodec(channel).Inject(packet, tracex.FormatTraceparent(span.GetSpanContext()))
+	return span
+}
+
+// startCloseSpan 开始关闭的span
+func startCloseSpan(ch *Channel) tracex.ISpan {
+	if !tracex.IsEnabled() {
+		return nil
+	}
+	_, span := tracex.StartSpan(ch.GetContext(), tracex.Span_Close)
+	return span
+}
+
+// endSpans 批量发送后结束各包的写入span，err不为nil时记录错误
+func endSpans(spans []tracex.ISpan, err error) {
+	for _, span := range spans {
+		endSpan(span, err)
+	}
+}
+
+// endSpan 结束span，err不为nil时记录错误，span为nil(未开启追踪)时不处理
+func endSpan(span tracex.ISpan, err error) {
+	if span == nil {
+		return
+	}
+	span.SetError(err)
+	span.End()
+}
@@ -0,0 +1,187 @@
+/*
+ * Author:slive
+ * DATE:2020/9/25
+ */
+package channel
+
+import (
+	"errors"
+	"github.com/slive/gsfly/tracex"
+	"testing"
+)
+
+func TestChannelTrace(t *testing.T) {
+	exporter := tracex.NewMemoryExporter()
+	tracex.SetTracer(tracex.NewTracer(exporter))
+	defer tracex.SetTracer(nil)
+
+	var reply IPacket
+	handle := NewDefChHandle(func(ctx IChHandleContext) {
+		// 回复的包设置为处理上下文，写入的span为处理的子span
+		ch := ctx.GetChannel()
+		reply = ch.NewPacket()
+		reply.SetData([]byte("world"))
+		reply.SetContext(ctx.GetContext())
+		ch.Write(reply)
+	})
+	ch := newStateChannel(handle)
+	if err := ch.Open(); err != nil {
+		t.Fatalf("open error:%v", err)
+	}
+
+	remote := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	packet := ch.NewPacket()
+	packet.SetData([]byte("hello"))
+	packet.AddAttach(tracex.TRACEPARENT, remote)
+	traceRead(ch, packet)
+	handle.onInnerRead(NewChHandleContext(ch, packet))
+	ch.Release()
+
+	spanOf := func(name string) *tracex.SpanData {
+		spans := exporter.GetSpansByName(name)
+		if len(spans) != 1 {
+			t.Fatalf("unexpected %v spans:%v", name, spans)
+		}
+		return spans[0]
+	}
+	connect, read, handleSpan, write, closeSpan := spanOf(tracex.Span_Connect), spanOf(tracex.Span_Read),
+		spanOf(tracex.Span_Handle), spanOf(tracex.Span_Write), spanOf(tracex.Span_Close)
+	if connect.ParentSpanId != "" || connect.Attrs["chId"] != ch.GetId() {
+		t.Fatalf("unexpected connect span:%+v", connect)
+	}
+	if read.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || read.ParentSpanId != "00f067aa0ba902b7" ||
+		!read.RemoteParent || read.Attrs[tracex.Attr_Size] != 5 {
+		t.Fatalf("read span should be child of remote, span:%+v", read)
+	}
+	if handleSpan.ParentSpanId != read.SpanId || write.ParentSpanId != handleSpan.SpanId || write.TraceId != read.TraceId {
+		t.Fatalf("unexpected span chain, handle:%+v, write:%+v", handleSpan, write)
+	}
+	if closeSpan.ParentSpanId != connect.SpanId || closeSpan.TraceId != connect.TraceId {
+		t.Fatalf("close span should be child of connect, span:%+v", closeSpan)
+	}
+	injected, _ := tracex.ParseTraceparent(reply.GetAttach(tracex.TRACEPARENT).(string))
+	if injected.SpanId != write.SpanId {
+		t.Fatalf("unexpected injected traceparent:%v", reply.GetAttach(tracex.TRACEPARENT))
+	}
+}
+
+// mapTraceCodec 用于测试的编解码，traceparent放在map中
+type mapTraceCodec struct {
+	carrier tracex.MapCarrier
+}
+
+func (codec *mapTraceCodec) Inject(packet IPacket, traceparent string) {
+	codec.carrier.Set(tracex.TRACEPARENT, traceparent)
+}
+
+func (codec *mapTraceCodec) Extract(packet IPacket) string {
+	return codec.carrier.Get(tracex.TRACEPARENT)
+}
+
+func TestChannelTraceCodec(t *testing.T) {
+	exporter := tracex.NewMemoryExporter()
+	tracex.SetTracer(tracex.NewTracer(exporter))
+	defer tracex.SetTracer(nil)
+
+	codec := &mapTraceCodec{carrier: tracex.MapCarrier{}}
+	handle := NewDefChHandle(func(ctx IChHandleContext) {})
+	handle.SetTraceCodec(codec)
+	if CopyChHandle(handle).GetTraceCodec() != codec {
+		t.Fatal("trace codec should be copied.")
+	}
+	ch := newStateChannel(handle)
+	if err := ch.Open(); err != nil {
+		t.Fatalf("open error:%v", err)
+	}
+	defer ch.Release()
+
+	packet := ch.NewPacket()
+	packet.SetData([]byte("hello"))
+	ch.Write(packet)
+	write := exporter.GetSpansByName(tracex.Span_Write)
+	if len(write) != 1 || packet.GetAttach(tracex.TRACEPARENT) != nil {
+		t.Fatalf("unexpected write spans:%v", write)
+	}
+	sc, err := tracex.ParseTraceparent(codec.carrier.Get(tracex.TRACEPARENT))
+	if err != nil || sc.SpanId != write[0].SpanId {
+		t.Fatalf("traceparent should be injected by codec, err:%v", err)
+	}
+
+	// 读取时由codec提取
+	packet = ch.NewPacket()
+	packet.SetData([]byte("hello"))
+	traceRead(ch, packet)
+	read := exporter.GetSpansByName(tracex.Span_Read)
+	if len(read) != 1 || read[0].ParentSpanId != write[0].SpanId {
+		t.Fatalf("unexpected read spans:%v", read)
+	}
+}
+
+func TestChannelTraceBatch(t *testing.T) {
+	exporter := tracex.NewMemoryExporter()
+	tracex.SetTracer(tracex.NewTracer(exporter))
+	defer tracex.SetTracer(nil)
+
+	ch := newStateChannel(NewDefChHandle(func(ctx IChHandleContext) {}))
+	if err := ch.Open(); err != nil {
+		t.Fatalf("open error:%v", err)
+	}
+	defer ch.Release()
+	writeBatch := func(msg string) {
+		packet := ch.NewPacket()
+		packet.SetData([]byte(msg))
+		if err := ch.WriteBatch(packet); err != nil {
+			t.Fatalf("write batch error:%v", err)
+		}
+	}
+
+	// 发送后才结束写入的span
+	writeBatch("a")
+	if spans := exporter.GetSpansByName(tracex.Span_Write); len(spans) != 0 {
+		t.Fatalf("write span should end after flush, spans:%v", spans)
+	}
+	if err := ch.Flush(); err != nil {
+		t.Fatalf("flush error:%v", err)
+	}
+	spans := exporter.GetSpansByName(tracex.Span_Write)
+	if len(spans) != 1 || spans[0].Err != nil || spans[0].Attrs[tracex.Attr_Batch] != true {
+		t.Fatalf("unexpected write spans:%v", spans)
+	}
+
+	// 发送失败时记录发送的错误
+	writeBatch("b")
+	ch.writeErr = errors.New("broken")
+	if err := ch.Flush(); err == nil {
+		t.Fatal("flush should fail.")
+	}
+	spans = exporter.GetSpansByName(tracex.Span_Write)
+	if len(spans) != 2 || !errors.Is(spans[1].Err, ch.writeErr) {
+		t.Fatalf("write span should record flush error, spans:%v", spans)
+	}
+}
+
+func TestChannelTraceHandlePanic(t *testing.T) {
+	exporter := tracex.NewMemoryExporter()
+	tracex.SetTracer(tracex.NewTracer(exporter))
+	defer tracex.SetTracer(nil)
+
+	handle := NewDefChHandle(func(ctx IChHandleContext) {
+		panic("handle error")
+	})
+	ch := newStateChannel(handle)
+	packet := ch.NewPacket()
+	packet.SetData([]byte("hello"))
+	func() {
+		defer func() {
+			// 异常继续抛出
+			if rec := recover(); rec != "handle error" {
+				t.Fatalf("panic should be rethrown, rec:%v", rec)
+			}
+		}()
+		handle.onInnerRead(NewChHandleContext(ch, packet))
+	}()
+	spans := exporter.GetSpansByName(tracex.Span_Handle)
+	if len(spans) != 1 || spans[0].Err == nil || spans[0].Err.Error() != "handle error" {
+		t.Fatalf("handle span should end with panic error, spans:%v", spans)
+	}
+}
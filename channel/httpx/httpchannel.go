@@ -0,0 +1,336 @@
+/*
+ * 基于http的会话通道，用于不支持websocket时的降级，包括sse(Server-Sent Events)和长轮询两种方式，
+ * 通过会话id(sid)把同一个客户端的多次http请求关联到同一个逻辑channel。
+ * 下行(服务端->客户端)通过sse推送或者长轮询获取，上行(客户端->服务端)统一通过POST请求发送。
+ *
+ * Author:slive
+ * DATE:2020/7/17
+ */
+package httpx
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gch "github.com/slive/gsfly/channel"
+	logx "github.com/slive/gsfly/logger"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// KEY_SID 会话id的请求参数
+	KEY_SID = "sid"
+	// HEADER_SID 会话id的响应头
+	HEADER_SID = "X-Gsfly-Sid"
+
+	// SESSION_QUEUE_SIZE 会话收发消息的队列大小
+	SESSION_QUEUE_SIZE = 128
+	// POLL_MAX_MSGS 一次长轮询最多返回的消息数
+	POLL_MAX_MSGS = 64
+)
+
+// ErrSessionTimeout 会话超时
+var ErrSessionTimeout = errors.New("http session timeout")
+
+// HttpChannel 基于http会话的channel
+type HttpChannel struct {
+	gch.Channel
+	sid     string
+	network gch.Network
+	lAddr   net.Addr
+	rAddr   net.Addr
+
+	// 客户端上行的消息
+	readChan chan []byte
+	// 待下发给客户端的消息
+	writeChan chan []byte
+
+	// 最近一次活跃时间(UnixNano)，sse连接期间一直视为活跃
+	lastActive int64
+	streaming  int32
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// NewHttpChannel 创建http会话channel
+// parent 父类
+// network 协议类型，gch.NETWORK_SSE或者gch.NETWORK_POLL
+// sid 会话id
+// chConf channel配置
+// chHandle 处理handle
+// req 创建会话的请求，用于获取地址
+func NewHttpChannel(parent interface{}, network gch.Network, sid string, chConf gch.IChannelConf, chHandle *gch.ChHandle, req *http.Request) *HttpChannel {
+	// 复制一份配置，以便区分协议类型
+	conf := gch.NewDefChannelConf(network)
+	conf.CopyChConf(chConf)
+	ch := &HttpChannel{
+		sid:       sid,
+		network:   network,
+		readChan:  make(chan []byte, SESSION_QUEUE_SIZE),
+		writeChan: make(chan []byte, SESSION_QUEUE_SIZE),
+		done:      make(chan struct{}),
+	}
+	ch.Channel = *gch.NewDefChannel(parent, conf, chHandle, true)
+	lAddr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if ok {
+		ch.lAddr = lAddr
+	}
+	rAddr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+	if err == nil {
+		ch.rAddr = rAddr
+	}
+	ch.active()
+	ch.SetId(sid)
+	return ch
+}
+
+// NewSessionId 生成会话id
+func NewSessionId() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// GetSessionId 会话id
+func (httpCh *HttpChannel) GetSessionId() string {
+	return httpCh.sid
+}
+
+func (httpCh *HttpChannel) Open() error {
+	err := httpCh.StartChannel(httpCh)
+	if err == nil {
+		gch.HandleOnConnnect(gch.NewChHandleContext(httpCh, nil))
+	}
+	return err
+}
+
+func (httpCh *HttpChannel) Release() {
+	httpCh.StopChannel(httpCh)
+	httpCh.doneOnce.Do(func() {
+		close(httpCh.done)
+	})
+}
+
+// active 刷新活跃时间
+func (httpCh *HttpChannel) active() {
+	atomic.StoreInt64(&httpCh.lastActive, time.Now().UnixNano())
+}
+
+// isExpired 会话是否已过期，超过readTimeout*closeRevFailTime未有请求即为过期
+func (httpCh *HttpChannel) isExpired() bool {
+	if atomic.LoadInt32(&httpCh.streaming) > 0 {
+		return false
+	}
+	conf := httpCh.GetConf()
+	timeout := conf.GetReadTimeout() * time.Second * time.Duration(conf.GetCloseRevFailTime())
+	last := atomic.LoadInt64(&httpCh.lastActive)
+	return time.Since(time.Unix(0, last)) > timeout
+}
+
+func (httpCh *HttpChannel) Read() (gch.IPacket, error) {
+	conf := httpCh.GetConf()
+	now := time.Now()
+	timer := time.NewTimer(conf.GetReadTimeout() * time.Second)
+	defer timer.Stop()
+	select {
+	case data := <-httpCh.readChan:
+		datapack := httpCh.NewPacket()
+		datapack.SetData(data)
+		gch.RevStatis(datapack, true)
+		return datapack, nil
+	case <-timer.C:
+		// 没有上行消息不算失败，只有会话过期才结束
+		if httpCh.isExpired() {
+			gch.RevStatisFail(httpCh, now)
+			return nil, ErrSessionTimeout
+		}
+		return nil, nil
+	case <-httpCh.done:
+		return nil, io.EOF
+	}
+}
+
+func (httpCh *HttpChannel) IsReadLoopContinued(err error) bool {
+	return err != ErrSessionTimeout
+}
+
+func (httpCh *HttpChannel) Write(datapack gch.IPacket) error {
+	return httpCh.Channel.Write(datapack)
+}
+
+// WriteByConn 放入待下发队列，等待sse推送或者长轮询获取
+func (httpCh *HttpChannel) WriteByConn(datapacket gch.IPacket) error {
+	conf := httpCh.GetConf()
+	timer := time.NewTimer(conf.GetWriteTimeout() * time.Second)
+	defer timer.Stop()
+	select {
+	case httpCh.writeChan <- datapacket.GetData():
+		return nil
+	case <-timer.C:
+		return gch.NewWriteErrorByKind(httpCh, gch.ErrWriteTimeout, errors.New("write http session timeout, sid:"+httpCh.sid))
+	case <-httpCh.done:
+		return gch.NewWriteErrorByKind(httpCh, gch.ErrChannelClosed, errors.New("http session had closed, sid:"+httpCh.sid))
+	}
+}
+
+// ReceiveRequest 处理客户端上行的POST请求，消息体为一条消息
+func (httpCh *HttpChannel) ReceiveRequest(writer http.ResponseWriter, req *http.Request) {
+	httpCh.active()
+	limit := int64(httpCh.GetConf().GetReadBufSize())
+	data, err := ioutil.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		logx.WarnTracef(httpCh, "read http body error:%v", err)
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > limit {
+		writer.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	timer := time.NewTimer(httpCh.GetConf().GetReadTimeout() * time.Second)
+	defer timer.Stop()
+	select {
+	case httpCh.readChan <- data:
+		writer.Header().Set(HEADER_SID, httpCh.sid)
+		writer.WriteHeader(http.StatusNoContent)
+	case <-timer.C:
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	case <-httpCh.done:
+		writer.WriteHeader(http.StatusGone)
+	}
+}
+
+// ServeSse 以sse方式推送消息，直到请求结束或者channel释放，第一个事件为open，数据为会话id
+func (httpCh *HttpChannel) ServeSse(writer http.ResponseWriter, req *http.Request) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	atomic.AddInt32(&httpCh.streaming, 1)
+	defer func() {
+		atomic.AddInt32(&httpCh.streaming, -1)
+		httpCh.active()
+	}()
+
+	header := writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set(HEADER_SID, httpCh.sid)
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprintf(writer, "event: open\ndata: %v\n\n", httpCh.sid)
+	flusher.Flush()
+
+	// 定时发送注释行，避免代理断开空闲连接
+	ticker := time.NewTicker(httpCh.GetConf().GetReadTimeout() * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case data := <-httpCh.writeChan:
+			for _, line := range strings.Split(string(data), "\n") {
+				fmt.Fprintf(writer, "data: %v\n", line)
+			}
+			fmt.Fprint(writer, "\n")
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(writer, ": ping\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			logx.InfoTracef(httpCh, "sse request done.")
+			return
+		case <-httpCh.done:
+			fmt.Fprint(writer, "event: close\ndata: \n\n")
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// PollResult 长轮询的返回结果
+type PollResult struct {
+	Sid  string   `json:"sid"`
+	Msgs []string `json:"msgs"`
+}
+
+// ServePoll 长轮询，等待最多waitTime直到有消息下发，一次最多返回POLL_MAX_MSGS条消息
+func (httpCh *HttpChannel) ServePoll(writer http.ResponseWriter, req *http.Request, waitTime time.Duration) {
+	httpCh.active()
+	defer httpCh.active()
+	result := &PollResult{Sid: httpCh.sid, Msgs: []string{}}
+	status := http.StatusOK
+	timer := time.NewTimer(waitTime)
+	defer timer.Stop()
+	select {
+	case data := <-httpCh.writeChan:
+		result.Msgs = append(result.Msgs, string(data))
+		// 尽量一次多返回
+	drain:
+		for len(result.Msgs) < POLL_MAX_MSGS {
+			select {
+			case data = <-httpCh.writeChan:
+				result.Msgs = append(result.Msgs, string(data))
+			default:
+				break drain
+			}
+		}
+	case <-timer.C:
+	case <-req.Context().Done():
+		return
+	case <-httpCh.done:
+		status = http.StatusGone
+	}
+
+	header := writer.Header()
+	header.Set("Content-Type", "application/json")
+	header.Set("Cache-Control", "no-cache")
+	header.Set(HEADER_SID, httpCh.sid)
+	writer.WriteHeader(status)
+	err := json.NewEncoder(writer).Encode(result)
+	if err != nil {
+		logx.WarnTracef(httpCh, "write poll result error:%v", err)
+	}
+}
+
+// IsDrained 读协程池已处理完，且待下发的消息已被客户端取走
+func (httpCh *HttpChannel) IsDrained() bool {
+	return httpCh.Channel.IsDrained() && len(httpCh.writeChan) <= 0
+}
+
+func (httpCh *HttpChannel) GetConn() net.Conn {
+	return nil
+}
+
+func (httpCh *HttpChannel) LocalAddr() net.Addr {
+	return httpCh.lAddr
+}
+
+func (httpCh *HttpChannel) RemoteAddr() net.Addr {
+	return httpCh.rAddr
+}
+
+func (httpCh *HttpChannel) NewPacket() gch.IPacket {
+	w := &HttpPacket{}
+	w.Packet = *gch.NewPacket(httpCh, httpCh.network)
+	return w
+}
+
+// HttpPacket http会话包，数据按文本处理
+type HttpPacket struct {
+	gch.Packet
+}
@@ -57,7 +57,7 @@ func (tcpCh *TcpChannel) Read() (gch.IPacket, error) {
 	conf := tcpCh.GetConf()
 	now := time.Now()
 	tcpCh.Conn.SetReadDeadline(now.Add(conf.GetReadTimeout() * time.Second))
-	readbf := tcpCh.NewReadBuf()
+	readbf := tcpCh.GetReadBuf()
 	readNum, err := tcpCh.Conn.Read(readbf)
 	if err != nil {
 		logx.Warn("read udp err:", err)
@@ -65,9 +65,9 @@ func (tcpCh *TcpChannel) Read() (gch.IPacket, error) {
 		return nil, err
 	}
 
-	bytes := readbf[0:readNum]
+	// 读缓冲复用，按实际大小从缓冲池复制
 	datapack := tcpCh.NewPacket()
-	datapack.SetData(bytes)
+	datapack.SetBuf(gch.CopyBuf(readbf[0:readNum]))
 	gch.RevStatis(datapack, true)
 	return datapack, err
 }
@@ -82,10 +82,22 @@ func (tcpCh *TcpChannel) WriteByConn(datapacket gch.IPacket) error {
 	tcpCh.Conn.SetWriteDeadline(time.Now().Add(conf.GetWriteTimeout() * time.Second))
 	_, err := tcpCh.Conn.Write(bytes)
 	if err != nil {
-		logx.Error("write tcp error:", err)
-		gch.SendStatis(datapacket, false)
-		panic(err)
-		return err
+		return gch.NewWriteError(tcpCh, err)
+	}
+	return nil
+}
+
+// WriteBatchByConn 通过writev一次发送多个包
+func (tcpCh *TcpChannel) WriteBatchByConn(datapackets []gch.IPacket) error {
+	buffers := make(net.Buffers, 0, len(datapackets))
+	for _, datapacket := range datapackets {
+		buffers = append(buffers, datapacket.GetData())
+	}
+	conf := tcpCh.GetConf()
+	tcpCh.Conn.SetWriteDeadline(time.Now().Add(conf.GetWriteTimeout() * time.Second))
+	_, err := buffers.WriteTo(tcpCh.Conn)
+	if err != nil {
+		return gch.NewWriteError(tcpCh, err)
 	}
 	return nil
 }
@@ -0,0 +1,158 @@
+/*
+ * Author:slive
+ * DATE:2020/9/26
+ */
+package tcpx
+
+import (
+	"bytes"
+	gch "github.com/slive/gsfly/channel"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tcpPair 建立tcp连接，返回已打开的服务端和客户端channel
+func tcpPair(t *testing.T, serverHandle *gch.ChHandle, clientHandle *gch.ChHandle) (*TcpChannel, *TcpChannel) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen error:%v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan *net.TCPConn, 1)
+	go func() {
+		conn, err := listener.AcceptTCP()
+		if err != nil {
+			t.Errorf("accept error:%v", err)
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	conn, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatalf("dial error:%v", err)
+	}
+	serverConn := <-accepted
+	if serverConn == nil {
+		t.FailNow()
+	}
+
+	conf := gch.NewDefChannelConf(gch.NETWORK_TCP)
+	server := NewTcpChannel(nil, serverConn, conf, serverHandle, true)
+	client := NewTcpChannel(nil, conn, conf, clientHandle, false)
+	for _, ch := range []*TcpChannel{server, client} {
+		if err := ch.Open(); err != nil {
+			t.Fatalf("open error:%v", err)
+		}
+	}
+	t.Cleanup(func() {
+		client.Release()
+		server.Release()
+	})
+	return server, client
+}
+
+// tcpRecorder 收集收到的字节，tcp是流，只比较合并后的内容
+type tcpRecorder struct {
+	mut  sync.Mutex
+	data []byte
+}
+
+func (r *tcpRecorder) handle() *gch.ChHandle {
+	return gch.NewDefChHandle(func(ctx gch.IChHandleContext) {
+		r.mut.Lock()
+		defer r.mut.Unlock()
+		r.data = append(r.data, ctx.GetPacket().GetData()...)
+	})
+}
+
+func (r *tcpRecorder) wait(t *testing.T, expect []byte) {
+	for i := 0; i < 300; i++ {
+		r.mut.Lock()
+		data := append([]byte(nil), r.data...)
+		r.mut.Unlock()
+		if bytes.Equal(data, expect) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("wait data timeout, expect:%v", string(expect))
+}
+
+func newTcpPackets(ch *TcpChannel, msgs ...string) []gch.IPacket {
+	packets := make([]gch.IPacket, 0, len(msgs))
+	for _, msg := range msgs {
+		packet := ch.NewPacket()
+		packet.SetData([]byte(msg))
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+func TestTcpWriteBatch(t *testing.T) {
+	clientRev := &tcpRecorder{}
+	serverHandle := gch.NewDefChHandle(func(ctx gch.IChHandleContext) {
+		// 批量回写收到的数据
+		ch := ctx.GetChannel()
+		packet := ch.NewPacket()
+		packet.SetData(append([]byte(nil), ctx.GetPacket().GetData()...))
+		ch.WriteBatch(packet)
+		ch.Flush()
+	})
+	_, client := tcpPair(t, serverHandle, clientRev.handle())
+
+	if err := client.WriteBatch(newTcpPackets(client, "a", "bb", "ccc")...); err != nil {
+		t.Fatalf("write batch error:%v", err)
+	}
+	// 未Flush前不发送
+	time.Sleep(50 * time.Millisecond)
+	clientRev.mut.Lock()
+	if len(clientRev.data) != 0 {
+		t.Fatalf("batch should be cached before flush, data:%v", string(clientRev.data))
+	}
+	clientRev.mut.Unlock()
+	if err := client.Flush(); err != nil {
+		t.Fatalf("flush error:%v", err)
+	}
+	clientRev.wait(t, []byte("abbccc"))
+
+	// Write前先发送已缓存的包，保证顺序
+	client.WriteBatch(newTcpPackets(client, "d")...)
+	client.Write(newTcpPackets(client, "e")[0])
+	clientRev.wait(t, []byte("abbcccde"))
+	if statis := client.GetChStatis().SendStatics; statis.GetTotalPacketNum() != 5 {
+		t.Fatalf("unexpected send statis:%v", statis)
+	}
+}
+
+func TestTcpWriteBatchRelease(t *testing.T) {
+	serverRev := &tcpRecorder{}
+	_, client := tcpPair(t, serverRev.handle(), gch.NewDefChHandle(func(ctx gch.IChHandleContext) {}))
+
+	// 关闭时先发送已缓存的包
+	client.WriteBatch(newTcpPackets(client, "a", "b")...)
+	client.Release()
+	serverRev.wait(t, []byte("ab"))
+
+	packets := newTcpPackets(client, "c")
+	if err := client.WriteBatch(packets...); err == nil {
+		t.Fatal("write batch after release should fail.")
+	}
+
+	// conn已关闭，发送失败的包被释放
+	_, client = tcpPair(t, serverRev.handle(), gch.NewDefChHandle(func(ctx gch.IChHandleContext) {}))
+	packets = newTcpPackets(client, "d", "e")
+	client.WriteBatch(packets...)
+	client.Conn.Close()
+	client.Release()
+	for _, packet := range packets {
+		if !packet.IsRelease() {
+			t.Fatal("unsent batch packet should be released.")
+		}
+	}
+	if client.Flush() != nil {
+		t.Fatal("batch should be empty after release.")
+	}
+}
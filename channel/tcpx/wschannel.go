@@ -5,23 +5,68 @@
 package tcpx
 
 import (
+	"bufio"
+	"errors"
 	gch "github.com/slive/gsfly/channel"
 	logx "github.com/slive/gsfly/logger"
 	gws "github.com/gorilla/websocket"
+	"io"
+	"io/ioutil"
 	"net"
+	"sync"
 	"time"
 )
 
+const (
+	// KEY_WS_CLOSE_CODE 释放时ctx中存放关闭码的附件key，值为int
+	KEY_WS_CLOSE_CODE = "ws-close-code"
+	// KEY_WS_CLOSE_REASON 释放时ctx中存放关闭原因的附件key，值为string
+	KEY_WS_CLOSE_REASON = "ws-close-reason"
+)
+
 // WsChannel
 type WsChannel struct {
 	gch.Channel
 	Conn   *gws.Conn
 	params map[string]interface{}
+	// 压缩配置，可为nil
+	compressConf IWsCompressConf
+
+	// 关闭握手相关，记录关闭码和原因
+	closeMut    sync.Mutex
+	closeCode   int
+	closeReason string
+	// 收到对端关闭帧或读取结束时通知
+	closeAck chan bool
+
+	// 流式读取配置，可为nil
+	streamConf IWsStreamConf
+	// 当前正在分片读取的消息，只在读协程中使用
+	streamReader *bufio.Reader
+	streamType   int
+	streamSeq    int
+	// streamErr 预读时的异常，先返回已读取的分片，下次读取时再返回
+	streamErr error
+
+	// 写锁，gorilla只支持一个并发写
+	writeMut sync.Mutex
 }
 
 func newWsChannel(parent interface{}, wsconn *gws.Conn, conf gch.IChannelConf, chHandle *gch.ChHandle, params map[string]interface{}, server bool) *WsChannel {
-	ch := &WsChannel{Conn: wsconn, params: params}
+	ch := &WsChannel{Conn: wsconn, params: params, closeAck: make(chan bool, 1)}
 	ch.Channel = *gch.NewDefChannel(parent, conf, chHandle, server)
+	compressConf, ok := conf.(IWsCompressConf)
+	if ok && compressConf.IsCompressEnable() {
+		ch.compressConf = compressConf
+		err := wsconn.SetCompressionLevel(compressConf.GetCompressLevel())
+		if err != nil {
+			logx.WarnTracef(ch, "set compression level error:%v", err)
+		}
+	}
+	streamConf, ok := conf.(IWsStreamConf)
+	if ok && streamConf.IsStreamEnable() {
+		ch.streamConf = streamConf
+	}
 	return ch
 }
 
@@ -33,7 +78,16 @@ func NewWsSimpleChannel(parent interface{}, wsConn *gws.Conn, chConf gch.IChanne
 // NewWsChannel 创建WsChannel
 func NewWsChannel(parent interface{}, wsConn *gws.Conn, chConf gch.IChannelConf, chHandle *gch.ChHandle, params map[string]interface{}, server bool) *WsChannel {
 	ch := newWsChannel(parent, wsConn, chConf, chHandle, params, server)
-	wsConn.SetReadLimit(int64(chConf.GetReadBufSize()))
+	if ch.streamConf != nil {
+		// 流式读取时，非流式消息的大小在readMessage中限制
+		maxSize := ch.streamConf.GetStreamMaxSize()
+		if maxSize < 0 {
+			maxSize = 0
+		}
+		wsConn.SetReadLimit(maxSize)
+	} else {
+		wsConn.SetReadLimit(int64(chConf.GetReadBufSize()))
+	}
 	ch.SetId(wsConn.LocalAddr().String() + "->" + wsConn.RemoteAddr().String())
 	return ch
 }
@@ -46,8 +100,80 @@ func (wsCh *WsChannel) Open() error {
 	return err
 }
 
+// Release 释放通道，onRelease的ctx中可通过GetWsCloseInfo获取关闭码和原因
 func (wsCh *WsChannel) Release() {
-	wsCh.StopChannel(wsCh)
+	ctx := gch.NewChHandleContext(wsCh, nil)
+	code, reason := wsCh.GetCloseInfo()
+	ctx.AddAttach(KEY_WS_CLOSE_CODE, code)
+	ctx.AddAttach(KEY_WS_CLOSE_REASON, reason)
+	wsCh.StopChannelWithContext(ctx)
+}
+
+// Close 发送关闭帧，等待对端回复关闭帧(超时时间为写超时时间)后释放通道
+// code 关闭码，如gws.CloseNormalClosure
+// reason 关闭原因
+func (wsCh *WsChannel) Close(code int, reason string) error {
+	if wsCh.IsClosed() {
+		return errors.New("wschannel had closed, chId:" + wsCh.GetId())
+	}
+
+	logx.InfoTracef(wsCh, "start to close ws, code:%v, reason:%v", code, reason)
+	wsCh.setCloseInfo(code, reason, false)
+	timeout := wsCh.GetConf().GetWriteTimeout() * time.Second
+	msg := gws.FormatCloseMessage(code, reason)
+	err := wsCh.Conn.WriteControl(gws.CloseMessage, msg, time.Now().Add(timeout))
+	if err != nil {
+		logx.WarnTracef(wsCh, "write close message error:%v", err)
+		wsCh.Release()
+		return err
+	}
+
+	// 等待对端回复关闭帧
+	select {
+	case <-wsCh.closeAck:
+		logx.InfoTracef(wsCh, "receive close reply.")
+	case <-time.After(timeout):
+		logx.WarnTracef(wsCh, "wait close reply timeout.")
+	}
+	wsCh.Release()
+	return nil
+}
+
+// GetCloseInfo 获取关闭码和原因，优先为对端发送的关闭帧，
+// 若未收到任何关闭帧，则为gws.CloseAbnormalClosure
+func (wsCh *WsChannel) GetCloseInfo() (int, string) {
+	wsCh.closeMut.Lock()
+	defer wsCh.closeMut.Unlock()
+	if wsCh.closeCode == 0 {
+		return gws.CloseAbnormalClosure, ""
+	}
+	return wsCh.closeCode, wsCh.closeReason
+}
+
+// setCloseInfo 记录关闭码和原因
+// inbound 是否为对端发送的关闭帧，对端发送的会覆盖本地的
+func (wsCh *WsChannel) setCloseInfo(code int, reason string, inbound bool) {
+	wsCh.closeMut.Lock()
+	defer wsCh.closeMut.Unlock()
+	if inbound || wsCh.closeCode == 0 {
+		wsCh.closeCode = code
+		wsCh.closeReason = reason
+	}
+}
+
+// GetWsCloseInfo 从onRelease的ctx中获取ws的关闭码和原因
+func GetWsCloseInfo(ctx gch.IChHandleContext) (int, string) {
+	code := gws.CloseAbnormalClosure
+	reason := ""
+	c, ok := ctx.GetAttach(KEY_WS_CLOSE_CODE).(int)
+	if ok {
+		code = c
+	}
+	r, ok := ctx.GetAttach(KEY_WS_CLOSE_REASON).(string)
+	if ok {
+		reason = r
+	}
+	return code, reason
 }
 
 func (wsCh *WsChannel) Read() (gch.IPacket, error) {
@@ -58,21 +184,39 @@ func (wsCh *WsChannel) Read() (gch.IPacket, error) {
 	duration := conf.GetReadTimeout() * time.Second * failTime
 	// 一次失败都会失败
 	wsCh.Conn.SetReadDeadline(now.Add(duration))
-	msgType, data, err := wsCh.readMessage()
+	msgType, data, seq, end, err := wsCh.readMessage()
 	if err != nil {
 		logx.WarnTracef(wsCh, "read ws err:%v", err)
+		closeErr, ok := err.(*gws.CloseError)
+		if ok {
+			// 对端发送的关闭帧
+			wsCh.setCloseInfo(closeErr.Code, closeErr.Text, true)
+		}
+		// 读取结束，通知等待关闭的一方
+		select {
+		case wsCh.closeAck <- true:
+		default:
+		}
+		if gws.IsCloseError(err, gws.CloseNormalClosure, gws.CloseGoingAway) {
+			// 正常关闭，由读协程直接释放，onRelease中可获取关闭码和原因
+			return nil, gch.ErrNormalClosure
+		}
 		gch.RevStatisFail(wsCh, now)
 		return nil, err
 	}
 
 	wspacket := wsCh.NewPacket().(*WsPacket)
 	wspacket.MsgType = msgType
+	wspacket.StreamSeq = seq
+	wspacket.StreamEnd = end
 	wspacket.SetData(data)
 	gch.RevStatis(wspacket, true)
 	return wspacket, err
 }
 
-func (wsCh *WsChannel) readMessage() (messageType int, p []byte, err error) {
+// readMessage 读取消息，未开启流式读取时同Conn.ReadMessage；
+// 开启后超过分片大小的二进制消息按分片返回，seq为分片序号(非流式消息为-1)，end表示是否为最后一个分片
+func (wsCh *WsChannel) readMessage() (messageType int, p []byte, seq int, end bool, err error) {
 	defer func() {
 		rec := recover()
 		if rec != nil {
@@ -82,7 +226,86 @@ func (wsCh *WsChannel) readMessage() (messageType int, p []byte, err error) {
 			}
 		}
 	}()
-	return wsCh.Conn.ReadMessage()
+
+	seq = -1
+	end = true
+	if wsCh.streamConf == nil {
+		messageType, p, err = wsCh.Conn.ReadMessage()
+		return
+	}
+
+	if wsCh.streamErr != nil {
+		err = wsCh.streamErr
+		wsCh.streamErr = nil
+		wsCh.streamReader = nil
+		return
+	}
+
+	if wsCh.streamReader == nil {
+		var reader io.Reader
+		messageType, reader, err = wsCh.Conn.NextReader()
+		if err != nil {
+			return
+		}
+		if messageType != gws.BinaryMessage {
+			// 非二进制消息仍受ReadBufSize限制
+			p, err = readLimit(reader, int64(wsCh.GetConf().GetReadBufSize()))
+			return
+		}
+		wsCh.streamReader = bufio.NewReader(reader)
+		wsCh.streamType = messageType
+		wsCh.streamSeq = -1
+	}
+
+	messageType = wsCh.streamType
+	chunk := make([]byte, wsCh.getStreamChunkSize())
+	readNum, err := io.ReadFull(wsCh.streamReader, chunk)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// 不足一个分片，消息已结束
+		err = nil
+	} else if err == nil {
+		// 刚好读满一个分片，预读判断消息是否结束
+		_, perr := wsCh.streamReader.Peek(1)
+		end = (perr == io.EOF)
+		if perr != nil && !end {
+			// 先返回已读取的分片，下次读取时再返回异常
+			wsCh.streamErr = perr
+		}
+	}
+	if err != nil {
+		wsCh.streamReader = nil
+		return
+	}
+
+	if !end || wsCh.streamSeq >= 0 {
+		// 第一个分片就结束的，作为普通消息处理
+		wsCh.streamSeq++
+		seq = wsCh.streamSeq
+	}
+	if end {
+		wsCh.streamReader = nil
+	}
+	p = chunk[0:readNum]
+	return
+}
+
+// getStreamChunkSize 获取分片大小，不超过ReadBufSize
+func (wsCh *WsChannel) getStreamChunkSize() int {
+	chunkSize := wsCh.streamConf.GetStreamChunkSize()
+	readBufSize := wsCh.GetConf().GetReadBufSize()
+	if chunkSize > readBufSize {
+		chunkSize = readBufSize
+	}
+	return chunkSize
+}
+
+// readLimit 读取reader所有内容，超过limit时返回gws.ErrReadLimit
+func readLimit(reader io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+	if err == nil && int64(len(data)) > limit {
+		err = gws.ErrReadLimit
+	}
+	return data, err
 }
 
 func (wsCh *WsChannel) IsReadLoopContinued(err error) bool {
@@ -98,18 +321,123 @@ func (wsCh *WsChannel) WriteByConn(datapacket gch.IPacket) error {
 	wspacket := datapacket.(*WsPacket)
 	data := wspacket.GetData()
 	conf := wsCh.GetConf()
+	wsCh.writeMut.Lock()
+	defer wsCh.writeMut.Unlock()
 	// TODO 设置超时?
 	wsCh.Conn.SetWriteDeadline(time.Now().Add(conf.GetWriteTimeout() * time.Second))
+	// 是否压缩，只有握手时协商成功才真正生效
+	wsCh.Conn.EnableWriteCompression(wsCh.isCompress(wspacket))
 	err := wsCh.Conn.WriteMessage(wspacket.MsgType, data)
 	if err != nil {
-		logx.Error("write ws error:", err)
-		gch.SendStatis(wspacket, false)
-		panic(err)
-		return err
+		return wsCh.newWriteError(err)
 	}
 	return nil
 }
 
+// newWriteError ws的写入异常，已发送关闭帧的视为已关闭
+func (wsCh *WsChannel) newWriteError(err error) error {
+	if err == gws.ErrCloseSent {
+		return gch.NewWriteErrorByKind(wsCh, gch.ErrChannelClosed, err)
+	}
+	return gch.NewWriteError(wsCh, err)
+}
+
+// WriteBatchByConn 持有写锁依次写入多个消息，期间不会插入其他写入
+func (wsCh *WsChannel) WriteBatchByConn(datapackets []gch.IPacket) error {
+	conf := wsCh.GetConf()
+	wsCh.writeMut.Lock()
+	defer wsCh.writeMut.Unlock()
+	wsCh.Conn.SetWriteDeadline(time.Now().Add(conf.GetWriteTimeout() * time.Second))
+	for _, datapacket := range datapackets {
+		wspacket := datapacket.(*WsPacket)
+		wsCh.Conn.EnableWriteCompression(wsCh.isCompress(wspacket))
+		err := wsCh.Conn.WriteMessage(wspacket.MsgType, wspacket.GetData())
+		if err != nil {
+			return wsCh.newWriteError(err)
+		}
+	}
+	return nil
+}
+
+// NextWriter 获取流式写入一个消息的writer，写完后必须调用Close，Close之前其他的写入都会等待
+// msgType ws消息类型，如gws.BinaryMessage
+func (wsCh *WsChannel) NextWriter(msgType int) (io.WriteCloser, error) {
+	if wsCh.IsClosed() {
+		return nil, errors.New("wschannel had closed, chId:" + wsCh.GetId())
+	}
+
+	wsCh.writeMut.Lock()
+	writer, err := wsCh.Conn.NextWriter(msgType)
+	if err != nil {
+		wsCh.writeMut.Unlock()
+		logx.ErrorTracef(wsCh, "next writer error:%v", err)
+		return nil, err
+	}
+	return &wsStreamWriter{wsCh: wsCh, writer: writer}, nil
+}
+
+// WriteStream 将reader中的内容作为一个消息流式写入，返回写入的字节数
+// msgType ws消息类型，如gws.BinaryMessage
+func (wsCh *WsChannel) WriteStream(msgType int, reader io.Reader) (int64, error) {
+	writer, err := wsCh.NextWriter(msgType)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(writer, reader)
+	cerr := writer.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		logx.ErrorTracef(wsCh, "write stream error:%v", err)
+	}
+	return written, err
+}
+
+// wsStreamWriter 流式写入，每次写入都会重新设置写超时
+type wsStreamWriter struct {
+	wsCh   *WsChannel
+	writer io.WriteCloser
+	closed bool
+}
+
+func (w *wsStreamWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("stream writer had closed.")
+	}
+	conf := w.wsCh.GetConf()
+	w.wsCh.Conn.SetWriteDeadline(time.Now().Add(conf.GetWriteTimeout() * time.Second))
+	return w.writer.Write(p)
+}
+
+// Close 结束消息并释放写锁
+func (w *wsStreamWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.wsCh.writeMut.Unlock()
+	conf := w.wsCh.GetConf()
+	w.wsCh.Conn.SetWriteDeadline(time.Now().Add(conf.GetWriteTimeout() * time.Second))
+	return w.writer.Close()
+}
+
+// isCompress 判断packet是否需要压缩，packet的设置优先于配置
+func (wsCh *WsChannel) isCompress(wspacket *WsPacket) bool {
+	switch wspacket.Compress {
+	case WS_COMPRESS_ON:
+		return true
+	case WS_COMPRESS_OFF:
+		return false
+	default:
+		compressConf := wsCh.compressConf
+		if compressConf == nil {
+			return false
+		}
+		return len(wspacket.GetData()) >= compressConf.GetCompressMinSize()
+	}
+}
+
 // GetConn Deprecated
 func (wsCh *WsChannel) GetConn() net.Conn {
 	return wsCh.Conn.UnderlyingConn()
@@ -133,12 +461,39 @@ func (wsCh *WsChannel) NewPacket() gch.IPacket {
 	w.Packet = *gch.NewPacket(wsCh, gch.NETWORK_WS)
 	// 默认TextMessage 文本类型
 	w.MsgType = gws.TextMessage
+	w.StreamSeq = -1
 	return w
 }
 
+// WsCompressMode packet的压缩模式
+type WsCompressMode int
+
+const (
+	// WS_COMPRESS_DEF 跟随配置
+	WS_COMPRESS_DEF WsCompressMode = iota
+	// WS_COMPRESS_ON 强制压缩
+	WS_COMPRESS_ON
+	// WS_COMPRESS_OFF 不压缩
+	WS_COMPRESS_OFF
+)
+
 type WsPacket struct {
 	gch.Packet
 
 	// ws类型
 	MsgType int
+
+	// Compress 压缩模式，默认跟随配置
+	Compress WsCompressMode
+
+	// StreamSeq 流式消息的分片序号，从0开始，非流式消息为-1
+	StreamSeq int
+
+	// StreamEnd 是否为流式消息的最后一个分片
+	StreamEnd bool
+}
+
+// IsStream 是否为流式消息的分片
+func (wsPacket *WsPacket) IsStream() bool {
+	return wsPacket.StreamSeq >= 0
 }
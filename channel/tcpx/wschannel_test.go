@@ -0,0 +1,130 @@
+/*
+ * Author:slive
+ * DATE:2020/9/26
+ */
+package tcpx
+
+import (
+	"bytes"
+	gws "github.com/gorilla/websocket"
+	gch "github.com/slive/gsfly/channel"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsTestConf 用于测试的ws配置，包括压缩和流式读取
+type wsTestConf struct {
+	*gch.ChannelConf
+	*WsCompressConf
+	*WsStreamConf
+}
+
+func newWsTestConf(compress bool, stream bool) *wsTestConf {
+	chConf := gch.NewDefChannelConf(gch.NETWORK_WS)
+	chConf.ReadBufSize = 4096
+	return &wsTestConf{
+		ChannelConf:    chConf,
+		WsCompressConf: NewWsCompressConf(compress, 0, 16),
+		WsStreamConf:   NewWsStreamConf(stream, 1024, 0),
+	}
+}
+
+// wsPair 通过httptest建立ws连接，返回已打开的服务端和客户端channel，以及握手的response
+func wsPair(t *testing.T, conf *wsTestConf, serverHandle *gch.ChHandle, clientHandle *gch.ChHandle) (*WsChannel, *WsChannel, *http.Response) {
+	serverCh := make(chan *WsChannel, 1)
+	upgrader := gws.Upgrader{EnableCompression: conf.IsCompressEnable()}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(writer, req, nil)
+		if err != nil {
+			t.Errorf("upgrade error:%v", err)
+			return
+		}
+		ch := NewWsChannel(nil, conn, conf, serverHandle, nil, true)
+		if err := ch.Open(); err != nil {
+			t.Errorf("open server error:%v", err)
+		}
+		serverCh <- ch
+	}))
+	t.Cleanup(server.Close)
+
+	dialer := gws.Dialer{EnableCompression: conf.IsCompressEnable()}
+	conn, response, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial error:%v", err)
+	}
+	client := NewWsChannel(nil, conn, conf, clientHandle, nil, false)
+	if err := client.Open(); err != nil {
+		t.Fatalf("open client error:%v", err)
+	}
+	var serverWs *WsChannel
+	select {
+	case serverWs = <-serverCh:
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait server channel timeout.")
+	}
+	t.Cleanup(func() {
+		client.Release()
+		serverWs.Release()
+	})
+	return serverWs, client, response
+}
+
+// revPackets 收集收到的ws包
+func revPackets(rev chan *WsPacket) *gch.ChHandle {
+	return gch.NewDefChHandle(func(ctx gch.IChHandleContext) {
+		packet := ctx.GetPacket().(*WsPacket)
+		copyPacket := &WsPacket{MsgType: packet.MsgType, StreamSeq: packet.StreamSeq, StreamEnd: packet.StreamEnd}
+		copyPacket.SetData(append([]byte(nil), packet.GetData()...))
+		rev <- copyPacket
+	})
+}
+
+func waitPacket(t *testing.T, rev chan *WsPacket) *WsPacket {
+	select {
+	case packet := <-rev:
+		return packet
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait packet timeout.")
+	}
+	return nil
+}
+
+func TestWsCompress(t *testing.T) {
+	rev := make(chan *WsPacket, 10)
+	serverWs, client, response := wsPair(t, newWsTestConf(true, false), revPackets(rev), revPackets(make(chan *WsPacket, 10)))
+	if !strings.Contains(response.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate") {
+		t.Fatalf("compression should be negotiated, header:%v", response.Header)
+	}
+
+	data := bytes.Repeat([]byte("compress"), 256)
+	for _, mode := range []WsCompressMode{WS_COMPRESS_DEF, WS_COMPRESS_ON, WS_COMPRESS_OFF} {
+		packet := client.NewPacket().(*WsPacket)
+		packet.Compress = mode
+		packet.SetData(data)
+		if err := client.Write(packet); err != nil {
+			t.Fatalf("write error:%v", err)
+		}
+		if got := waitPacket(t, rev); !bytes.Equal(got.GetData(), data) {
+			t.Fatalf("unexpected data, mode:%v, len:%v", mode, len(got.GetData()))
+		}
+	}
+
+	// packet的设置优先，未设置时超过最小字节数才压缩
+	packet := serverWs.NewPacket().(*WsPacket)
+	packet.SetData([]byte("short"))
+	if serverWs.isCompress(packet) {
+		t.Fatal("short message should not compress.")
+	}
+	packet.Compress = WS_COMPRESS_ON
+	if !serverWs.isCompress(packet) {
+		t.Fatal("packet compress mode should take precedence.")
+	}
+	packet.Compress = WS_COMPRESS_OFF
+	packet.SetData(data)
+	if serverWs.isCompress(packet) {
+		t.Fatal("packet compress mode should take precedence.")
+	}
+}
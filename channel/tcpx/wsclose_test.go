@@ -0,0 +1,108 @@
+/*
+ * Author:slive
+ * DATE:2020/9/26
+ */
+package tcpx
+
+import (
+	gws "github.com/gorilla/websocket"
+	gch "github.com/slive/gsfly/channel"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWsCloseHandshake(t *testing.T) {
+	type closeInfo struct {
+		code   int
+		reason string
+	}
+	serverInfo := make(chan closeInfo, 1)
+	clientInfo := make(chan closeInfo, 1)
+	serverHandle := gch.NewDefChHandle(func(ctx gch.IChHandleContext) {})
+	serverHandle.SetOnRelease(func(ctx gch.IChHandleContext) {
+		code, reason := GetWsCloseInfo(ctx)
+		serverInfo <- closeInfo{code, reason}
+	})
+	clientHandle := gch.NewDefChHandle(func(ctx gch.IChHandleContext) {})
+	clientHandle.SetOnRelease(func(ctx gch.IChHandleContext) {
+		code, reason := GetWsCloseInfo(ctx)
+		clientInfo <- closeInfo{code, reason}
+	})
+	_, client, _ := wsPair(t, newWsTestConf(false, false), serverHandle, clientHandle)
+
+	start := time.Now()
+	if err := client.Close(4000, "bye"); err != nil {
+		t.Fatalf("close error:%v", err)
+	}
+	// 收到对端的回复，不需等待超时
+	if time.Since(start) >= client.GetConf().GetWriteTimeout()*time.Second {
+		t.Fatal("close should not wait for timeout.")
+	}
+	if !client.IsClosed() || client.Close(1000, "") == nil {
+		t.Fatal("close twice should fail.")
+	}
+
+	select {
+	case info := <-serverInfo:
+		if info.code != 4000 || info.reason != "bye" {
+			t.Fatalf("unexpected server close info:%+v", info)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait server release timeout.")
+	}
+	info := <-clientInfo
+	if info.code != 4000 {
+		t.Fatalf("unexpected client close info:%+v", info)
+	}
+
+	// 未收到关闭帧时为CloseAbnormalClosure
+	code, _ := GetWsCloseInfo(gch.NewChHandleContext(client, nil))
+	if code != gws.CloseAbnormalClosure {
+		t.Fatalf("unexpected default code:%v", code)
+	}
+}
+
+func TestWsNormalClose(t *testing.T) {
+	var errNum int32
+	released := make(chan int, 2)
+	newHandle := func() *gch.ChHandle {
+		handle := gch.NewDefChHandle(func(ctx gch.IChHandleContext) {})
+		handle.SetOnError(func(ctx gch.IChHandleContext) {
+			atomic.AddInt32(&errNum, 1)
+		})
+		handle.SetOnRelease(func(ctx gch.IChHandleContext) {
+			code, _ := GetWsCloseInfo(ctx)
+			released <- code
+		})
+		return handle
+	}
+	server, client, _ := wsPair(t, newWsTestConf(false, false), newHandle(), newHandle())
+
+	// 服务端直接发送关闭帧，客户端回复后双方的读协程都正常结束并释放
+	msg := gws.FormatCloseMessage(gws.CloseGoingAway, "going away")
+	if err := server.Conn.WriteControl(gws.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("write close error:%v", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case code := <-released:
+			if code != gws.CloseGoingAway {
+				t.Fatalf("unexpected close code:%v", code)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("wait release timeout.")
+		}
+	}
+	if !client.IsClosed() || !server.IsClosed() {
+		t.Fatal("channels should be released after normal close.")
+	}
+	// 正常关闭不视为读取异常
+	time.Sleep(50 * time.Millisecond)
+	if num := atomic.LoadInt32(&errNum); num != 0 {
+		t.Fatalf("onError should not be called for normal close, num:%v", num)
+	}
+	if fail := client.GetChStatis().RevStatics.GetTotalFailPacketNum(); fail != 0 {
+		t.Fatalf("normal close should not count as read fail:%v", fail)
+	}
+}
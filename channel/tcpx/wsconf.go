@@ -0,0 +1,133 @@
+/*
+ * websocket相关的扩展配置
+ * Author:slive
+ * DATE:2020/7/17
+ */
+package tcpx
+
+const (
+	// WS_COMPRESS_LEVEL 默认压缩级别，同gorilla默认值
+	WS_COMPRESS_LEVEL = 1
+	// WS_COMPRESS_MINSIZE 默认超过该字节数(byte)才压缩
+	WS_COMPRESS_MINSIZE = 512
+)
+
+// IWsCompressConf ws压缩(permessage-deflate)配置接口
+type IWsCompressConf interface {
+	// IsCompressEnable 是否开启压缩
+	IsCompressEnable() bool
+
+	// GetCompressLevel 压缩级别，同flate，范围为[-2, 9]
+	GetCompressLevel() int
+
+	// GetCompressMinSize 消息超过该字节数(byte)才压缩
+	GetCompressMinSize() int
+}
+
+// WsCompressConf ws压缩(permessage-deflate)配置
+type WsCompressConf struct {
+	// CompressEnable 是否开启压缩，需双方都支持才生效
+	CompressEnable bool
+
+	// CompressLevel 压缩级别，同flate，范围为[-2, 9]，为0或超出范围时取默认值
+	CompressLevel int
+
+	// CompressMinSize 消息超过该字节数(byte)才压缩，<=0时取默认值
+	CompressMinSize int
+}
+
+// NewWsCompressConf 创建压缩配置
+// enable 是否开启压缩
+// level 压缩级别
+// minSize 超过该字节数才压缩
+func NewWsCompressConf(enable bool, level int, minSize int) *WsCompressConf {
+	return &WsCompressConf{
+		CompressEnable:  enable,
+		CompressLevel:   level,
+		CompressMinSize: minSize,
+	}
+}
+
+// IsCompressEnable 是否开启压缩
+func (conf *WsCompressConf) IsCompressEnable() bool {
+	return conf.CompressEnable
+}
+
+// GetCompressLevel 压缩级别
+func (conf *WsCompressConf) GetCompressLevel() int {
+	level := conf.CompressLevel
+	if level == 0 || level < -2 || level > 9 {
+		level = WS_COMPRESS_LEVEL
+	}
+	return level
+}
+
+// GetCompressMinSize 消息超过该字节数(byte)才压缩
+func (conf *WsCompressConf) GetCompressMinSize() int {
+	ret := conf.CompressMinSize
+	if ret <= 0 {
+		ret = WS_COMPRESS_MINSIZE
+	}
+	return ret
+}
+
+const (
+	// WS_STREAM_CHUNKSIZE 默认流式消息每个分片的字节数(byte)
+	WS_STREAM_CHUNKSIZE = 64 * 1024
+)
+
+// IWsStreamConf ws流式消息配置接口，开启后超过分片大小的二进制消息会分片投递，
+// 不再受ReadBufSize的限制
+type IWsStreamConf interface {
+	// IsStreamEnable 是否开启流式读取
+	IsStreamEnable() bool
+
+	// GetStreamChunkSize 每个分片的字节数(byte)，不超过ReadBufSize
+	GetStreamChunkSize() int
+
+	// GetStreamMaxSize 流式消息最大字节数(byte)，<=0时不限制
+	GetStreamMaxSize() int64
+}
+
+// WsStreamConf ws流式消息配置
+type WsStreamConf struct {
+	// StreamEnable 是否开启流式读取
+	StreamEnable bool
+
+	// StreamChunkSize 每个分片的字节数(byte)，<=0时取默认值
+	StreamChunkSize int
+
+	// StreamMaxSize 流式消息最大字节数(byte)，<=0时不限制
+	StreamMaxSize int64
+}
+
+// NewWsStreamConf 创建流式消息配置
+// enable 是否开启流式读取
+// chunkSize 每个分片的字节数
+// maxSize 流式消息最大字节数
+func NewWsStreamConf(enable bool, chunkSize int, maxSize int64) *WsStreamConf {
+	return &WsStreamConf{
+		StreamEnable:    enable,
+		StreamChunkSize: chunkSize,
+		StreamMaxSize:   maxSize,
+	}
+}
+
+// IsStreamEnable 是否开启流式读取
+func (conf *WsStreamConf) IsStreamEnable() bool {
+	return conf.StreamEnable
+}
+
+// GetStreamChunkSize 每个分片的字节数(byte)
+func (conf *WsStreamConf) GetStreamChunkSize() int {
+	ret := conf.StreamChunkSize
+	if ret <= 0 {
+		ret = WS_STREAM_CHUNKSIZE
+	}
+	return ret
+}
+
+// GetStreamMaxSize 流式消息最大字节数(byte)，<=0时不限制
+func (conf *WsStreamConf) GetStreamMaxSize() int64 {
+	return conf.StreamMaxSize
+}
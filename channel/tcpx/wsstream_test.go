@@ -0,0 +1,99 @@
+/*
+ * Author:slive
+ * DATE:2020/9/26
+ */
+package tcpx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	gws "github.com/gorilla/websocket"
+	gch "github.com/slive/gsfly/channel"
+	"testing"
+)
+
+func TestWsStream(t *testing.T) {
+	rev := make(chan *WsPacket, 20)
+	serverWs, client, _ := wsPair(t, newWsTestConf(false, true), revPackets(rev), revPackets(make(chan *WsPacket, 20)))
+
+	// 超过分片大小的二进制消息按分片投递
+	data := bytes.Repeat([]byte("0123456789"), 250)
+	written, err := client.WriteStream(gws.BinaryMessage, bytes.NewReader(data))
+	if err != nil || written != int64(len(data)) {
+		t.Fatalf("write stream error:%v, written:%v", err, written)
+	}
+	var got []byte
+	for seq := 0; seq < 3; seq++ {
+		packet := waitPacket(t, rev)
+		if packet.StreamSeq != seq || packet.StreamEnd != (seq == 2) || !packet.IsStream() {
+			t.Fatalf("unexpected chunk, seq:%v, end:%v", packet.StreamSeq, packet.StreamEnd)
+		}
+		got = append(got, packet.GetData()...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("unexpected stream data.")
+	}
+
+	// 刚好为分片大小整数倍时，预读判断结束
+	writer, err := client.NextWriter(gws.BinaryMessage)
+	if err != nil {
+		t.Fatalf("next writer error:%v", err)
+	}
+	writer.Write(make([]byte, 1024))
+	writer.Write(make([]byte, 1024))
+	writer.Close()
+	if _, err := writer.Write([]byte("x")); err == nil {
+		t.Fatal("write after close should fail.")
+	}
+	first, second := waitPacket(t, rev), waitPacket(t, rev)
+	if first.StreamSeq != 0 || first.StreamEnd || second.StreamSeq != 1 || !second.StreamEnd || len(second.GetData()) != 1024 {
+		t.Fatalf("unexpected chunks, first:%+v, second:%+v", first, second)
+	}
+
+	// 不超过分片大小的作为普通消息
+	packet := client.NewPacket().(*WsPacket)
+	packet.MsgType = gws.BinaryMessage
+	packet.SetData([]byte("small"))
+	client.Write(packet)
+	if got := waitPacket(t, rev); got.IsStream() || !got.StreamEnd || string(got.GetData()) != "small" {
+		t.Fatalf("unexpected small packet:%+v", got)
+	}
+	if serverWs.IsClosed() {
+		t.Fatal("server should be active.")
+	}
+}
+
+// errAfterReader 读完数据后返回异常
+type errAfterReader struct {
+	data []byte
+	err  error
+}
+
+func (r *errAfterReader) Read(p []byte) (int, error) {
+	if len(r.data) <= 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestWsStreamPeekError(t *testing.T) {
+	conf := newWsTestConf(false, true)
+	wsCh := newWsChannel(nil, nil, conf, gch.NewDefChHandle(func(ctx gch.IChHandleContext) {}), nil, false)
+	broken := errors.New("broken")
+	wsCh.streamReader = bufio.NewReader(&errAfterReader{data: make([]byte, 1024), err: broken})
+	wsCh.streamType = gws.BinaryMessage
+	wsCh.streamSeq = -1
+
+	// 预读异常时先返回已读取的分片
+	_, p, seq, end, err := wsCh.readMessage()
+	if err != nil || len(p) != 1024 || seq != 0 || end {
+		t.Fatalf("chunk should be returned first, err:%v, len:%v, seq:%v, end:%v", err, len(p), seq, end)
+	}
+	_, p, _, _, err = wsCh.readMessage()
+	if err != broken || len(p) != 0 || wsCh.streamReader != nil {
+		t.Fatalf("unexpected second read, err:%v", err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	logx "github.com/slive/gsfly/logger"
 	"github.com/xtaci/kcp-go"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,7 +20,8 @@ type KcpChannel struct {
 	Conn             *kcp.UDPSession
 	protocol         gch.Network
 	onKcpReadHandler gch.ChHandleFunc
-	connected        bool
+	// 是否已连接，1为已连接，读协程池和Release并发访问
+	connected int32
 }
 
 // NewKcpChannel 创建KcpChannel
@@ -51,17 +53,19 @@ func (b *KcpChannel) onKcpRead(ctx gch.IChHandleContext) {
 	handleFunc := b.onKcpReadHandler
 	if handleFunc != nil {
 		var continued = true
-		if !b.connected {
+		if atomic.LoadInt32(&b.connected) == 0 {
 			// 第一次使用时为链接
 			gch.HandleOnConnnect(ctx)
 			continued = (ctx.GetError() == nil)
-			b.connected = continued
+			if continued {
+				atomic.StoreInt32(&b.connected, 1)
+			}
 		}
 
 		if continued {
 			handleFunc(ctx)
 		} else {
-			logx.Error("onKcpRead error:%v.", ctx.GetError())
+			logx.Errorf("onKcpRead error:%v.", ctx.GetError())
 		}
 	}
 }
@@ -72,7 +76,7 @@ func Read(ch *KcpChannel) (gch.IPacket, error) {
 	conf := ch.GetConf()
 	now := time.Now()
 	conn.SetReadDeadline(now.Add(conf.GetReadTimeout() * time.Second))
-	readbf := ch.NewReadBuf()
+	readbf := ch.GetReadBuf()
 	readNum, err := conn.Read(readbf)
 	if err != nil {
 		// TODO 超时后抛出异常？
@@ -85,9 +89,9 @@ func Read(ch *KcpChannel) (gch.IPacket, error) {
 	// 	return nil, nil
 	// }
 
+	// 读缓冲复用，按实际大小从缓冲池复制
 	datapack := ch.NewPacket()
-	bytes := readbf[0:readNum]
-	datapack.SetData(bytes)
+	datapack.SetBuf(gch.CopyBuf(readbf[0:readNum]))
 	gch.RevStatis(datapack, true)
 	return datapack, err
 }
@@ -109,10 +113,23 @@ func (b *KcpChannel) WriteByConn(datapacket gch.IPacket) error {
 	conn.SetWriteDeadline(writeDeadLine)
 	_, err := conn.Write(bytes)
 	if err != nil {
-		logx.Error("write kcp error:", err)
-		gch.SendStatis(datapacket, false)
-		panic(err)
-		return err
+		return gch.NewWriteError(b, err)
+	}
+	return nil
+}
+
+// WriteBatchByConn 多个包一次写入kcp，保留消息边界，只flush一次
+func (b *KcpChannel) WriteBatchByConn(datapackets []gch.IPacket) error {
+	buffers := make([][]byte, 0, len(datapackets))
+	for _, datapacket := range datapackets {
+		buffers = append(buffers, datapacket.GetData())
+	}
+	conn := b.Conn
+	conf := b.GetConf()
+	conn.SetWriteDeadline(time.Now().Add(conf.GetWriteTimeout() * time.Second))
+	_, err := conn.WriteBuffers(buffers)
+	if err != nil {
+		return gch.NewWriteError(b, err)
 	}
 	return nil
 }
@@ -132,7 +149,7 @@ func (b *KcpChannel) Open() error {
 }
 
 func (b *KcpChannel) Release() {
-	b.connected = false
+	atomic.StoreInt32(&b.connected, 0)
 	b.StopChannel(b)
 }
 
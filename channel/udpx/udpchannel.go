@@ -6,10 +6,15 @@
 package udpx
 
 import (
+	"fmt"
 	gch "github.com/slive/gsfly/channel"
 	logx "github.com/slive/gsfly/logger"
 	"github.com/pkg/errors"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"io"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -17,10 +22,32 @@ type UdpChannel struct {
 	gch.Channel
 	Conn     *net.UDPConn
 	rAddr    *net.UDPAddr
-	readchan chan []byte
+	readchan chan *gch.ByteBuf
+	// 服务端channel释放的通知，readchan不关闭，避免与CacheServerRead并发时出现向已关闭chan发送
+	done     chan struct{}
+	doneOnce sync.Once
+	// 批量发送，linux下为sendmmsg
+	batchConn batchWriter
+}
+
+// batchWriter ipv4.PacketConn和ipv6.PacketConn的批量发送
+type batchWriter interface {
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// udp 包最大不大于65507，即65535减去ip头(20)和udp头(8)，超过时内核返回EMSGSIZE
+const Max_UDP_Buf = 65507
+
+// ErrNoRemoteAddr 未连接的conn发送时包未设置目标地址
+var ErrNoRemoteAddr = errors.New("udp packet remote addr is nil")
+
+// checkUdpSize 检查包大小，超过udp允许的大小时返回ErrMsgTooLarge
+func checkUdpSize(udpCh *UdpChannel, data []byte) error {
+	if len(data) > Max_UDP_Buf {
+		return gch.NewWriteErrorByKind(udpCh, gch.ErrMsgTooLarge, fmt.Errorf("udp packet size:%v", len(data)))
+	}
+	return nil
 }
-// udp 包最大不大于65535
-const Max_UDP_Buf = 65535
 
 func newUdpChannel(parent interface{}, conn *net.UDPConn, conf gch.IChannelConf, chHandle *gch.ChHandle, rAddr *net.UDPAddr, server bool) *UdpChannel {
 	ch := &UdpChannel{Conn: conn}
@@ -37,8 +64,14 @@ func newUdpChannel(parent interface{}, conn *net.UDPConn, conf gch.IChannelConf,
 	}
 	conn.SetWriteBuffer(writeBufSize)
 	ch.rAddr = rAddr
+	if udpAddr, ok := conn.LocalAddr().(*net.UDPAddr); ok && udpAddr.IP.To4() == nil {
+		ch.batchConn = ipv6.NewPacketConn(conn)
+	} else {
+		ch.batchConn = ipv4.NewPacketConn(conn)
+	}
 	if server {
-		ch.readchan = make(chan []byte, 100)
+		ch.readchan = make(chan *gch.ByteBuf, 100)
+		ch.done = make(chan struct{})
 	}
 	return ch
 }
@@ -71,16 +104,18 @@ func (udpCh *UdpChannel) Open() error {
 func (udpCh *UdpChannel) Release() {
 	udpCh.StopChannel(udpCh)
 	if udpCh.IsServer() {
-		close(udpCh.readchan)
+		udpCh.doneOnce.Do(func() {
+			close(udpCh.done)
+		})
 	}
 }
 
 func (udpCh *UdpChannel) Read() (gch.IPacket, error) {
 	// TODO 超时配置
 	raddr := udpCh.rAddr
-	var bytes []byte
+	var buf *gch.ByteBuf
 	if !udpCh.IsServer() {
-		readbf := udpCh.NewReadBuf()
+		readbf := udpCh.GetReadBuf()
 		conf := udpCh.GetConf()
 		now := time.Now()
 		udpCh.Conn.SetReadDeadline(now.Add(conf.GetReadTimeout() * time.Second))
@@ -92,25 +127,39 @@ func (udpCh *UdpChannel) Read() (gch.IPacket, error) {
 			return nil, err
 		}
 		raddr = addr
-		bytes = readbf[0:readNum]
+		// 读缓冲复用，按实际大小从缓冲池复制
+		buf = gch.CopyBuf(readbf[0:readNum])
 	} else {
 		// 服务端直接获取
-		bytes = <-udpCh.readchan
+		select {
+		case buf = <-udpCh.readchan:
+		case <-udpCh.done:
+			return nil, io.EOF
+		}
 	}
 
-	if len(bytes) > 0 {
+	if buf != nil && buf.Len() > 0 {
 		nPacket := udpCh.NewPacket()
 		datapack := nPacket.(*UdpPacket)
-		datapack.SetData(bytes)
+		datapack.SetBuf(buf)
 		datapack.RAddr = raddr
 		gch.RevStatis(datapack, true)
 		return datapack, nil
 	}
+	if buf != nil {
+		buf.Release()
+	}
 	return nil, errors.New("udp data is null.")
 }
 
-func (udpCh *UdpChannel) CacheServerRead(bytes []byte) {
-	udpCh.readchan <- bytes
+// CacheServerRead 服务端缓存接收到的数据，由channel负责释放，channel已释放时直接丢弃
+func (udpCh *UdpChannel) CacheServerRead(buf *gch.ByteBuf) {
+	select {
+	case udpCh.readchan <- buf:
+	case <-udpCh.done:
+		logx.WarnTracef(udpCh, "channel had released, drop server read.")
+		buf.Release()
+	}
 }
 
 // Write datapack需要设置目标addr
@@ -122,12 +171,18 @@ func (udpCh *UdpChannel) Write(datapack gch.IPacket) error {
 func (udpCh *UdpChannel) WriteByConn(datapacket gch.IPacket) error {
 	writePacket := datapacket.(*UdpPacket)
 	bytes := writePacket.GetData()
+	err := checkUdpSize(udpCh, bytes)
+	if err != nil {
+		return err
+	}
 	conf := udpCh.GetConf()
 	// 设置超时时间
 	udpCh.Conn.SetWriteDeadline(time.Now().Add(conf.GetWriteTimeout() * time.Second))
 	addr := writePacket.RAddr
-	var err error
-	if addr != nil {
+	if udpCh.Conn.RemoteAddr() == nil {
+		if addr == nil {
+			return gch.NewWriteError(udpCh, ErrNoRemoteAddr)
+		}
 		// 发送到某个目标地址
 		_, err = udpCh.Conn.WriteTo(bytes, addr)
 	} else {
@@ -135,10 +190,40 @@ func (udpCh *UdpChannel) WriteByConn(datapacket gch.IPacket) error {
 		_, err = udpCh.Conn.Write(bytes)
 	}
 	if err != nil {
-		logx.Error("write udp error:", err)
-		gch.SendStatis(datapacket, false)
-		panic(err)
-		return err
+		return gch.NewWriteError(udpCh, err)
+	}
+	return nil
+}
+
+// WriteBatchByConn 通过sendmmsg一次发送多个包，不支持的平台内部逐个发送
+func (udpCh *UdpChannel) WriteBatchByConn(datapackets []gch.IPacket) error {
+	// 已连接的conn不能指定目标地址
+	connected := udpCh.Conn.RemoteAddr() != nil
+	msgs := make([]ipv4.Message, len(datapackets))
+	for index, datapacket := range datapackets {
+		writePacket := datapacket.(*UdpPacket)
+		err := checkUdpSize(udpCh, writePacket.GetData())
+		if err != nil {
+			return err
+		}
+		msgs[index].Buffers = [][]byte{writePacket.GetData()}
+		if !connected {
+			// nil的*net.UDPAddr赋值给net.Addr后不为nil，发送时会panic
+			if writePacket.RAddr == nil {
+				return gch.NewWriteError(udpCh, ErrNoRemoteAddr)
+			}
+			msgs[index].Addr = writePacket.RAddr
+		}
+	}
+	conf := udpCh.GetConf()
+	udpCh.Conn.SetWriteDeadline(time.Now().Add(conf.GetWriteTimeout() * time.Second))
+	sent := 0
+	for sent < len(msgs) {
+		num, err := udpCh.batchConn.WriteBatch(msgs[sent:], 0)
+		if err != nil {
+			return gch.NewWriteError(udpCh, err)
+		}
+		sent += num
 	}
 	return nil
 }
@@ -0,0 +1,122 @@
+/*
+ * Author:slive
+ * DATE:2020/9/26
+ */
+package udpx
+
+import (
+	"errors"
+	gch "github.com/slive/gsfly/channel"
+	"net"
+	"testing"
+	"time"
+)
+
+func listenUdp(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen error:%v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+// readUdp 按顺序读取指定的消息，udp每个包对应一条消息
+func readUdp(t *testing.T, conn *net.UDPConn, expects ...string) {
+	buf := make([]byte, Max_UDP_Buf)
+	for _, expect := range expects {
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		num, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("read error:%v", err)
+		}
+		if string(buf[:num]) != expect {
+			t.Fatalf("expect:%v, actual:%v", expect, string(buf[:num]))
+		}
+	}
+}
+
+func newUdpPackets(ch *UdpChannel, rAddr *net.UDPAddr, msgs ...string) []gch.IPacket {
+	packets := make([]gch.IPacket, 0, len(msgs))
+	for _, msg := range msgs {
+		packet := ch.NewPacket().(*UdpPacket)
+		packet.SetData([]byte(msg))
+		packet.RAddr = rAddr
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+func TestUdpWriteBatch(t *testing.T) {
+	server := listenUdp(t)
+	serverAddr := server.LocalAddr().(*net.UDPAddr)
+	handle := gch.NewDefChHandle(func(ctx gch.IChHandleContext) {})
+	conf := gch.NewDefChannelConf(gch.NETWORK_UDP)
+
+	// 已连接的conn
+	conn, err := net.DialUDP("udp", nil, serverAddr)
+	if err != nil {
+		t.Fatalf("dial error:%v", err)
+	}
+	connected := NewUdpChannel(nil, conn, conf, handle, serverAddr, false)
+	// 未连接的conn，按包的目标地址发送
+	unconnected := NewUdpChannel(nil, listenUdp(t), conf, handle, serverAddr, false)
+	for _, ch := range []*UdpChannel{connected, unconnected} {
+		if err := ch.Open(); err != nil {
+			t.Fatalf("open error:%v", err)
+		}
+		if err := ch.WriteBatch(newUdpPackets(ch, serverAddr, "a", "bb", "ccc")...); err != nil {
+			t.Fatalf("write batch error:%v", err)
+		}
+		if err := ch.Flush(); err != nil {
+			t.Fatalf("flush error:%v", err)
+		}
+		readUdp(t, server, "a", "bb", "ccc")
+
+		// 关闭时先发送已缓存的包
+		ch.WriteBatch(newUdpPackets(ch, serverAddr, "d")...)
+		ch.Release()
+		readUdp(t, server, "d")
+		if statis := ch.GetChStatis().SendStatics; statis.GetTotalPacketNum() != 4 || statis.GetTotalFailPacketNum() != 0 {
+			t.Fatalf("unexpected send statis:%v", statis)
+		}
+	}
+
+	// 超过udp大小的包发送失败，缓存的包被释放
+	ch := NewUdpChannel(nil, listenUdp(t), conf, handle, serverAddr, false)
+	if err := ch.Open(); err != nil {
+		t.Fatalf("open error:%v", err)
+	}
+	defer ch.Release()
+	packets := newUdpPackets(ch, serverAddr, "e", string(make([]byte, Max_UDP_Buf+1)))
+	ch.WriteBatch(packets...)
+	if err := ch.Flush(); err == nil {
+		t.Fatal("flush too large packet should fail.")
+	}
+	for _, packet := range packets {
+		if !packet.IsRelease() {
+			t.Fatal("failed batch packet should be released.")
+		}
+	}
+
+	// 未连接的conn发送没有目标地址的包时返回错误
+	ch = NewUdpChannel(nil, listenUdp(t), conf, handle, serverAddr, false)
+	if err := ch.Open(); err != nil {
+		t.Fatalf("open error:%v", err)
+	}
+	defer ch.Release()
+	packets = newUdpPackets(ch, nil, "f")
+	ch.WriteBatch(packets...)
+	if err := ch.Flush(); !errors.Is(err, ErrNoRemoteAddr) {
+		t.Fatalf("flush without addr should fail, err:%v", err)
+	}
+	if !packets[0].IsRelease() {
+		t.Fatal("failed batch packet should be released.")
+	}
+	packets = newUdpPackets(ch, nil, "g")
+	if err := ch.WriteByConn(packets[0]); !errors.Is(err, ErrNoRemoteAddr) {
+		t.Fatalf("write without addr should fail, err:%v", err)
+	}
+}
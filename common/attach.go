@@ -0,0 +1,166 @@
+/*
+ * 类型化的附件key，以key对象作为标识，避免不同库使用相同的字符串key时冲突
+ * Author:slive
+ * DATE:2020/9/24
+ */
+package common
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+)
+
+// AttachReleaseFunc 附件的释放回调，在Clear(如channel释放)时执行
+type AttachReleaseFunc func(val interface{})
+
+// AttachKey 类型化的附件key，不同的key对象即使名称相同也不冲突，一般定义为包级变量
+type AttachKey struct {
+	name      string
+	typ       reflect.Type
+	onRelease AttachReleaseFunc
+}
+
+// NewAttachKey 创建类型化的附件key
+// name 名称，用于展示
+// sample 值的类型样例，如(*Session)(nil)，附件需可赋值给该类型，为nil时不校验类型
+func NewAttachKey(name string, sample interface{}) *AttachKey {
+	return &AttachKey{name: name, typ: reflect.TypeOf(sample)}
+}
+
+// NewAttachKeyWithRelease 创建带释放回调的类型化附件key，见NewAttachKey
+// onRelease 释放回调，Clear时对该key的附件执行
+func NewAttachKeyWithRelease(name string, sample interface{}, onRelease AttachReleaseFunc) *AttachKey {
+	key := NewAttachKey(name, sample)
+	key.onRelease = onRelease
+	return key
+}
+
+func (k *AttachKey) GetName() string {
+	return k.name
+}
+
+// GetType 获取值的类型，为nil时不校验
+func (k *AttachKey) GetType() reflect.Type {
+	return k.typ
+}
+
+func (k *AttachKey) String() string {
+	return k.name
+}
+
+// check 校验附件是否符合该key的类型
+func (k *AttachKey) check(val interface{}) error {
+	if val == nil {
+		return fmt.Errorf("attach is nil, key:%v", k.name)
+	}
+	if k.typ != nil && !reflect.TypeOf(val).AssignableTo(k.typ) {
+		return fmt.Errorf("attach type mismatch, key:%v, expect:%v, actual:%T", k.name, k.typ, val)
+	}
+	return nil
+}
+
+// release 执行释放回调，回调的异常不影响其他附件的释放
+func (k *AttachKey) release(val interface{}) {
+	if k.onRelease == nil {
+		return
+	}
+	defer func() {
+		ret := recover()
+		if ret != nil {
+			log.Printf("release attach error, key:%v, err:%v\n", k.name, ret)
+		}
+	}()
+	k.onRelease(val)
+}
+
+func (b *Attact) AddTypedAttach(key *AttachKey, val interface{}) error {
+	if key == nil {
+		return fmt.Errorf("attach key is nil")
+	}
+	err := key.check(val)
+	if err != nil {
+		return err
+	}
+	b.amut.Lock()
+	defer b.amut.Unlock()
+	b.attach[key] = val
+	return nil
+}
+
+func (b *Attact) GetTypedAttach(key *AttachKey) interface{} {
+	if key == nil {
+		return nil
+	}
+	b.amut.RLock()
+	defer b.amut.RUnlock()
+	return b.attach[key]
+}
+
+func (b *Attact) RemoveTypedAttach(key *AttachKey) {
+	if key == nil {
+		return
+	}
+	b.amut.Lock()
+	defer b.amut.Unlock()
+	delete(b.attach, key)
+}
+
+// ComputeAttachIfAbsent 不存在时通过compute生成并添加，compute在锁内执行，
+// 同一时间只会执行一次，不可在compute中再操作该附件
+func (b *Attact) ComputeAttachIfAbsent(key *AttachKey, compute func() interface{}) (interface{}, error) {
+	if key == nil {
+		return nil, fmt.Errorf("attach key is nil")
+	}
+	b.amut.Lock()
+	defer b.amut.Unlock()
+	val, ok := b.attach[key]
+	if ok {
+		return val, nil
+	}
+	val = compute()
+	err := key.check(val)
+	if err != nil {
+		return nil, err
+	}
+	b.attach[key] = val
+	return val, nil
+}
+
+// SwapAttach 替换附件，返回原有的附件，不存在时为nil
+func (b *Attact) SwapAttach(key *AttachKey, val interface{}) (interface{}, error) {
+	if key == nil {
+		return nil, fmt.Errorf("attach key is nil")
+	}
+	err := key.check(val)
+	if err != nil {
+		return nil, err
+	}
+	b.amut.Lock()
+	defer b.amut.Unlock()
+	old := b.attach[key]
+	b.attach[key] = val
+	return old, nil
+}
+
+// CompareAndSwapAttach 当前附件为old时替换为val，old为nil表示不存在，
+// 附件需为可比较的类型，否则返回false
+func (b *Attact) CompareAndSwapAttach(key *AttachKey, old interface{}, val interface{}) bool {
+	if key == nil || key.check(val) != nil {
+		return false
+	}
+	if old != nil && !reflect.TypeOf(old).Comparable() {
+		return false
+	}
+	b.amut.Lock()
+	defer b.amut.Unlock()
+	cur := b.attach[key]
+	if cur != nil && !reflect.TypeOf(cur).Comparable() {
+		return false
+	}
+	if cur != old {
+		return false
+	}
+	b.attach[key] = val
+	return true
+}
@@ -0,0 +1,112 @@
+/*
+ * Author:slive
+ * DATE:2020/9/24
+ */
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type testSession struct {
+	id string
+}
+
+func TestTypedAttach(t *testing.T) {
+	attact := NewAttact()
+	key1 := NewAttachKey("session", (*testSession)(nil))
+	key2 := NewAttachKey("session", (*testSession)(nil))
+	attact.AddAttach("session", "str")
+
+	if attact.AddTypedAttach(key1, "str") == nil || attact.AddTypedAttach(key1, nil) == nil {
+		t.Fatal("mismatch type or nil should be rejected.")
+	}
+	if err := attact.AddTypedAttach(key1, &testSession{id: "1"}); err != nil {
+		t.Fatalf("add typed attach error:%v", err)
+	}
+	if attact.GetTypedAttach(key1).(*testSession).id != "1" || attact.GetTypedAttach(key2) != nil {
+		t.Fatal("keys with the same name should not conflict.")
+	}
+	if attact.GetAttach("session") != "str" || len(attact.GetAttachKeys()) != 2 {
+		t.Fatalf("unexpected attach keys:%v", attact.GetAttachKeys())
+	}
+
+	attact.RemoveTypedAttach(key1)
+	attact.RemoveAttach("session")
+	if attact.GetTypedAttach(key1) != nil || len(attact.GetAttachKeys()) != 0 {
+		t.Fatal("attach should be removed.")
+	}
+}
+
+func TestAttachAtomicOps(t *testing.T) {
+	attact := NewAttact()
+	key := NewAttachKey("counter", (*int32)(nil))
+
+	var computeNum int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := attact.ComputeAttachIfAbsent(key, func() interface{} {
+				atomic.AddInt32(&computeNum, 1)
+				return new(int32)
+			})
+			if err != nil {
+				t.Errorf("compute error:%v", err)
+				return
+			}
+			atomic.AddInt32(val.(*int32), 1)
+		}()
+	}
+	wg.Wait()
+	counter := attact.GetTypedAttach(key).(*int32)
+	if computeNum != 1 || *counter != 10 {
+		t.Fatalf("unexpected compute, num:%v, counter:%v", computeNum, *counter)
+	}
+
+	newCounter := new(int32)
+	old, err := attact.SwapAttach(key, newCounter)
+	if err != nil || old != counter {
+		t.Fatalf("unexpected swap, old:%v, err:%v", old, err)
+	}
+	if attact.CompareAndSwapAttach(key, counter, new(int32)) {
+		t.Fatal("cas should fail when old not match.")
+	}
+	if !attact.CompareAndSwapAttach(key, newCounter, counter) || attact.GetTypedAttach(key) != counter {
+		t.Fatal("cas should succeed when old match.")
+	}
+
+	absent := NewAttachKey("absent", nil)
+	if !attact.CompareAndSwapAttach(absent, nil, []int{1}) || attact.CompareAndSwapAttach(absent, []int{1}, 2) {
+		t.Fatal("cas with nil old should add, uncomparable old should fail.")
+	}
+}
+
+func TestAttachClear(t *testing.T) {
+	attact := NewAttact()
+	var released []interface{}
+	key := NewAttachKeyWithRelease("session", nil, func(val interface{}) {
+		released = append(released, val)
+	})
+	panicKey := NewAttachKeyWithRelease("panic", nil, func(val interface{}) {
+		panic("release panic")
+	})
+	attact.AddTypedAttach(key, "s1")
+	attact.AddTypedAttach(panicKey, "p1")
+	attact.AddAttach("plain", "v")
+
+	attact.Clear()
+	if len(attact.GetAttachKeys()) != 0 {
+		t.Fatal("attach should be cleared.")
+	}
+	if len(released) != 1 || released[0] != "s1" {
+		t.Fatalf("unexpected released:%v", released)
+	}
+	attact.Clear()
+	if len(released) != 1 {
+		t.Fatal("release should not be called twice.")
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
 type IParent interface {
@@ -60,18 +63,40 @@ type IAttact interface {
 
 	RemoveAttach(key string)
 
+	// GetAttachKeys 获取所有附件的key，类型化的key为其名称
+	GetAttachKeys() []string
+
+	// AddTypedAttach 添加类型化key的附件，值的类型与key不符时返回错误
+	AddTypedAttach(key *AttachKey, val interface{}) error
+
+	// GetTypedAttach 获取类型化key的附件
+	GetTypedAttach(key *AttachKey) interface{}
+
+	// RemoveTypedAttach 移除类型化key的附件，不会执行key的释放回调
+	RemoveTypedAttach(key *AttachKey)
+
+	// ComputeAttachIfAbsent 不存在时通过compute生成并添加，返回当前的附件
+	ComputeAttachIfAbsent(key *AttachKey, compute func() interface{}) (interface{}, error)
+
+	// SwapAttach 替换附件，返回原有的附件
+	SwapAttach(key *AttachKey, val interface{}) (interface{}, error)
+
+	// CompareAndSwapAttach 当前附件为old时替换为val
+	CompareAndSwapAttach(key *AttachKey, old interface{}, val interface{}) bool
+
+	// Clear 清除所有附件，并执行类型化key的释放回调
 	Clear()
 }
 
 type Attact struct {
-	// channel存放附件，可以是任意key-value值
-	attach map[string]interface{}
+	// channel存放附件，可以是任意key-value值，key为string或者*AttachKey
+	attach map[interface{}]interface{}
 	amut   sync.RWMutex
 }
 
 func NewAttact() *Attact {
 	a := &Attact{}
-	a.attach = make(map[string]interface{})
+	a.attach = make(map[interface{}]interface{})
 	return a
 }
 
@@ -93,16 +118,50 @@ func (b *Attact) GetAttach(key string) interface{} {
 }
 
 func (b *Attact) RemoveAttach(key string) {
+	b.amut.Lock()
+	defer b.amut.Unlock()
+	delete(b.attach, key)
+}
+
+func (b *Attact) GetAttachKeys() []string {
 	b.amut.RLock()
 	defer b.amut.RUnlock()
-	delete(b.attach, key)
+	keys := make([]string, 0, len(b.attach))
+	for key := range b.attach {
+		keys = append(keys, fmt.Sprintf("%v", key))
+	}
+	return keys
 }
 
+// Clear 清除所有附件，清除后执行类型化key的释放回调
 func (b *Attact) Clear() {
-	// Todo
+	b.amut.Lock()
+	attach := b.attach
+	b.attach = make(map[interface{}]interface{})
+	b.amut.Unlock()
+	for key, val := range attach {
+		typedKey, ok := key.(*AttachKey)
+		if ok {
+			typedKey.release(val)
+		}
+	}
 }
 
-const Key_Trace = "trace"
+// ctxKey 上下文值的key类型，避免与其他包的字符串key冲突，名称见Key_Trace等
+type ctxKey string
+
+const (
+	// Key_Trace 追踪的标识
+	Key_Trace = "trace"
+	// Key_ChId channel的id
+	Key_ChId = "chId"
+	// Key_Network channel的网络类型
+	Key_Network = "network"
+	// Key_RemoteAddr channel的远端地址
+	Key_RemoteAddr = "remoteAddr"
+	// Key_RelativePath channel的相对路径
+	Key_RelativePath = "relativePath"
+)
 
 type IRunContext interface {
 
@@ -117,24 +176,88 @@ type IRunContext interface {
 	AppendTrace(append string)
 
 	AddTrace(append string)
+
+	// AddValue 添加上下文的值，会传递到由该上下文派生的上下文中，如日志的结构化字段
+	AddValue(key string, val interface{})
+
+	// GetValue 获取上下文的值
+	GetValue(key string) interface{}
+
+	// SetDeadline 设置截止时间，到期后上下文取消，不再使用时需调用Cancel释放资源
+	SetDeadline(deadline time.Time)
+
+	// Cancel 取消上下文，由该上下文派生的上下文也会取消，不可取消时不做处理
+	Cancel()
 }
 
-type RunContext struct {
+// ContextWithValue 以RunContext的key类型添加上下文的值，与AddValue一致，用于普通的context
+func ContextWithValue(c context.Context, key string, val interface{}) context.Context {
+	return context.WithValue(c, ctxKey(key), val)
+}
+
+// ContextValue 获取由AddValue或者ContextWithValue添加的值，用于普通的context
+func ContextValue(c context.Context, key string) interface{} {
+	return c.Value(ctxKey(key))
+}
+
+// runState 上下文及其取消方法，创建后不再修改，变更时整体替换
+type runState struct {
 	context context.Context
+	// cancel 取消当前上下文，包括SetDeadline产生的，为nil时不可取消
+	cancel context.CancelFunc
+}
+
+// RunContext 运行的上下文，channel发布后仍可能被多个协程读写，如读协程和关闭，通过原子替换保证并发安全
+type RunContext struct {
+	// state 当前的*runState
+	state unsafe.Pointer
+}
+
+// loadState 获取当前状态及其指针，未初始化时为空的上下文
+func (ctx *RunContext) loadState() (unsafe.Pointer, *runState) {
+	ptr := atomic.LoadPointer(&ctx.state)
+	if ptr == nil {
+		return nil, &runState{context: context.TODO()}
+	}
+	return ptr, (*runState)(ptr)
+}
+
+// updateState 基于当前状态生成新的状态并原子替换，并发修改时执行discard后重试
+func (ctx *RunContext) updateState(next func(old *runState) (state *runState, discard func())) {
+	for {
+		ptr, old := ctx.loadState()
+		state, discard := next(old)
+		if atomic.CompareAndSwapPointer(&ctx.state, ptr, unsafe.Pointer(state)) {
+			return
+		}
+		if discard != nil {
+			discard()
+		}
+	}
+}
+
+// updateContext 基于当前上下文派生新的上下文并替换，保留原有的取消方法
+func (ctx *RunContext) updateContext(derive func(c context.Context) context.Context) {
+	ctx.updateState(func(old *runState) (*runState, func()) {
+		return &runState{context: derive(old.context), cancel: old.cancel}, nil
+	})
 }
 
 // SetContext 设置上下文，以便线程优雅停止
 func (ctx *RunContext) SetContext(c context.Context) {
-	ctx.context = c
+	ctx.updateContext(func(context.Context) context.Context {
+		return c
+	})
 }
 
 // GetContext 设置上下文，以便线程优雅停止
 func (ctx *RunContext) GetContext() context.Context {
-	return ctx.context
+	_, state := ctx.loadState()
+	return state.context
 }
 
 func (ctx *RunContext) GetTrace() string {
-	value := ctx.context.Value(Key_Trace)
+	value := ctx.GetValue(Key_Trace)
 	if value != nil {
 		s, ok := value.(string)
 		if ok {
@@ -146,20 +269,85 @@ func (ctx *RunContext) GetTrace() string {
 
 // AppendTrace 新增到trace后面
 func (ctx *RunContext) AppendTrace(append string) {
-	c := ctx.context
-	value := c.Value(Key_Trace)
-	if value == nil {
-		value = append
-	} else {
-		value = fmt.Sprintf("%v#%v", value, append)
-	}
-	ctx.context = context.WithValue(c, Key_Trace, value)
+	ctx.updateContext(func(c context.Context) context.Context {
+		value := ContextValue(c, Key_Trace)
+		if value == nil {
+			value = append
+		} else {
+			value = fmt.Sprintf("%v#%v", value, append)
+		}
+		return ContextWithValue(c, Key_Trace, value)
+	})
 }
 
 // AppendTrace 添加trace，覆盖原有的
 func (ctx *RunContext) AddTrace(addStr string) {
-	c := ctx.context
-	ctx.context = context.WithValue(c, Key_Trace, addStr)
+	ctx.AddValue(Key_Trace, addStr)
+}
+
+// AddValue 添加上下文的值，会传递到由该上下文派生的上下文中，如日志的结构化字段
+func (ctx *RunContext) AddValue(key string, val interface{}) {
+	ctx.updateContext(func(c context.Context) context.Context {
+		return ContextWithValue(c, key, val)
+	})
+}
+
+// GetValue 获取上下文的值
+func (ctx *RunContext) GetValue(key string) interface{} {
+	return ContextValue(ctx.GetContext(), key)
+}
+
+// SetDeadline 设置截止时间，到期后上下文取消，不再使用时需调用Cancel释放资源
+func (ctx *RunContext) SetDeadline(deadline time.Time) {
+	ctx.updateState(func(old *runState) (*runState, func()) {
+		c, cancel := context.WithDeadline(old.context, deadline)
+		return &runState{context: c, cancel: joinCancel(cancel, old.cancel)}, cancel
+	})
+}
+
+// Cancel 取消上下文，由该上下文派生的上下文也会取消，不可取消时不做处理
+func (ctx *RunContext) Cancel() {
+	_, state := ctx.loadState()
+	cancel := state.cancel
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// Renew 已取消时重新创建由父节点上下文派生的可取消上下文，保留原有的值，如socket关闭后重新监听或者拨号
+func (ctx *RunContext) Renew(parent interface{}) {
+	ctx.updateState(func(old *runState) (*runState, func()) {
+		if old.context.Err() == nil {
+			return old, nil
+		}
+		c, cancel := context.WithCancel(getParentContext(parent))
+		return &runState{context: &renewContext{Context: c, values: old.context}, cancel: cancel}, cancel
+	})
+}
+
+// renewContext 取消和截止时间取自新的上下文，值优先取自原有的上下文
+type renewContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c *renewContext) Value(key interface{}) interface{} {
+	val := c.values.Value(key)
+	if val != nil {
+		return val
+	}
+	return c.Context.Value(key)
+}
+
+// joinCancel 合并取消方法，prev为nil时直接返回cancel
+func joinCancel(cancel context.CancelFunc, prev context.CancelFunc) context.CancelFunc {
+	if prev == nil {
+		return cancel
+	}
+	return func() {
+		cancel()
+		prev()
+	}
 }
 
 func NewDefRunContext() *RunContext {
@@ -168,16 +356,35 @@ func NewDefRunContext() *RunContext {
 
 func NewRunContext(parentCtx context.Context) *RunContext {
 	var ctx context.Context
-	value := parentCtx.Value(Key_Trace)
+	value := ContextValue(parentCtx, Key_Trace)
 	if value != nil {
-		ctx = context.WithValue(parentCtx, Key_Trace, value)
+		ctx = ContextWithValue(parentCtx, Key_Trace, value)
 	} else {
 		ctx = parentCtx
 	}
-	return &RunContext{context: ctx}
+	return newRunContext(&runState{context: ctx})
+}
+
+func newRunContext(state *runState) *RunContext {
+	return &RunContext{state: unsafe.Pointer(state)}
 }
 
 func NewRunContextByParent(parent interface{}) *RunContext {
+	return NewRunContext(getParentContext(parent))
+}
+
+// NewCancelRunContext 创建可取消的上下文，Cancel或者父上下文取消时取消
+func NewCancelRunContext(parentCtx context.Context) *RunContext {
+	c, cancel := context.WithCancel(NewRunContext(parentCtx).GetContext())
+	return newRunContext(&runState{context: c, cancel: cancel})
+}
+
+// NewCancelRunContextByParent 创建由父节点上下文派生的可取消上下文，如socket和channel的上下文
+func NewCancelRunContextByParent(parent interface{}) *RunContext {
+	return NewCancelRunContext(getParentContext(parent))
+}
+
+func getParentContext(parent interface{}) context.Context {
 	var ctx context.Context
 	if parent != nil {
 		runContext, ok := parent.(IRunContext)
@@ -188,5 +395,5 @@ func NewRunContextByParent(parent interface{}) *RunContext {
 	if ctx == nil {
 		ctx = context.TODO()
 	}
-	return NewRunContext(ctx)
+	return ctx
 }
@@ -0,0 +1,135 @@
+/*
+ * Author:slive
+ * DATE:2020/9/25
+ */
+package common
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCancelRunContext(t *testing.T) {
+	parent := NewCancelRunContext(context.TODO())
+	parent.AddTrace("parent")
+	child := NewCancelRunContextByParent(parent)
+	child.AddValue(Key_ChId, "ch1")
+	if child.GetTrace() != "parent" || child.GetValue(Key_ChId) != "ch1" {
+		t.Fatal("child should inherit trace and values.")
+	}
+
+	child.Cancel()
+	if child.GetContext().Err() != context.Canceled || parent.GetContext().Err() != nil {
+		t.Fatal("cancel child should not cancel parent.")
+	}
+
+	other := NewCancelRunContextByParent(parent)
+	parent.Cancel()
+	select {
+	case <-other.GetContext().Done():
+	case <-time.After(time.Second):
+		t.Fatal("cancel parent should cancel child.")
+	}
+
+	// 不可取消的上下文
+	NewDefRunContext().Cancel()
+}
+
+func TestRunContextRenew(t *testing.T) {
+	parent := NewCancelRunContext(context.TODO())
+	ctx := NewCancelRunContextByParent(parent)
+	ctx.AddTrace("socket")
+	// 未取消时不重新创建
+	c := ctx.GetContext()
+	ctx.Renew(parent)
+	if ctx.GetContext() != c {
+		t.Fatal("should not renew before canceled.")
+	}
+
+	ctx.Cancel()
+	ctx.Renew(parent)
+	if ctx.GetContext().Err() != nil || ctx.GetTrace() != "socket" {
+		t.Fatalf("should renew and keep values, err:%v, trace:%v", ctx.GetContext().Err(), ctx.GetTrace())
+	}
+	ctx.Cancel()
+	if ctx.GetContext().Err() != context.Canceled || parent.GetContext().Err() != nil {
+		t.Fatal("renewed context should be cancelable.")
+	}
+
+	// 重新创建后仍随父上下文取消
+	ctx.Renew(parent)
+	parent.Cancel()
+	select {
+	case <-ctx.GetContext().Done():
+	case <-time.After(time.Second):
+		t.Fatal("cancel parent should cancel renewed context.")
+	}
+}
+
+func TestRunContextDeadline(t *testing.T) {
+	ctx := NewRunContext(context.TODO())
+	ctx.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	deadline, ok := ctx.GetContext().Deadline()
+	if !ok || deadline.IsZero() {
+		t.Fatal("deadline should be set.")
+	}
+	select {
+	case <-ctx.GetContext().Done():
+	case <-time.After(time.Second):
+		t.Fatal("context should be done after deadline.")
+	}
+	if ctx.GetContext().Err() != context.DeadlineExceeded {
+		t.Fatalf("unexpected err:%v", ctx.GetContext().Err())
+	}
+
+	ctx = NewCancelRunContext(context.TODO())
+	ctx.SetDeadline(time.Now().Add(time.Hour))
+	ctx.AddValue(Key_ChId, "ch1")
+	ctx.Cancel()
+	if ctx.GetContext().Err() != context.Canceled {
+		t.Fatalf("unexpected err:%v", ctx.GetContext().Err())
+	}
+}
+
+func TestRunContextValue(t *testing.T) {
+	// 字符串key不会与上下文中的值冲突
+	ctx := NewRunContext(context.WithValue(context.TODO(), Key_ChId, "plain"))
+	if ctx.GetValue(Key_ChId) != nil {
+		t.Fatal("plain string key should not be visible.")
+	}
+	ctx.AddValue(Key_ChId, "ch1")
+	if ctx.GetContext().Value(Key_ChId) != "plain" || ContextValue(ctx.GetContext(), Key_ChId) != "ch1" {
+		t.Fatal("value should be added with private key type.")
+	}
+	if ContextValue(ContextWithValue(context.TODO(), Key_Network, "tcp"), Key_Network) != "tcp" {
+		t.Fatal("ContextWithValue should be readable by ContextValue.")
+	}
+
+	// 并发修改和读取，修改不丢失
+	ctx = NewCancelRunContext(context.TODO())
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "key" + strconv.Itoa(i)
+			ctx.AddValue(key, i)
+			ctx.SetDeadline(time.Now().Add(time.Minute))
+			ctx.AppendTrace(key)
+			ctx.GetContext().Value(key)
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 8; i++ {
+		if ctx.GetValue("key"+strconv.Itoa(i)) != i {
+			t.Fatalf("value of key%v is lost.", i)
+		}
+	}
+	ctx.Cancel()
+	if ctx.GetContext().Err() != context.Canceled {
+		t.Fatal("should cancel all deadlines.")
+	}
+}
@@ -7,17 +7,111 @@ package common
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"sync"
 )
 
+// 库内的错误码，业务可通过RegisterErrCode注册自定义的错误码
+const (
+	// ERR_READ 读取异常
+	ERR_READ = "ERR_READ"
+	// ERR_WRITE 写入异常
+	ERR_WRITE = "ERR_WRITE"
+	// ERR_MSG 消息处理异常
+	ERR_MSG = "ERR_MSG"
+	// ERR_ACTIVE 激活(连接)异常
+	ERR_ACTIVE = "ERR_ACTIVE"
+	// ERR_INACTIVE 关闭异常
+	ERR_INACTIVE = "ERR_INACTIVE"
+	// ERR_REG 注册异常
+	ERR_REG = "ERR_REG"
+	// ERR_TIMEOUT 超时
+	ERR_TIMEOUT = "ERR_TIMEOUT"
+	// ERR_CLOSED 连接已关闭或者被对端重置
+	ERR_CLOSED = "ERR_CLOSED"
+	// ERR_AUTH 鉴权失败
+	ERR_AUTH = "ERR_AUTH"
+	// ERR_DECODE 解码异常
+	ERR_DECODE = "ERR_DECODE"
+	// ERR_OVERLOAD 过载，如队列已满
+	ERR_OVERLOAD = "ERR_OVERLOAD"
+)
+
+var errCodes sync.Map
+
+func init() {
+	defCodes := map[string]string{
+		ERR_READ:     "read error",
+		ERR_WRITE:    "write error",
+		ERR_MSG:      "handle message error",
+		ERR_ACTIVE:   "active error",
+		ERR_INACTIVE: "inactive error",
+		ERR_REG:      "register error",
+		ERR_TIMEOUT:  "timeout",
+		ERR_CLOSED:   "closed",
+		ERR_AUTH:     "auth failed",
+		ERR_DECODE:   "decode error",
+		ERR_OVERLOAD: "overload",
+	}
+	for code, desc := range defCodes {
+		errCodes.Store(code, desc)
+	}
+}
+
+// RegisterErrCode 注册错误码，已存在时返回错误
+// code 错误码，如ERR_BIZ
+// desc 错误码的描述
+func RegisterErrCode(code string, desc string) error {
+	if len(code) <= 0 {
+		return errors.New("error code is empty")
+	}
+	_, loaded := errCodes.LoadOrStore(code, desc)
+	if loaded {
+		return fmt.Errorf("error code had registered, code:%v", code)
+	}
+	return nil
+}
+
+// GetErrCodeDesc 获取错误码的描述，未注册时返回false
+func GetErrCodeDesc(code string) (string, bool) {
+	desc, ok := errCodes.Load(code)
+	if !ok {
+		return "", false
+	}
+	return desc.(string), true
+}
+
+// GetErrCodes 获取所有已注册的错误码，按错误码排序
+func GetErrCodes() []string {
+	codes := make([]string, 0)
+	errCodes.Range(func(key, value interface{}) bool {
+		codes = append(codes, key.(string))
+		return true
+	})
+	sort.Strings(codes)
+	return codes
+}
+
+// GError 带错误码的错误，支持errors.Is/As/Unwrap
 type GError interface {
 	error
 	GetErrCode() string
 	GetErr() error
+
+	// Unwrap 获取原始错误
+	Unwrap() error
+
+	// GetFields 获取结构化字段，如chId，network
+	GetFields() map[string]interface{}
+
+	// WithField 添加结构化字段，返回自身
+	WithField(key string, val interface{}) GError
 }
 
 type innerErr struct {
 	error   error
 	errCode string
+	fields  map[string]interface{}
 }
 
 func NewError0(err error) GError {
@@ -48,9 +142,27 @@ func NewError3(errCode string, msg interface{}) GError {
 	}
 }
 
+// NewCodeError 创建只有错误码的错误，用于errors.Is按错误码判断，如errors.Is(err, NewCodeError(ERR_TIMEOUT))
+func NewCodeError(errCode string) GError {
+	return &innerErr{errCode: errCode}
+}
+
+// IsErrCode 判断错误链中是否有该错误码的GError
+func IsErrCode(err error, errCode string) bool {
+	return errors.Is(err, NewCodeError(errCode))
+}
+
 func (err *innerErr) Error() string {
+	if err.error == nil {
+		desc, ok := GetErrCodeDesc(err.errCode)
+		if ok {
+			return err.errCode + ":" + desc
+		}
+		return err.errCode
+	}
 	return err.errCode + ":" + err.error.Error()
 }
+
 func (err *innerErr) GetErrCode() string {
 	return err.errCode
 }
@@ -58,3 +170,25 @@ func (err *innerErr) GetErrCode() string {
 func (err *innerErr) GetErr() error {
 	return err.error
 }
+
+func (err *innerErr) Unwrap() error {
+	return err.error
+}
+
+// Is 错误码相同，且target为NewCodeError创建的错误时匹配
+func (err *innerErr) Is(target error) bool {
+	t, ok := target.(*innerErr)
+	return ok && t.error == nil && len(t.errCode) > 0 && t.errCode == err.errCode
+}
+
+func (err *innerErr) GetFields() map[string]interface{} {
+	return err.fields
+}
+
+func (err *innerErr) WithField(key string, val interface{}) GError {
+	if err.fields == nil {
+		err.fields = make(map[string]interface{})
+	}
+	err.fields[key] = val
+	return err
+}
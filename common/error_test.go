@@ -0,0 +1,58 @@
+/*
+ * Author:slive
+ * DATE:2020/8/7
+ */
+package common
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestErrorIsAs(t *testing.T) {
+	var err error = NewError1(ERR_READ, io.EOF)
+	if !errors.Is(err, io.EOF) {
+		t.Fatal("should unwrap to origin error.")
+	}
+	if !errors.Is(err, NewCodeError(ERR_READ)) || IsErrCode(err, ERR_WRITE) {
+		t.Fatal("should match by error code.")
+	}
+	if errors.Is(err, NewError1(ERR_READ, io.EOF)) {
+		t.Fatal("only code error should match by error code.")
+	}
+
+	wrapped := NewError1(ERR_MSG, err)
+	var gerr GError
+	if !errors.As(wrapped, &gerr) || gerr.GetErrCode() != ERR_MSG || !IsErrCode(wrapped, ERR_READ) {
+		t.Fatal("should find error code through wrapped error.")
+	}
+	if NewCodeError(ERR_TIMEOUT).Error() != "ERR_TIMEOUT:timeout" || err.Error() != "ERR_READ:EOF" {
+		t.Fatalf("unexpected error string:%v", err)
+	}
+
+	gerr = NewError2(ERR_AUTH, "token expired").WithField(Key_ChId, "client#tcp#a->b")
+	if gerr.GetFields()[Key_ChId] != "client#tcp#a->b" {
+		t.Fatalf("unexpected fields:%v", gerr.GetFields())
+	}
+}
+
+func TestRegisterErrCode(t *testing.T) {
+	if RegisterErrCode(ERR_READ, "dup") == nil || RegisterErrCode("", "empty") == nil {
+		t.Fatal("duplicate or empty code should be rejected.")
+	}
+	if RegisterErrCode("ERR_TEST_REG", "test") != nil {
+		t.Fatal("register error code error.")
+	}
+	desc, ok := GetErrCodeDesc("ERR_TEST_REG")
+	if !ok || desc != "test" {
+		t.Fatalf("unexpected desc:%v", desc)
+	}
+	found := false
+	for _, code := range GetErrCodes() {
+		found = found || code == "ERR_TEST_REG"
+	}
+	if !found {
+		t.Fatal("registered code should be listed.")
+	}
+}
@@ -0,0 +1,241 @@
+/*
+ * 运行引擎，统一管理读协程池，服务端和客户端的生命周期，
+ * 由使用方显式调用Start/Stop，库本身不再监听进程信号。
+ * Author:slive
+ * DATE:2020/7/17
+ */
+package engine
+
+import (
+	"context"
+	"errors"
+	gch "github.com/slive/gsfly/channel"
+	"github.com/slive/gsfly/common"
+	logx "github.com/slive/gsfly/logger"
+	"github.com/slive/gsfly/socket"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// IEngine 运行引擎接口
+type IEngine interface {
+	gch.IReadPoolHolder
+
+	// AddServer 添加服务端，Start时启动监听
+	AddServer(serverSocket socket.IServerSocket)
+
+	// AddClient 添加客户端，Start时进行拨号
+	AddClient(clientSocket socket.IClientSocket)
+
+	// Start 启动所有服务端和客户端
+	Start() error
+
+	// Stop 优雅关闭所有服务端和客户端，最后关闭读协程池
+	Stop(ctx context.Context) error
+
+	// IsStarted 是否已启动
+	IsStarted() bool
+
+	common.IId
+
+	common.IRunContext
+}
+
+// Engine 运行引擎实现
+type Engine struct {
+	readPool *gch.ReadPool
+	servers  []socket.IServerSocket
+	clients  []socket.IClientSocket
+	started  bool
+	mut      sync.Mutex
+	// 读协程池配置，停止后重新启动时按该配置重新创建
+	readPoolConf *gch.ReadPoolConf
+	// 读协程池已随Stop关闭
+	readPoolClosed bool
+	// 读协程池在Start时可能被替换，与mut分开，避免监听过程中获取读协程池时死锁
+	poolMut sync.RWMutex
+
+	common.Id
+	common.RunContext
+}
+
+// engineSeq 引擎序号，用于生成唯一的引擎id
+var engineSeq int64
+
+// NewEngine 创建运行引擎，id为engine#序号，同一进程内唯一
+// readPoolConf 读协程池配置，为nil时按cpu数创建默认配置，配置有误时panic
+func NewEngine(readPoolConf *gch.ReadPoolConf) *Engine {
+	if readPoolConf == nil {
+		readPoolConf = gch.NewReadPoolConf(runtime.NumCPU()*gch.MAX_READ_POOL_EVERY_CPU, gch.MAX_READ_QUEUE_SIZE)
+	}
+	readPool, err := gch.NewReadPoolByConf(readPoolConf)
+	if err != nil {
+		logx.Panic("new engine readPool error:", err)
+		panic(err)
+	}
+	e := &Engine{
+		readPool:     readPool,
+		readPoolConf: readPoolConf,
+	}
+	e.Id = *common.NewId()
+	e.RunContext = *common.NewDefRunContext()
+	e.SetId("engine#" + strconv.FormatInt(atomic.AddInt64(&engineSeq, 1), 10))
+	return e
+}
+
+func (e *Engine) SetId(id string) {
+	e.AddTrace(id)
+	e.Id.SetId(id)
+}
+
+// GetReadPool 获取读协程池，该引擎下的服务端和客户端默认都使用该协程池
+func (e *Engine) GetReadPool() *gch.ReadPool {
+	e.poolMut.RLock()
+	defer e.poolMut.RUnlock()
+	return e.readPool
+}
+
+// NewServerSocket 创建以引擎为父节点的服务端并添加到引擎中
+func (e *Engine) NewServerSocket(serverConf socket.IServerConf, chHandle gch.IChHandle) *socket.ServerSocket {
+	serverSocket := socket.NewServerSocket(e, serverConf, chHandle)
+	e.AddServer(serverSocket)
+	return serverSocket
+}
+
+// NewClientSocket 创建以引擎为父节点的客户端并添加到引擎中
+func (e *Engine) NewClientSocket(clientConf socket.IClientConf, chHandle gch.IChHandle, inputParams map[string]interface{}) *socket.ClientSocket {
+	clientSocket := socket.NewClientSocket(e, clientConf, chHandle, inputParams)
+	e.AddClient(clientSocket)
+	return clientSocket
+}
+
+// AddServer 添加服务端，若引擎已启动，则直接启动监听
+func (e *Engine) AddServer(serverSocket socket.IServerSocket) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.servers = append(e.servers, serverSocket)
+	if e.started {
+		err := serverSocket.Listen()
+		if err != nil {
+			logx.ErrorTracef(e, "listen error, id:%v, err:%v", serverSocket.GetId(), err)
+		}
+	}
+}
+
+// AddClient 添加客户端，若引擎已启动，则直接拨号
+func (e *Engine) AddClient(clientSocket socket.IClientSocket) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.clients = append(e.clients, clientSocket)
+	if e.started {
+		err := clientSocket.Dial()
+		if err != nil {
+			logx.ErrorTracef(e, "dial error, id:%v, err:%v", clientSocket.GetId(), err)
+		}
+	}
+}
+
+// GetServers 获取所有服务端
+func (e *Engine) GetServers() []socket.IServerSocket {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return append([]socket.IServerSocket{}, e.servers...)
+}
+
+// GetClients 获取所有客户端
+func (e *Engine) GetClients() []socket.IClientSocket {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return append([]socket.IClientSocket{}, e.clients...)
+}
+
+// Start 先启动所有服务端的监听，停止后可重新启动，读协程池已关闭时重新创建，再进行客户端的拨号，有失败时关闭已启动的并返回错误
+func (e *Engine) Start() error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if e.started {
+		return errors.New("engine had started, id:" + e.GetId())
+	}
+
+	logx.InfoTrace(e, "start engine.")
+	if e.readPoolClosed {
+		// 停止时已关闭，重新创建读协程池
+		readPool, err := gch.NewReadPoolByConf(e.readPoolConf)
+		if err != nil {
+			logx.ErrorTracef(e, "new readPool error:%v", err)
+			return err
+		}
+		e.poolMut.Lock()
+		e.readPool = readPool
+		e.poolMut.Unlock()
+		e.readPoolClosed = false
+	}
+	for index, serverSocket := range e.servers {
+		err := serverSocket.Listen()
+		if err != nil {
+			logx.ErrorTracef(e, "listen error, id:%v, err:%v", serverSocket.GetId(), err)
+			for _, ss := range e.servers[0:index] {
+				ss.Close()
+			}
+			return err
+		}
+	}
+
+	for index, clientSocket := range e.clients {
+		err := clientSocket.Dial()
+		if err != nil {
+			logx.ErrorTracef(e, "dial error, id:%v, err:%v", clientSocket.GetId(), err)
+			for _, cs := range e.clients[0:index] {
+				cs.Close()
+			}
+			for _, ss := range e.servers {
+				ss.Close()
+			}
+			return err
+		}
+	}
+	e.started = true
+	logx.InfoTrace(e, "finish to start engine.")
+	return nil
+}
+
+// Stop 关闭所有客户端，优雅关闭所有服务端直到ctx超时，最后关闭读协程池，返回第一个出现的错误
+func (e *Engine) Stop(ctx context.Context) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if !e.started {
+		return nil
+	}
+
+	logx.InfoTrace(e, "start to stop engine.")
+	e.started = false
+	for _, clientSocket := range e.clients {
+		clientSocket.Close()
+	}
+
+	var retErr error
+	for _, serverSocket := range e.servers {
+		err := serverSocket.Shutdown(ctx)
+		if err != nil {
+			logx.WarnTracef(e, "shutdown error, id:%v, err:%v", serverSocket.GetId(), err)
+			if retErr == nil {
+				retErr = err
+			}
+		}
+	}
+	e.GetReadPool().Close()
+	e.readPoolClosed = true
+	logx.InfoTracef(e, "finish to stop engine, err:%v", retErr)
+	// 停止时写入异步缓冲的日志
+	logx.Flush()
+	return retErr
+}
+
+// IsStarted 是否已启动
+func (e *Engine) IsStarted() bool {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.started
+}
@@ -0,0 +1,120 @@
+/*
+ * Author:slive
+ * DATE:2020/7/17
+ */
+package engine
+
+import (
+	"context"
+	gch "github.com/slive/gsfly/channel"
+	"github.com/slive/gsfly/socket"
+	"testing"
+	"time"
+)
+
+func TestEngineStartStop(t *testing.T) {
+	// 同一进程内多次创建和销毁，同一端口可以重复使用
+	for i := 0; i < 3; i++ {
+		e := NewEngine(gch.NewReadPoolConf(4, 16))
+		serverHandle := gch.NewDefChHandle(func(ctx gch.IChHandleContext) {
+			channel := ctx.GetChannel()
+			packet := channel.NewPacket()
+			packet.SetData(ctx.GetPacket().GetData())
+			channel.Write(packet)
+		})
+		serverSocket := e.NewServerSocket(socket.NewTcpServerConf("127.0.0.1", 19101), serverHandle)
+
+		revChan := make(chan string, 1)
+		clientHandle := gch.NewDefChHandle(func(ctx gch.IChHandleContext) {
+			revChan <- string(ctx.GetPacket().GetData())
+		})
+		clientSocket := e.NewClientSocket(socket.NewTcpClientConf("127.0.0.1", 19101), clientHandle, nil)
+
+		err := e.Start()
+		if err != nil {
+			t.Fatalf("start engine error:%v", err)
+		}
+		if serverSocket.GetReadPool() != e.GetReadPool() {
+			t.Fatal("server socket should use the engine read pool.")
+		}
+
+		packet := clientSocket.GetChannel().NewPacket()
+		packet.SetData([]byte("hello"))
+		clientSocket.GetChannel().Write(packet)
+		select {
+		case msg := <-revChan:
+			if msg != "hello" {
+				t.Fatalf("unexpected msg:%v", msg)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatal("wait echo timeout.")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		err = e.Stop(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("stop engine error:%v", err)
+		}
+		if e.IsStarted() {
+			t.Fatal("engine should be stopped.")
+		}
+		if serverSocket.GetChannelMap().Size() != 0 {
+			t.Fatalf("channels should be released, size:%v", serverSocket.GetChannelMap().Size())
+		}
+	}
+}
+
+func TestEngineRestart(t *testing.T) {
+	e := NewEngine(gch.NewReadPoolConf(4, 16))
+	serverHandle := gch.NewDefChHandle(func(ctx gch.IChHandleContext) {
+		channel := ctx.GetChannel()
+		packet := channel.NewPacket()
+		packet.SetData(ctx.GetPacket().GetData())
+		channel.Write(packet)
+	})
+	serverSocket := e.NewServerSocket(socket.NewTcpServerConf("127.0.0.1", 19102), serverHandle)
+	revChan := make(chan string, 1)
+	clientHandle := gch.NewDefChHandle(func(ctx gch.IChHandleContext) {
+		revChan <- string(ctx.GetPacket().GetData())
+	})
+	clientSocket := e.NewClientSocket(socket.NewTcpClientConf("127.0.0.1", 19102), clientHandle, nil)
+
+	// 同一个引擎停止后可以重新启动，每次都能正常回显
+	var lastPool *gch.ReadPool
+	for i := 0; i < 3; i++ {
+		if err := e.Start(); err != nil {
+			t.Fatalf("start engine error:%v", err)
+		}
+		readPool := e.GetReadPool()
+		if readPool == lastPool || serverSocket.GetReadPool() != readPool {
+			t.Fatal("read pool should be recreated after restart.")
+		}
+		lastPool = readPool
+		if serverSocket.GetContext().Err() != nil || clientSocket.GetContext().Err() != nil {
+			t.Fatal("socket context should be renewed after restart.")
+		}
+
+		packet := clientSocket.GetChannel().NewPacket()
+		packet.SetData([]byte("hello"))
+		clientSocket.GetChannel().Write(packet)
+		select {
+		case msg := <-revChan:
+			if msg != "hello" {
+				t.Fatalf("unexpected msg:%v", msg)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("wait echo timeout, round:%v", i)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		err := e.Stop(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("stop engine error:%v", err)
+		}
+		if serverSocket.GetContext().Err() == nil {
+			t.Fatal("socket context should be canceled after stop.")
+		}
+	}
+}
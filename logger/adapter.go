@@ -0,0 +1,212 @@
+/*
+ * 可插拔的日志实现，库内所有日志都经由ILogger输出，默认为logrus，
+ * 可通过SetLogger替换为标准库log，zap等结构化日志或者不输出，替换时不会创建日志文件
+ * Author:slive
+ * DATE:2020/9/22
+ */
+package logger
+
+import (
+	"fmt"
+	logx "github.com/sirupsen/logrus"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// ILogger 日志实现
+type ILogger interface {
+	// Log 输出日志，级别过滤已在外层处理
+	// level 日志级别，如Level_Info
+	// fields 结构化字段，如file，trace和chId等，字段名为配置后的名称
+	// msg 日志内容
+	Log(level string, fields map[string]interface{}, msg string)
+
+	// IsLevelEnabled 是否输出该级别的日志
+	IsLevelEnabled(level string) bool
+}
+
+// loggerHolder atomic.Value需要存储相同的类型
+type loggerHolder struct {
+	logger ILogger
+}
+
+var curLogger atomic.Value
+
+func init() {
+	// 默认输出到stderr，不修改logrus的默认logger，级别由外层控制
+	defLogger := logx.New()
+	defLogger.SetLevel(logx.DebugLevel)
+	curLogger.Store(&loggerHolder{logger: NewLogrusLogger(defLogger)})
+}
+
+// SetLogger 设置日志实现，为nil时不输出日志
+func SetLogger(logger ILogger) {
+	if logger == nil {
+		logger = NewNopLogger()
+	}
+	curLogger.Store(&loggerHolder{logger: logger})
+}
+
+// GetLogger 获取当前的日志实现
+func GetLogger() ILogger {
+	return curLogger.Load().(*loggerHolder).logger
+}
+
+// LogrusLogger 基于logrus的日志实现
+type LogrusLogger struct {
+	logger *logx.Logger
+}
+
+// NewLogrusLogger 创建基于logrus的日志实现
+// logger logrus的logger，为nil时选用logrus的默认logger(InitLogger使用独立的logger，不修改默认logger)
+func NewLogrusLogger(logger *logx.Logger) *LogrusLogger {
+	if logger == nil {
+		logger = logx.StandardLogger()
+	}
+	return &LogrusLogger{logger: logger}
+}
+
+func (l *LogrusLogger) Log(level string, fields map[string]interface{}, msg string) {
+	l.logger.WithFields(fields).Log(toLogrusLevel(level), msg)
+}
+
+func (l *LogrusLogger) IsLevelEnabled(level string) bool {
+	return l.logger.IsLevelEnabled(toLogrusLevel(level))
+}
+
+// StdLogger 基于标准库log的日志实现，结构化字段按key=value追加到内容后
+type StdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger 创建基于标准库log的日志实现
+// logger 标准库的logger，为nil时输出到stderr
+func NewStdLogger(logger *log.Logger) *StdLogger {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags|log.Lmicroseconds)
+	}
+	return &StdLogger{logger: logger}
+}
+
+func (l *StdLogger) Log(level string, fields map[string]interface{}, msg string) {
+	builder := &strings.Builder{}
+	builder.WriteString("[" + strings.ToUpper(level) + "] " + msg)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		builder.WriteString(fmt.Sprintf(" %v=%v", key, fields[key]))
+	}
+	l.logger.Println(builder.String())
+}
+
+func (l *StdLogger) IsLevelEnabled(level string) bool {
+	return true
+}
+
+// IStructuredLogger 调用方提供的结构化日志，方法与zap.SugaredLogger一致，可直接传入
+type IStructuredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// StructuredLogger 适配调用方提供的结构化日志，fatal和panic级别以error输出，退出和panic由外层处理
+type StructuredLogger struct {
+	logger IStructuredLogger
+}
+
+// NewStructuredLogger 创建适配结构化日志的日志实现
+// logger 调用方提供的结构化日志，不可为nil
+func NewStructuredLogger(logger IStructuredLogger) *StructuredLogger {
+	if logger == nil {
+		errMsg := "structured logger is nil"
+		log.Println(errMsg)
+		panic(errMsg)
+	}
+	return &StructuredLogger{logger: logger}
+}
+
+func (l *StructuredLogger) Log(level string, fields map[string]interface{}, msg string) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	keysAndValues := make([]interface{}, 0, len(fields)*2)
+	for _, key := range keys {
+		keysAndValues = append(keysAndValues, key, fields[key])
+	}
+	switch level {
+	case Level_Debug:
+		l.logger.Debugw(msg, keysAndValues...)
+	case Level_Info:
+		l.logger.Infow(msg, keysAndValues...)
+	case Level_Warn:
+		l.logger.Warnw(msg, keysAndValues...)
+	default:
+		l.logger.Errorw(msg, keysAndValues...)
+	}
+}
+
+func (l *StructuredLogger) IsLevelEnabled(level string) bool {
+	return true
+}
+
+// NopLogger 不输出任何日志
+type NopLogger struct {
+}
+
+// NewNopLogger 创建不输出任何日志的日志实现
+func NewNopLogger() *NopLogger {
+	return &NopLogger{}
+}
+
+func (l *NopLogger) Log(level string, fields map[string]interface{}, msg string) {
+}
+
+func (l *NopLogger) IsLevelEnabled(level string) bool {
+	return false
+}
+
+// toLogrusLevel 日志级别转为logrus的级别
+func toLogrusLevel(level string) logx.Level {
+	switch level {
+	case Level_Panic:
+		return logx.PanicLevel
+	case Level_Fatal:
+		return logx.FatalLevel
+	case Level_Error:
+		return logx.ErrorLevel
+	case Level_Warn:
+		return logx.WarnLevel
+	case Level_Info:
+		return logx.InfoLevel
+	default:
+		return logx.DebugLevel
+	}
+}
+
+// fromLogrusLevel logrus的级别转为日志级别
+func fromLogrusLevel(level logx.Level) string {
+	switch level {
+	case logx.PanicLevel:
+		return Level_Panic
+	case logx.FatalLevel:
+		return Level_Fatal
+	case logx.ErrorLevel:
+		return Level_Error
+	case logx.WarnLevel:
+		return Level_Warn
+	case logx.InfoLevel:
+		return Level_Info
+	default:
+		return Level_Debug
+	}
+}
@@ -0,0 +1,214 @@
+/*
+ * 异步输出日志，日志先写入有界的环形缓冲，由单独的协程批量写入，避免磁盘阻塞影响网络协程
+ * Author:slive
+ * DATE:2020/9/23
+ */
+package logger
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Overflow_DropNewest 缓冲满时丢弃新日志，默认
+	Overflow_DropNewest = "dropNewest"
+	// Overflow_DropOldest 缓冲满时丢弃最旧的日志
+	Overflow_DropOldest = "dropOldest"
+	// Overflow_Block 缓冲满时阻塞等待
+	Overflow_Block = "block"
+)
+
+// asyncBufSize 写入底层writer的批量缓冲大小
+const asyncBufSize = 64 * 1024
+
+// AsyncWriter 异步输出的writer，定时刷新，Close时刷新所有缓冲的日志，
+// Close后的日志直接同步写入底层writer
+type AsyncWriter struct {
+	writer   io.Writer
+	bw       *bufio.Writer
+	overflow string
+
+	// ring 环形缓冲，head为最旧日志的位置，size为日志条数
+	ring    [][]byte
+	head    int
+	size    int
+	closed  bool
+	mut     sync.Mutex
+	notFull *sync.Cond
+
+	dropped uint64
+
+	notify   chan struct{}
+	flushReq chan chan struct{}
+	exit     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewAsyncWriter 创建异步输出的writer，并启动输出协程
+// writer 底层的writer
+// logConf 异步的配置，包括AsyncBufferSize，AsyncOverflow和AsyncFlushInterval
+func NewAsyncWriter(writer io.Writer, logConf *LogConf) *AsyncWriter {
+	w := &AsyncWriter{
+		writer:   writer,
+		bw:       bufio.NewWriterSize(writer, asyncBufSize),
+		overflow: logConf.GetAsyncOverflow(),
+		ring:     make([][]byte, logConf.GetAsyncBufferSize()),
+		notify:   make(chan struct{}, 1),
+		flushReq: make(chan chan struct{}),
+		exit:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	w.notFull = sync.NewCond(&w.mut)
+	go w.run(logConf.GetAsyncFlushInterval())
+	return w
+}
+
+// Write 写入缓冲，缓冲满时按策略丢弃或者阻塞，丢弃时也返回成功
+func (w *AsyncWriter) Write(p []byte) (int, error) {
+	// 调用方可能复用p，需复制
+	data := append([]byte(nil), p...)
+	w.mut.Lock()
+	for !w.closed && w.size >= len(w.ring) {
+		switch w.overflow {
+		case Overflow_Block:
+			w.notFull.Wait()
+		case Overflow_DropOldest:
+			w.ring[w.head] = nil
+			w.head = (w.head + 1) % len(w.ring)
+			w.size--
+			atomic.AddUint64(&w.dropped, 1)
+		default:
+			w.mut.Unlock()
+			atomic.AddUint64(&w.dropped, 1)
+			return len(p), nil
+		}
+	}
+	if w.closed {
+		w.mut.Unlock()
+		return w.writer.Write(p)
+	}
+	w.ring[(w.head+w.size)%len(w.ring)] = data
+	w.size++
+	w.mut.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// Flush 等待已缓冲的日志写入底层writer
+func (w *AsyncWriter) Flush() {
+	done := make(chan struct{})
+	select {
+	case w.flushReq <- done:
+		<-done
+	case <-w.stopped:
+	}
+}
+
+// Close 停止输出协程，并写入所有缓冲的日志，底层writer需由调用方关闭
+func (w *AsyncWriter) Close() error {
+	w.mut.Lock()
+	if w.closed {
+		w.mut.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.notFull.Broadcast()
+	w.mut.Unlock()
+	close(w.exit)
+	<-w.stopped
+	return nil
+}
+
+// GetDropped 获取缓冲满时丢弃的日志条数
+func (w *AsyncWriter) GetDropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// GetPending 获取缓冲中等待输出的日志条数
+func (w *AsyncWriter) GetPending() int {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.size
+}
+
+// run 输出协程，有日志时批量写入，定时或者请求时刷新
+func (w *AsyncWriter) run(flushInterval time.Duration) {
+	ticker := time.NewTicker(flushInterval)
+	defer func() {
+		ticker.Stop()
+		close(w.stopped)
+	}()
+	for {
+		select {
+		case <-w.notify:
+			w.drain()
+		case <-ticker.C:
+			w.drain()
+			w.flush()
+		case done := <-w.flushReq:
+			w.drain()
+			w.flush()
+			close(done)
+		case <-w.exit:
+			w.drain()
+			w.flush()
+			return
+		}
+	}
+}
+
+// drain 取出缓冲中所有日志写入批量缓冲
+func (w *AsyncWriter) drain() {
+	w.mut.Lock()
+	if w.size <= 0 {
+		w.mut.Unlock()
+		return
+	}
+	batch := make([][]byte, 0, w.size)
+	for ; w.size > 0; w.size-- {
+		batch = append(batch, w.ring[w.head])
+		w.ring[w.head] = nil
+		w.head = (w.head + 1) % len(w.ring)
+	}
+	w.notFull.Broadcast()
+	w.mut.Unlock()
+
+	for _, data := range batch {
+		_, err := w.bw.Write(data)
+		if err != nil {
+			// 出错后bufio不再可写，丢弃后重置
+			log.Println("async write log error:", err)
+			w.bw.Reset(w.writer)
+		}
+	}
+}
+
+func (w *AsyncWriter) flush() {
+	err := w.bw.Flush()
+	if err != nil {
+		log.Println("async flush log error:", err)
+		w.bw.Reset(w.writer)
+	}
+}
+
+// getOverflow 解析缓冲满时的策略
+func getOverflow(overflow string) string {
+	switch strings.ToLower(overflow) {
+	case strings.ToLower(Overflow_DropOldest):
+		return Overflow_DropOldest
+	case strings.ToLower(Overflow_Block):
+		return Overflow_Block
+	default:
+		return Overflow_DropNewest
+	}
+}
@@ -0,0 +1,202 @@
+/*
+ * Author:slive
+ * DATE:2020/9/23
+ */
+package logger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockWriter gate关闭前阻塞写入
+type blockWriter struct {
+	buf     bytes.Buffer
+	mut     sync.Mutex
+	entered chan struct{}
+	gate    chan struct{}
+}
+
+func newBlockWriter() *blockWriter {
+	return &blockWriter{entered: make(chan struct{}, 1), gate: make(chan struct{})}
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	select {
+	case w.entered <- struct{}{}:
+	default:
+	}
+	<-w.gate
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *blockWriter) String() string {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.buf.String()
+}
+
+// newBlockedAsyncWriter 创建输出协程阻塞在写入a时的AsyncWriter，缓冲为2条
+func newBlockedAsyncWriter(t *testing.T, overflow string) (*AsyncWriter, *blockWriter) {
+	bw := newBlockWriter()
+	w := NewAsyncWriter(bw, &LogConf{AsyncBufferSize: 2, AsyncOverflow: overflow, AsyncFlushInterval: time.Hour})
+	w.Write([]byte("a"))
+	go w.Flush()
+	select {
+	case <-bw.entered:
+	case <-time.After(time.Second * 3):
+		t.Fatal("wait write timeout.")
+	}
+	return w, bw
+}
+
+func TestAsyncWriterOverflow(t *testing.T) {
+	expects := map[string]string{
+		Overflow_DropNewest: "abc",
+		Overflow_DropOldest: "acd",
+	}
+	for overflow, expect := range expects {
+		w, bw := newBlockedAsyncWriter(t, overflow)
+		for _, s := range []string{"b", "c", "d"} {
+			n, err := w.Write([]byte(s))
+			if n != 1 || err != nil {
+				t.Fatalf("write error:%v", err)
+			}
+		}
+		if w.GetDropped() != 1 || w.GetPending() != 2 {
+			t.Fatalf("%v dropped:%v, pending:%v", overflow, w.GetDropped(), w.GetPending())
+		}
+		close(bw.gate)
+		w.Close()
+		if bw.String() != expect {
+			t.Fatalf("%v expect %v, but %v", overflow, expect, bw.String())
+		}
+	}
+}
+
+func TestAsyncWriterBlock(t *testing.T) {
+	w, bw := newBlockedAsyncWriter(t, "BLOCK")
+	w.Write([]byte("b"))
+	w.Write([]byte("c"))
+	written := make(chan struct{})
+	go func() {
+		w.Write([]byte("d"))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("write should be blocked when buffer full.")
+	case <-time.After(time.Millisecond * 50):
+	}
+	close(bw.gate)
+	<-written
+	w.Close()
+	if bw.String() != "abcd" || w.GetDropped() != 0 {
+		t.Fatalf("unexpected output:%v", bw.String())
+	}
+}
+
+func TestAsyncWriterFlush(t *testing.T) {
+	bw := newBlockWriter()
+	close(bw.gate)
+	w := NewAsyncWriter(bw, &LogConf{AsyncFlushInterval: time.Millisecond * 10})
+	w.Write([]byte("tick"))
+	for i := 0; i < 100 && bw.String() != "tick"; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if bw.String() != "tick" {
+		t.Fatal("should flush periodically.")
+	}
+
+	w.Write([]byte(" flush"))
+	w.Flush()
+	if bw.String() != "tick flush" {
+		t.Fatalf("unexpected output after flush:%v", bw.String())
+	}
+
+	w.Close()
+	w.Write([]byte(" closed"))
+	w.Flush()
+	if bw.String() != "tick flush closed" {
+		t.Fatalf("should write directly after close:%v", bw.String())
+	}
+}
+
+func TestInitLoggerAsync(t *testing.T) {
+	dir := newTempDir(t)
+	defer os.RemoveAll(dir)
+	oldLogger, oldLevel := GetLogger(), GetLevel()
+	defer func() {
+		Close()
+		SetLogger(oldLogger)
+		SetLevel(oldLevel)
+	}()
+
+	InitLogger(&LogConf{LogDir: dir, LogFile: "async.log", Level: Level_Info, Async: true, AsyncFlushInterval: time.Hour})
+	Info("async msg")
+	Flush()
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "async.log"))
+	if !strings.Contains(string(data), "async msg") {
+		t.Fatalf("log should be written after flush:%v", string(data))
+	}
+}
+
+func TestFatalFlush(t *testing.T) {
+	// 子进程中异步输出后Fatal退出
+	dir := os.Getenv("GSFLY_FATAL_DIR")
+	if len(dir) > 0 {
+		InitLogger(&LogConf{LogDir: dir, LogFile: "fatal.log", Level: Level_Info, Async: true, AsyncFlushInterval: time.Hour})
+		Info("before fatal")
+		Fatalf("fatal %v", 1)
+		return
+	}
+
+	dir = newTempDir(t)
+	defer os.RemoveAll(dir)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFatalFlush$")
+	cmd.Env = append(os.Environ(), "GSFLY_FATAL_DIR="+dir)
+	err := cmd.Run()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != 1 {
+		t.Fatalf("fatal should exit with 1, err:%v", err)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "fatal.log"))
+	if !strings.Contains(string(data), "before fatal") || !strings.Contains(string(data), "fatal 1") {
+		t.Fatalf("buffered logs should be written before exit:%v", string(data))
+	}
+}
+
+func TestInitLoggerConcurrent(t *testing.T) {
+	dir := newTempDir(t)
+	defer os.RemoveAll(dir)
+	oldLogger, oldLevel := GetLogger(), GetLevel()
+	defer func() {
+		Close()
+		SetLogger(oldLogger)
+		SetLevel(oldLevel)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			InitLogger(&LogConf{LogDir: dir, LogFile: "concurrent.log", Level: Level_Info, Async: true})
+		}()
+		go func() {
+			defer wg.Done()
+			Info("concurrent msg")
+			Flush()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,159 @@
+/*
+ * 按channel开启debug日志，在未开启全局debug时，对匹配channel id，远端地址或者相对路径的上下文输出所有级别的日志，
+ * 匹配的依据为RunContext中的值，见common.Key_ChId等
+ * Author:slive
+ * DATE:2020/9/22
+ */
+package logger
+
+import (
+	"fmt"
+	logx "github.com/sirupsen/logrus"
+	"github.com/slive/gsfly/common"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// Target_ChId 按channel id匹配
+	Target_ChId = common.Key_ChId
+	// Target_RemoteAddr 按远端地址匹配，可为ip:port或者只有ip(匹配该ip的所有端口)
+	Target_RemoteAddr = common.Key_RemoteAddr
+	// Target_RelativePath 按相对路径匹配，如ws的请求路径
+	Target_RelativePath = common.Key_RelativePath
+)
+
+// debugTargets 开启debug的目标，key为目标类型，value为目标值集合，修改时整体替换
+var debugTargets atomic.Value
+
+// debugTargetNum 目标数，为0时不做匹配
+var debugTargetNum int32
+
+var debugTargetMut sync.Mutex
+
+func init() {
+	debugTargets.Store(map[string]map[string]bool{})
+}
+
+// AddDebugTarget 对匹配的channel开启debug日志
+// kind 目标类型，如Target_ChId
+// value 目标值
+func AddDebugTarget(kind string, value string) error {
+	if kind != Target_ChId && kind != Target_RemoteAddr && kind != Target_RelativePath {
+		return fmt.Errorf("unknown debug target kind:%v", kind)
+	}
+	debugTargetMut.Lock()
+	defer debugTargetMut.Unlock()
+	targets := copyDebugTargets()
+	values, ok := targets[kind]
+	if !ok {
+		values = make(map[string]bool)
+		targets[kind] = values
+	}
+	values[value] = true
+	storeDebugTargets(targets)
+	Warnf("add debug target, kind:%v, value:%v", kind, value)
+	return nil
+}
+
+// RemoveDebugTarget 移除开启debug日志的目标
+func RemoveDebugTarget(kind string, value string) {
+	debugTargetMut.Lock()
+	defer debugTargetMut.Unlock()
+	targets := copyDebugTargets()
+	values, ok := targets[kind]
+	if !ok {
+		return
+	}
+	delete(values, value)
+	if len(values) <= 0 {
+		delete(targets, kind)
+	}
+	storeDebugTargets(targets)
+	Warnf("remove debug target, kind:%v, value:%v", kind, value)
+}
+
+// ClearDebugTargets 清除所有开启debug日志的目标
+func ClearDebugTargets() {
+	debugTargetMut.Lock()
+	defer debugTargetMut.Unlock()
+	storeDebugTargets(map[string]map[string]bool{})
+}
+
+// GetDebugTargets 获取所有开启debug日志的目标，key为目标类型
+func GetDebugTargets() map[string][]string {
+	ret := make(map[string][]string)
+	for kind, values := range loadDebugTargets() {
+		for value := range values {
+			ret[kind] = append(ret[kind], value)
+		}
+	}
+	return ret
+}
+
+// IsDebugTrace 是否输出该上下文的debug日志，全局开启debug或者上下文匹配开启debug的目标
+func IsDebugTrace(ctx common.IRunContext) bool {
+	return isTraceEnabled(logx.DebugLevel, ctx)
+}
+
+// isTraceEnabled 是否输出该上下文该级别的日志
+func isTraceEnabled(level logx.Level, ctx common.IRunContext) bool {
+	if isEnabled(level) {
+		return true
+	}
+	if atomic.LoadInt32(&debugTargetNum) <= 0 {
+		return false
+	}
+	return matchDebugTarget(ctx) && GetLogger().IsLevelEnabled(fromLogrusLevel(level))
+}
+
+// matchDebugTarget 上下文是否匹配开启debug的目标
+func matchDebugTarget(ctx common.IRunContext) bool {
+	if ctx == nil || ctx.GetContext() == nil {
+		return false
+	}
+	for kind, values := range loadDebugTargets() {
+		value, ok := ctx.GetValue(kind).(string)
+		if !ok || len(value) <= 0 {
+			continue
+		}
+		if values[value] {
+			return true
+		}
+		if kind == Target_RemoteAddr {
+			// 只配置ip时匹配所有端口
+			host, _, err := net.SplitHostPort(value)
+			if err == nil && values[host] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func loadDebugTargets() map[string]map[string]bool {
+	return debugTargets.Load().(map[string]map[string]bool)
+}
+
+// copyDebugTargets 复制当前的目标，修改后整体替换，匹配时无需加锁
+func copyDebugTargets() map[string]map[string]bool {
+	targets := make(map[string]map[string]bool)
+	for kind, values := range loadDebugTargets() {
+		copyValues := make(map[string]bool, len(values))
+		for value := range values {
+			copyValues[value] = true
+		}
+		targets[kind] = copyValues
+	}
+	return targets
+}
+
+func storeDebugTargets(targets map[string]map[string]bool) {
+	num := 0
+	for _, values := range targets {
+		num += len(values)
+	}
+	debugTargets.Store(targets)
+	atomic.StoreInt32(&debugTargetNum, int32(num))
+}
@@ -8,16 +8,17 @@ import (
 	"fmt"
 	"github.com/slive/gsfly/common"
 	"github.com/slive/gsfly/util"
-	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
-	"github.com/rifflock/lfshook"
 	logx "github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/writer"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,8 +27,35 @@ const (
 	Level_Info  = "info"
 	Level_Warn  = "warn"
 	Level_Error = "error"
+	Level_Fatal = "fatal"
+	Level_Panic = "panic"
 )
 
+const (
+	// Format_Text 文本格式，默认
+	Format_Text = "text"
+	// Format_Json json格式，每行一个json对象，便于日志平台按字段索引
+	Format_Json = "json"
+)
+
+// 日志字段的默认名称，可通过LogConf.FieldNames修改输出的名称
+const (
+	Field_Time         = "time"
+	Field_Level        = "level"
+	Field_Msg          = "msg"
+	Field_File         = "file"
+	Field_Trace        = common.Key_Trace
+	Field_ChId         = common.Key_ChId
+	Field_Network      = common.Key_Network
+	Field_RemoteAddr   = common.Key_RemoteAddr
+	Field_RelativePath = common.Key_RelativePath
+	// Field_Suppressed 采样时被抑制的条数
+	Field_Suppressed = "suppressed"
+)
+
+// ctxFields 从上下文中获取的结构化字段
+var ctxFields = []string{Field_ChId, Field_Network, Field_RemoteAddr, Field_RelativePath}
+
 type LogConf struct {
 	// LogFile 日志文件
 	LogFile string
@@ -37,30 +65,146 @@ type LogConf struct {
 
 	Level string
 
+	// MaxRemainCount 保留的已切割文件数，默认为100
 	MaxRemainCount uint
+
+	// MaxSize 单个日志文件的最大字节数，超过后切割，<=0时只按天切割
+	MaxSize int64
+
+	// Compress 是否gzip压缩已切割的文件
+	Compress bool
+
+	// MaxTotalSize 日志文件(包括已切割的)占用的最大字节数，超过后删除最旧的已切割文件，<=0时不限制
+	MaxTotalSize int64
+
+	// LevelFiles 按级别单独输出的文件，key为级别，value为LogDir下的文件名，
+	// 输出该级别及更严重的日志，如{"error":"log-gsfly-error.log"}
+	LevelFiles map[string]string
+
+	// Async 是否异步输出到文件和控制台，避免磁盘阻塞影响网络协程
+	Async bool
+
+	// AsyncBufferSize 异步输出缓冲的日志条数，默认为8192
+	AsyncBufferSize int
+
+	// AsyncOverflow 异步输出缓冲满时的策略，见Overflow_xxx，默认为Overflow_DropNewest
+	AsyncOverflow string
+
+	// AsyncFlushInterval 异步输出定时刷新的间隔，默认为1秒
+	AsyncFlushInterval time.Duration
+
+	// Format 日志格式，Format_Text或者Format_Json，默认为Format_Text
+	Format string
+
+	// FieldNames 字段名映射，key为默认字段名(见Field_xxx)，value为输出的字段名，
+	// 如{"time":"@timestamp", "chId":"channel_id"}
+	FieldNames map[string]string
+}
+
+// GetMaxRemainCount 获取保留的已切割文件数，默认为100
+func (logConf *LogConf) GetMaxRemainCount() uint {
+	if logConf.MaxRemainCount <= 0 {
+		return 100
+	}
+	return logConf.MaxRemainCount
+}
+
+// GetAsyncBufferSize 获取异步输出缓冲的日志条数，默认为8192
+func (logConf *LogConf) GetAsyncBufferSize() int {
+	if logConf.AsyncBufferSize <= 0 {
+		return 8192
+	}
+	return logConf.AsyncBufferSize
+}
+
+// GetAsyncOverflow 获取异步输出缓冲满时的策略，默认为Overflow_DropNewest
+func (logConf *LogConf) GetAsyncOverflow() string {
+	return getOverflow(logConf.AsyncOverflow)
+}
+
+// GetAsyncFlushInterval 获取异步输出定时刷新的间隔，默认为1秒
+func (logConf *LogConf) GetAsyncFlushInterval() time.Duration {
+	if logConf.AsyncFlushInterval <= 0 {
+		return time.Second
+	}
+	return logConf.AsyncFlushInterval
+}
+
+// GetFormat 获取日志格式，默认为Format_Text
+func (logConf *LogConf) GetFormat() string {
+	if strings.ToLower(logConf.Format) == Format_Json {
+		return Format_Json
+	}
+	return Format_Text
+}
+
+// GetFieldName 获取字段输出的名称，未配置时为默认名称
+func (logConf *LogConf) GetFieldName(field string) string {
+	name, ok := logConf.FieldNames[field]
+	if ok && len(name) > 0 {
+		return name
+	}
+	return field
 }
 
 func (logConf *LogConf) GetLevel() logx.Level {
 	level := logConf.Level
 	if len(level) <= 0 {
 		level = Level_Debug
-	} else {
-		level = strings.ToLower(level)
 	}
-	switch level {
-	case Level_Debug:
+	ret, err := parseLevel(level)
+	if err != nil {
 		return logx.DebugLevel
+	}
+	return ret
+}
+
+// parseLevel 解析日志级别，只支持debug，info，warn和error
+func parseLevel(level string) (logx.Level, error) {
+	switch strings.ToLower(level) {
+	case Level_Debug:
+		return logx.DebugLevel, nil
 	case Level_Info:
-		return logx.InfoLevel
+		return logx.InfoLevel, nil
 	case Level_Warn:
-		return logx.WarnLevel
+		return logx.WarnLevel, nil
 	case Level_Error:
-		return logx.ErrorLevel
+		return logx.ErrorLevel, nil
 	default:
-		return logx.DebugLevel
+		return logx.DebugLevel, fmt.Errorf("unknown log level:%v", level)
+	}
+}
+
+// SetLevel 运行时修改日志级别
+// level 日志级别，如Level_Info
+func SetLevel(level string) error {
+	ret, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+	setLevel(ret)
+	return nil
+}
+
+// GetLevel 获取当前日志级别
+func GetLevel() string {
+	switch getLevel() {
+	case logx.PanicLevel, logx.FatalLevel, logx.ErrorLevel:
+		return Level_Error
+	default:
+		return fromLogrusLevel(getLevel())
 	}
 }
 
+// setLevel 修改日志级别，级别只在外层控制，以便按channel开启debug日志
+func setLevel(level logx.Level) {
+	atomic.StoreUint32(&logLevel, uint32(level))
+}
+
+func getLevel() logx.Level {
+	return logx.Level(atomic.LoadUint32(&logLevel))
+}
+
 func newDefaultLogConf() *LogConf {
 	logConf := &LogConf{
 		LogFile:        "log-gsfly.log",
@@ -75,10 +219,23 @@ func defaultLogDir() string {
 	return util.GetPwd() + "/log"
 }
 
-var logLevel logx.Level
+// logLevel 当前日志级别，未初始化时为info
+var logLevel = uint32(logx.InfoLevel)
 
 var initLogConf *LogConf
 
+// fileWriters 当前输出的日志文件
+var fileWriters []*RotateWriter
+
+// asyncWriters 当前异步输出的writer
+var asyncWriters []*AsyncWriter
+
+// writerMut InitLogger、Flush和Close并发时保护fileWriters和asyncWriters
+var writerMut sync.Mutex
+
+// fieldNames 当前生效的字段名映射
+var fieldNames = map[string]string{}
+
 func InitDefLogger() {
 	if initLogConf == nil {
 		InitLogger(newDefaultLogConf())
@@ -93,42 +250,50 @@ func InitLogger(logConf *LogConf) {
 	}
 	_ = mkdirLog(logdir)
 
-	filePath := path.Join(logdir, logConf.LogFile)
-	log.Println("filePath:", filePath)
-	var logfile *os.File = nil
-	if util.CheckFileExist(filePath) {
-		// 文件存在，打开
-		logfile, _ = os.OpenFile(filePath, os.O_APPEND|os.O_RDWR, 0644)
-	} else {
-		// 文件不存在，创建
-		logfile, _ = os.OpenFile(filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
-	}
-	logLevel = logConf.GetLevel()
-	log.Println("default level:", logLevel)
-	logx.SetLevel(logLevel)
-	logx.SetOutput(ioutil.Discard) // Send all logs to nowhere by default
-	logx.AddHook(&writer.Hook{ // Send logs with level higher than warning to stderr
-		Writer: logfile,
-		LogLevels: []logx.Level{
-			logx.PanicLevel,
-			logx.FatalLevel,
-			logx.ErrorLevel,
-			logx.WarnLevel,
-			logx.InfoLevel,
-			logx.DebugLevel,
-		},
-	})
+	level := logConf.GetLevel()
+	log.Println("default level:", level)
+	setLevel(level)
+	// 使用独立的logrus实例，不修改logrus的默认logger，重复初始化时替换，并关闭原有的文件
+	logger := logx.New()
+	// logrus不再过滤，由外层控制级别
+	logger.SetLevel(logx.DebugLevel)
+	logger.SetOutput(ioutil.Discard) // Send all logs to nowhere by default
+	writerMut.Lock()
+	defer writerMut.Unlock()
+	closeWriters()
+	wrapWriter := func(w io.Writer) io.Writer {
+		if !logConf.Async {
+			return w
+		}
+		asyncWriter := NewAsyncWriter(w, logConf)
+		asyncWriters = append(asyncWriters, asyncWriter)
+		return asyncWriter
+	}
+
+	fieldNames = newFieldNames(logConf)
+	formatter := newFormatter(logConf)
+	logger.SetFormatter(formatter)
+	hooks, writers, err := newFileHooks(logdir, logConf, formatter)
+	if err != nil {
+		log.Println("init log file error:", err)
+	}
+	for _, hook := range hooks {
+		hook.writer = wrapWriter(hook.writer)
+		logger.AddHook(hook)
+	}
+	fileWriters = writers
+	log.Println("filePath:", path.Join(logdir, logConf.LogFile))
 
-	logx.AddHook(&writer.Hook{ // Send info and debug logs to stdout
-		Writer: os.Stdout,
+	logger.AddHook(&writer.Hook{ // Send info and debug logs to stdout
+		Writer: wrapWriter(os.Stdout),
 		LogLevels: []logx.Level{
 			logx.InfoLevel,
 			logx.DebugLevel,
 		},
 	})
 
-	logx.AddHook(&writer.Hook{ // Send logs with level higher than warning to stderr
-		Writer: os.Stderr,
+	logger.AddHook(&writer.Hook{ // Send logs with level higher than warning to stderr
+		Writer: wrapWriter(os.Stderr),
 		LogLevels: []logx.Level{
 			logx.PanicLevel,
 			logx.FatalLevel,
@@ -137,19 +302,78 @@ func InitLogger(logConf *LogConf) {
 		},
 	})
 
-	tf := logx.TextFormatter{
+	initLogConf = logConf
+	SetLogger(NewLogrusLogger(logger))
+}
+
+// newFormatter 根据配置创建文本或者json格式
+func newFormatter(logConf *LogConf) logx.Formatter {
+	fieldMap := logx.FieldMap{
+		logx.FieldKeyTime:  logConf.GetFieldName(Field_Time),
+		logx.FieldKeyLevel: logConf.GetFieldName(Field_Level),
+		logx.FieldKeyMsg:   logConf.GetFieldName(Field_Msg),
+	}
+	timestampFormat := "2006-01-02 15:04:05.999999999"
+	if logConf.GetFormat() == Format_Json {
+		return &logx.JSONFormatter{
+			TimestampFormat: timestampFormat,
+			FieldMap:        fieldMap,
+		}
+	}
+	return &logx.TextFormatter{
 		DisableTimestamp: false,
 		FullTimestamp:    true,
-		TimestampFormat:  "2006-01-02 15:04:05.999999999",
+		TimestampFormat:  timestampFormat,
 		DisableSorting:   false,
+		FieldMap:         fieldMap,
+	}
+}
+
+// newFieldNames 生成自定义字段(非logrus内置字段)的名称映射
+func newFieldNames(logConf *LogConf) map[string]string {
+	names := make(map[string]string)
+	for _, field := range append([]string{Field_File, Field_Trace, Field_Suppressed}, ctxFields...) {
+		names[field] = logConf.GetFieldName(field)
 	}
-	logx.SetFormatter(&tf)
-	maxRemainCount := logConf.MaxRemainCount
-	if maxRemainCount <= 0 {
-		maxRemainCount = 100
+	return names
+}
+
+// fieldName 获取自定义字段输出的名称
+func fieldName(field string) string {
+	name, ok := fieldNames[field]
+	if ok {
+		return name
 	}
-	logx.AddHook(newRlfHook(maxRemainCount, filePath, &tf))
-	initLogConf = logConf
+	return field
+}
+
+// Flush 等待异步输出的日志写入，未开启异步时直接返回
+func Flush() {
+	writerMut.Lock()
+	defer writerMut.Unlock()
+	for _, asyncWriter := range asyncWriters {
+		asyncWriter.Flush()
+	}
+}
+
+// Close 关闭InitLogger创建的异步输出和日志文件，关闭前写入所有缓冲的日志，
+// 关闭后再输出的日志会重新打开文件同步写入
+func Close() {
+	writerMut.Lock()
+	defer writerMut.Unlock()
+	closeWriters()
+}
+
+// closeWriters 关闭异步输出和日志文件，需持有writerMut
+func closeWriters() {
+	for _, asyncWriter := range asyncWriters {
+		asyncWriter.Close()
+	}
+	asyncWriters = nil
+	for _, fileWriter := range fileWriters {
+		fileWriter.Close()
+	}
+	fileWriters = nil
 }
 
 func mkdirLog(dir string) (e error) {
@@ -171,31 +395,6 @@ func mkdirLog(dir string) (e error) {
 	return er
 }
 
-func newRlfHook(maxRemainCont uint, logName string, tf *logx.TextFormatter) logx.Hook {
-	writer, err := rotatelogs.New(logName+"%Y%m%d",
-		rotatelogs.WithLinkName(logName),
-		rotatelogs.WithRotationTime(time.Hour*24),
-		rotatelogs.WithRotationCount(maxRemainCont))
-	if err != nil {
-		logx.Errorf("config local file system for logger error: %v", err)
-	}
-
-	lfsHook := lfshook.NewHook(lfshook.WriterMap{
-		logx.DebugLevel: writer,
-		logx.InfoLevel:  writer,
-		logx.WarnLevel:  writer,
-		logx.ErrorLevel: writer,
-		logx.FatalLevel: writer,
-		logx.PanicLevel: writer,
-	}, tf)
-
-	return lfsHook
-}
-
-func caller() string {
-	return callerWithSkip(3)
-}
-
 func callerWithSkip(skip int) string {
 	lfmt := ""
 	pc, file, line, ok := runtime.Caller(skip)
@@ -231,127 +430,165 @@ func callerWithSkip(skip int) string {
 }
 
 func Println(logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Println(logs...)
+	if isEnabled(logx.InfoLevel) {
+		output(logx.InfoLevel, nil, strings.TrimSuffix(fmt.Sprintln(logs...), "\n"))
+	}
 }
 
 func Printf(format string, logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Printf(format, logs...)
+	if isEnabled(logx.InfoLevel) {
+		output(logx.InfoLevel, nil, fmt.Sprintf(format, logs...))
+	}
 }
 
 func PrintTracef(ctx common.IRunContext, format string, logs ...interface{}) {
-	field := withField(ctx)
-	field.Printf(format, logs...)
+	if isTraceEnabled(logx.InfoLevel, ctx) {
+		output(logx.InfoLevel, ctx, fmt.Sprintf(format, logs...))
+	}
+}
+
+// output 经由当前的日志实现输出，file为调用日志方法的位置
+func output(level logx.Level, ctx common.IRunContext, msg string) {
+	GetLogger().Log(fromLogrusLevel(level), newFields(ctx, callerWithSkip(3)), msg)
 }
 
-func withField(ctx common.IRunContext) *logx.Entry {
-	field := logx.WithField("file", callerWithSkip(3))
-	if ctx != nil {
-		field = field.WithField("trace", ctx.GetTrace())
+// newFields 生成结构化字段，包括file，trace，以及channel的id，网络类型和远端地址(存在时)
+func newFields(ctx common.IRunContext, file string) map[string]interface{} {
+	fields := map[string]interface{}{fieldName(Field_File): file}
+	if ctx != nil && ctx.GetContext() != nil {
+		fields[fieldName(Field_Trace)] = ctx.GetTrace()
+		for _, key := range ctxFields {
+			val := ctx.GetValue(key)
+			if val != nil {
+				fields[fieldName(key)] = val
+			}
+		}
 	}
-	return field
+	return fields
+}
+
+// isEnabled 是否输出该级别的日志
+func isEnabled(level logx.Level) bool {
+	return level <= getLevel() && GetLogger().IsLevelEnabled(fromLogrusLevel(level))
 }
 
 func IsDebug() bool {
-	return logx.IsLevelEnabled(logx.DebugLevel)
+	return isEnabled(logx.DebugLevel)
 }
 
 func Debug(logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Debug(logs...)
+	if isEnabled(logx.DebugLevel) {
+		output(logx.DebugLevel, nil, fmt.Sprint(logs...))
+	}
 }
 
 func Debugf(format string, logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Debugf(format, logs...)
+	if isEnabled(logx.DebugLevel) {
+		output(logx.DebugLevel, nil, fmt.Sprintf(format, logs...))
+	}
 }
 
 func DebugTracef(ctx common.IRunContext, format string, logs ...interface{}) {
-	field := withField(ctx)
-	field.Debugf(format, logs...)
+	if isTraceEnabled(logx.DebugLevel, ctx) {
+		output(logx.DebugLevel, ctx, fmt.Sprintf(format, logs...))
+	}
 }
 
 func Info(logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Info(logs...)
+	if isEnabled(logx.InfoLevel) {
+		output(logx.InfoLevel, nil, fmt.Sprint(logs...))
+	}
 }
 
 func InfoTrace(ctx common.IRunContext, logs ...interface{}) {
-	field := withField(ctx)
-	field.Info(logs...)
+	if isTraceEnabled(logx.InfoLevel, ctx) {
+		output(logx.InfoLevel, ctx, fmt.Sprint(logs...))
+	}
 }
 
 func Infof(format string, logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Infof(format, logs...)
+	if isEnabled(logx.InfoLevel) {
+		output(logx.InfoLevel, nil, fmt.Sprintf(format, logs...))
+	}
 }
 
 func InfoTracef(ctx common.IRunContext, format string, logs ...interface{}) {
-	field := withField(ctx)
-	field.Infof(format, logs...)
+	if isTraceEnabled(logx.InfoLevel, ctx) {
+		output(logx.InfoLevel, ctx, fmt.Sprintf(format, logs...))
+	}
 }
 
 func Warn(logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Warn(logs...)
+	if isEnabled(logx.WarnLevel) {
+		output(logx.WarnLevel, nil, fmt.Sprint(logs...))
+	}
 }
 
 func Warnf(format string, logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Warnf(format, logs...)
+	if isEnabled(logx.WarnLevel) {
+		output(logx.WarnLevel, nil, fmt.Sprintf(format, logs...))
+	}
 }
 
 func WarnTracef(ctx common.IRunContext, format string, logs ...interface{}) {
-	field := withField(ctx)
-	field.Warnf(format, logs...)
+	if isTraceEnabled(logx.WarnLevel, ctx) {
+		output(logx.WarnLevel, ctx, fmt.Sprintf(format, logs...))
+	}
 }
 
 func Error(logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Error(logs...)
+	if isEnabled(logx.ErrorLevel) {
+		output(logx.ErrorLevel, nil, fmt.Sprint(logs...))
+	}
 }
 
 func Errorf(format string, logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Errorf(format, logs...)
+	if isEnabled(logx.ErrorLevel) {
+		output(logx.ErrorLevel, nil, fmt.Sprintf(format, logs...))
+	}
 }
 
 func ErrorTracef(ctx common.IRunContext, format string, logs ...interface{}) {
-	field := withField(ctx)
-	field.Errorf(format, logs...)
+	if isTraceEnabled(logx.ErrorLevel, ctx) {
+		output(logx.ErrorLevel, ctx, fmt.Sprintf(format, logs...))
+	}
 }
 
+// Fatal 输出日志后退出进程，退出前关闭异步输出和日志文件，保证日志已写入
 func Fatal(logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Fatal(logs...)
+	output(logx.FatalLevel, nil, fmt.Sprint(logs...))
+	exit()
 }
 
 func Fatalf(format string, logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Fatalf(format, logs...)
+	output(logx.FatalLevel, nil, fmt.Sprintf(format, logs...))
+	exit()
 }
 
 func FatalTracef(ctx common.IRunContext, format string, logs ...interface{}) {
-	field := withField(ctx)
-	field.Fatalf(format, logs...)
+	output(logx.FatalLevel, ctx, fmt.Sprintf(format, logs...))
+	exit()
+}
+
+// exit 写入缓冲的日志后退出进程
+func exit() {
+	Close()
+	os.Exit(1)
 }
 
+// Panic 输出日志后panic
 func Panic(logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Panic(logs...)
+	output(logx.PanicLevel, nil, fmt.Sprint(logs...))
 	panic(logs)
 }
 
 func Panicf(format string, logs ...interface{}) {
-	field := logx.WithField("file", caller())
-	field.Panicf(format, logs...)
+	output(logx.PanicLevel, nil, fmt.Sprintf(format, logs...))
 	panic(logs)
 }
 
 // PanicTracef 支持
 func PanicTracef(ctx common.IRunContext, format string, logs ...interface{}) {
-	field := withField(ctx)
-	field.Panicf(format, logs...)
+	output(logx.PanicLevel, ctx, fmt.Sprintf(format, logs...))
 	panic(logs)
 }
@@ -4,7 +4,18 @@
  */
 package logger
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	logx "github.com/sirupsen/logrus"
+	"github.com/slive/gsfly/common"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestDebug(t *testing.T) {
 	Debug("测试debug...")
@@ -21,3 +32,230 @@ func TestWarn(t *testing.T) {
 func TestError(t *testing.T) {
 	Debug("测试error...")
 }
+
+func TestJsonFormat(t *testing.T) {
+	logConf := &LogConf{
+		Format:     "JSON",
+		FieldNames: map[string]string{Field_Time: "@timestamp", Field_ChId: "channel_id"},
+	}
+	oldNames := fieldNames
+	fieldNames = newFieldNames(logConf)
+	defer func() {
+		fieldNames = oldNames
+	}()
+
+	ctx := common.NewDefRunContext()
+	ctx.AddTrace("server#tcp#trace")
+	ctx.AddValue(common.Key_ChId, "server#tcp#a->b")
+	ctx.AddValue(common.Key_Network, "tcp")
+	entry := logx.WithFields(newFields(ctx, callerWithSkip(1)))
+	entry.Message = "hello"
+	entry.Level = logx.InfoLevel
+	entry.Time = time.Now()
+	data, err := newFormatter(logConf).Format(entry)
+	if err != nil {
+		t.Fatalf("format error:%v", err)
+	}
+
+	ret := make(map[string]interface{})
+	err = json.Unmarshal(data, &ret)
+	if err != nil {
+		t.Fatalf("unmarshal error:%v, data:%v", err, string(data))
+	}
+	expects := map[string]interface{}{
+		"msg":        "hello",
+		"level":      "info",
+		"trace":      "server#tcp#trace",
+		"channel_id": "server#tcp#a->b",
+		"network":    "tcp",
+	}
+	for key, val := range expects {
+		if ret[key] != val {
+			t.Fatalf("field %v expect %v, data:%v", key, val, string(data))
+		}
+	}
+	if ret["@timestamp"] == nil || ret["file"] == nil {
+		t.Fatalf("time and file should be exist, data:%v", string(data))
+	}
+	if _, ok := ret[Field_RemoteAddr]; ok {
+		t.Fatalf("empty field should not be output, data:%v", string(data))
+	}
+}
+
+type recordEntry struct {
+	level  string
+	fields map[string]interface{}
+	msg    string
+}
+
+type recordLogger struct {
+	entries []recordEntry
+	mut     sync.Mutex
+}
+
+func (l *recordLogger) Log(level string, fields map[string]interface{}, msg string) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.entries = append(l.entries, recordEntry{level: level, fields: fields, msg: msg})
+}
+
+func (l *recordLogger) IsLevelEnabled(level string) bool {
+	return true
+}
+
+type recordStructured struct {
+	method        string
+	msg           string
+	keysAndValues []interface{}
+}
+
+func (s *recordStructured) record(method string, msg string, keysAndValues []interface{}) {
+	s.method, s.msg, s.keysAndValues = method, msg, keysAndValues
+}
+
+func (s *recordStructured) Debugw(msg string, keysAndValues ...interface{}) {
+	s.record("debug", msg, keysAndValues)
+}
+
+func (s *recordStructured) Infow(msg string, keysAndValues ...interface{}) {
+	s.record("info", msg, keysAndValues)
+}
+
+func (s *recordStructured) Warnw(msg string, keysAndValues ...interface{}) {
+	s.record("warn", msg, keysAndValues)
+}
+
+func (s *recordStructured) Errorw(msg string, keysAndValues ...interface{}) {
+	s.record("error", msg, keysAndValues)
+}
+
+func TestSetLogger(t *testing.T) {
+	oldLogger, oldLevel := GetLogger(), GetLevel()
+	defer func() {
+		SetLogger(oldLogger)
+		SetLevel(oldLevel)
+	}()
+
+	recorder := &recordLogger{}
+	SetLogger(recorder)
+	SetLevel(Level_Info)
+	ctx := common.NewDefRunContext()
+	ctx.AddValue(common.Key_ChId, "client#tcp#a->b")
+	Debugf("debug %v", 1)
+	InfoTracef(ctx, "info %v", 2)
+	Println("print", 3)
+	if len(recorder.entries) != 2 {
+		t.Fatalf("debug should be filtered, entries:%+v", recorder.entries)
+	}
+	entry := recorder.entries[0]
+	if entry.level != Level_Info || entry.msg != "info 2" || entry.fields[Field_ChId] != "client#tcp#a->b" {
+		t.Fatalf("unexpected entry:%+v", entry)
+	}
+	if !strings.HasPrefix(entry.fields[Field_File].(string), "logger/logger_test.go#TestSetLogger") {
+		t.Fatalf("unexpected file:%v", entry.fields[Field_File])
+	}
+	if recorder.entries[1].msg != "print 3" {
+		t.Fatalf("unexpected print msg:%q", recorder.entries[1].msg)
+	}
+
+	SetLogger(nil)
+	if IsDebug() || GetLogger().IsLevelEnabled(Level_Error) {
+		t.Fatal("nil logger should be nop.")
+	}
+}
+
+func TestInitLoggerStandard(t *testing.T) {
+	dir := newTempDir(t)
+	defer os.RemoveAll(dir)
+	oldLogger, oldLevel := GetLogger(), GetLevel()
+	defer func() {
+		Close()
+		SetLogger(oldLogger)
+		SetLevel(oldLevel)
+	}()
+
+	// 不修改logrus的默认logger
+	std := logx.StandardLogger()
+	level, out, formatter, hookNum := std.GetLevel(), std.Out, std.Formatter, len(std.Hooks)
+	InitLogger(&LogConf{LogDir: dir, LogFile: "std.log", Level: Level_Debug})
+	if std.GetLevel() != level || std.Out != out || std.Formatter != formatter || len(std.Hooks) != hookNum {
+		t.Fatal("InitLogger should not modify logrus standard logger.")
+	}
+	logger, ok := GetLogger().(*LogrusLogger)
+	if !ok || logger.logger == std || !logger.IsLevelEnabled(Level_Debug) {
+		t.Fatalf("should use a private logrus logger:%v", GetLogger())
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewStdLogger(log.New(buf, "", 0))
+	logger.Log(Level_Warn, map[string]interface{}{"trace": "t1", "chId": "c1"}, "hello")
+	if buf.String() != "[WARN] hello chId=c1 trace=t1\n" {
+		t.Fatalf("unexpected output:%q", buf.String())
+	}
+}
+
+func TestStructuredLogger(t *testing.T) {
+	structured := &recordStructured{}
+	logger := NewStructuredLogger(structured)
+	logger.Log(Level_Fatal, map[string]interface{}{"trace": "t1", "chId": "c1"}, "hello")
+	if structured.method != "error" || structured.msg != "hello" || len(structured.keysAndValues) != 4 ||
+		structured.keysAndValues[0] != "chId" || structured.keysAndValues[3] != "t1" {
+		t.Fatalf("unexpected structured:%+v", structured)
+	}
+}
+
+func TestDebugTarget(t *testing.T) {
+	oldLogger, oldLevel := GetLogger(), GetLevel()
+	defer func() {
+		SetLogger(oldLogger)
+		SetLevel(oldLevel)
+		ClearDebugTargets()
+	}()
+	recorder := &recordLogger{}
+	SetLogger(recorder)
+	SetLevel(Level_Warn)
+
+	target := common.NewDefRunContext()
+	target.AddValue(common.Key_ChId, "server#tcp#a->b")
+	target.AddValue(common.Key_RemoteAddr, "10.0.0.1:5000")
+	other := common.NewDefRunContext()
+	other.AddValue(common.Key_RemoteAddr, "10.0.0.2:5000")
+	debugNum := func() int {
+		num := 0
+		for _, entry := range recorder.entries {
+			if entry.level == Level_Debug {
+				num++
+			}
+		}
+		return num
+	}
+
+	DebugTracef(target, "before")
+	if debugNum() != 0 || IsDebugTrace(target) {
+		t.Fatal("debug should be disabled.")
+	}
+	if AddDebugTarget("unknown", "a") == nil {
+		t.Fatal("unknown kind should be rejected.")
+	}
+	AddDebugTarget(Target_RemoteAddr, "10.0.0.1")
+	DebugTracef(target, "by ip")
+	DebugTracef(other, "other")
+	Debugf("no context")
+	if debugNum() != 1 || !IsDebugTrace(target) || IsDebugTrace(other) || IsDebug() {
+		t.Fatalf("only target should be debug, entries:%+v", recorder.entries)
+	}
+
+	RemoveDebugTarget(Target_RemoteAddr, "10.0.0.1")
+	AddDebugTarget(Target_ChId, "server#tcp#a->b")
+	DebugTracef(target, "by chId")
+	if debugNum() != 2 {
+		t.Fatalf("chId target should be debug, entries:%+v", recorder.entries)
+	}
+	ClearDebugTargets()
+	DebugTracef(target, "after")
+	if debugNum() != 2 || len(GetDebugTargets()) != 0 {
+		t.Fatal("debug targets should be cleared.")
+	}
+}
@@ -0,0 +1,367 @@
+/*
+ * 日志文件的切割，压缩和保留，按天和大小切割，切割后的文件名为：原文件名.时间，压缩后再加.gz
+ * Author:slive
+ * DATE:2020/9/22
+ */
+package logger
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	logx "github.com/sirupsen/logrus"
+	"github.com/slive/gsfly/util"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// backupTimeFormat 切割后文件名中的时间格式
+	backupTimeFormat = "20060102-150405.000000000"
+	compressSuffix   = ".gz"
+)
+
+// RotateWriter 可切割的日志文件，按天切割，配置MaxSize时也按大小切割，
+// 切割后异步压缩和清理，保留的文件数和占用的磁盘大小由LogConf配置
+type RotateWriter struct {
+	filename       string
+	maxSize        int64
+	maxRemainCount uint
+	maxTotalSize   int64
+	compress       bool
+
+	file    *os.File
+	size    int64
+	openDay string
+	mut     sync.Mutex
+
+	// millMut 压缩和清理串行执行
+	millMut sync.Mutex
+	millWg  sync.WaitGroup
+
+	// now 当前时间，便于测试
+	now func() time.Time
+}
+
+// NewRotateWriter 创建可切割的日志文件，首次写入时才打开文件
+// filename 日志文件路径
+// logConf 切割的配置，包括MaxSize，MaxRemainCount，MaxTotalSize和Compress
+func NewRotateWriter(filename string, logConf *LogConf) *RotateWriter {
+	return &RotateWriter{
+		filename:       filename,
+		maxSize:        logConf.MaxSize,
+		maxRemainCount: logConf.GetMaxRemainCount(),
+		maxTotalSize:   logConf.MaxTotalSize,
+		compress:       logConf.Compress,
+		now:            time.Now,
+	}
+}
+
+// GetFilename 获取日志文件路径
+func (w *RotateWriter) GetFilename() string {
+	return w.filename
+}
+
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.file == nil {
+		err := w.openFile()
+		if err != nil {
+			return 0, err
+		}
+	}
+	now := w.now()
+	overSize := w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize
+	if overSize || now.Format("20060102") != w.openDay {
+		err := w.rotate(now)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate 立即切割
+func (w *RotateWriter) Rotate() error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.file == nil {
+		err := w.openFile()
+		if err != nil {
+			return err
+		}
+	}
+	return w.rotate(w.now())
+}
+
+// Close 关闭文件，并等待压缩和清理完成
+func (w *RotateWriter) Close() error {
+	w.mut.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mut.Unlock()
+	w.millWg.Wait()
+	return err
+}
+
+// openFile 打开日志文件，已存在的文件如果不是当天的，则先切割
+func (w *RotateWriter) openFile() error {
+	err := os.MkdirAll(filepath.Dir(w.filename), 0775)
+	if err != nil {
+		return err
+	}
+	now := w.now()
+	info, err := os.Stat(w.filename)
+	if err == nil && info.ModTime().Format("20060102") != now.Format("20060102") {
+		err = w.backup(info.ModTime())
+		if err != nil {
+			return err
+		}
+		info = nil
+	}
+	file, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.size = 0
+	if info != nil {
+		w.size = info.Size()
+	}
+	w.openDay = now.Format("20060102")
+	return nil
+}
+
+// rotate 关闭当前文件并重命名，然后打开新文件，异步压缩和清理
+func (w *RotateWriter) rotate(now time.Time) error {
+	if w.file != nil {
+		err := w.file.Close()
+		w.file = nil
+		if err != nil {
+			return err
+		}
+	}
+	err := w.backup(now)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.size = 0
+	w.openDay = now.Format("20060102")
+
+	w.millWg.Add(1)
+	go func() {
+		defer w.millWg.Done()
+		w.mill()
+	}()
+	return nil
+}
+
+// backup 将当前文件重命名为带时间的文件
+func (w *RotateWriter) backup(t time.Time) error {
+	name := w.filename + "." + t.Format(backupTimeFormat)
+	for i := 1; util.CheckFileExist(name) || util.CheckFileExist(name+compressSuffix); i++ {
+		name = fmt.Sprintf("%v.%v.%v", w.filename, t.Format(backupTimeFormat), i)
+	}
+	err := os.Rename(w.filename, name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// backupFile 切割后的文件
+type backupFile struct {
+	path string
+	size int64
+	time time.Time
+}
+
+// listBackups 获取切割后的文件，按时间从新到旧排序
+func (w *RotateWriter) listBackups() ([]*backupFile, error) {
+	dir := filepath.Dir(w.filename)
+	prefix := filepath.Base(w.filename) + "."
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]*backupFile, 0)
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		timeStr := strings.TrimSuffix(strings.TrimPrefix(name, prefix), compressSuffix)
+		if len(timeStr) > len(backupTimeFormat) {
+			// 同一时间切割时的序号
+			timeStr = timeStr[:len(backupTimeFormat)]
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, timeStr, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, &backupFile{path: filepath.Join(dir, name), size: info.Size(), time: t})
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		if backups[i].time.Equal(backups[j].time) {
+			return backups[i].path > backups[j].path
+		}
+		return backups[i].time.After(backups[j].time)
+	})
+	return backups, nil
+}
+
+// mill 压缩切割后的文件，并清理超过数量或者大小的旧文件
+func (w *RotateWriter) mill() {
+	w.millMut.Lock()
+	defer w.millMut.Unlock()
+	backups, err := w.listBackups()
+	if err != nil {
+		log.Println("list log backups error:", err)
+		return
+	}
+
+	if w.compress {
+		for _, backup := range backups {
+			if strings.HasSuffix(backup.path, compressSuffix) {
+				continue
+			}
+			size, err := compressFile(backup.path)
+			if err != nil {
+				log.Println("compress log error:", err)
+				continue
+			}
+			backup.path += compressSuffix
+			backup.size = size
+		}
+	}
+
+	// 当前文件按最大可能的大小计算，保证总大小不超过限制
+	w.mut.Lock()
+	total := w.size
+	w.mut.Unlock()
+	if w.maxSize > total {
+		total = w.maxSize
+	}
+	for i, backup := range backups {
+		total += backup.size
+		overCount := w.maxRemainCount > 0 && uint(i) >= w.maxRemainCount
+		overSize := w.maxTotalSize > 0 && total > w.maxTotalSize
+		if overCount || overSize {
+			err := os.Remove(backup.path)
+			if err != nil && !os.IsNotExist(err) {
+				log.Println("remove log backup error:", err)
+			}
+		}
+	}
+}
+
+// compressFile 压缩文件为.gz，成功后删除原文件，返回压缩后的大小
+func compressFile(src string) (int64, error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+
+	dst := src + compressSuffix
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		srcFile.Close()
+		return 0, err
+	}
+	gw := gzip.NewWriter(dstFile)
+	_, err = io.Copy(gw, srcFile)
+	srcFile.Close()
+	if err == nil {
+		err = gw.Close()
+	}
+	if err == nil {
+		err = dstFile.Sync()
+	}
+	closeErr := dstFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return 0, err
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Remove(src)
+}
+
+// fileHook 输出日志到文件的hook
+type fileHook struct {
+	levels    []logx.Level
+	writer    io.Writer
+	formatter logx.Formatter
+}
+
+func newFileHook(writer io.Writer, formatter logx.Formatter, minLevel logx.Level) *fileHook {
+	levels := make([]logx.Level, 0)
+	for _, level := range logx.AllLevels {
+		if level <= minLevel {
+			levels = append(levels, level)
+		}
+	}
+	return &fileHook{levels: levels, writer: writer, formatter: formatter}
+}
+
+func (hook *fileHook) Levels() []logx.Level {
+	return hook.levels
+}
+
+func (hook *fileHook) Fire(entry *logx.Entry) error {
+	data, err := hook.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = hook.writer.Write(data)
+	return err
+}
+
+// newFileHooks 创建日志文件的hook，包括所有级别的主文件，以及按级别单独输出的文件
+func newFileHooks(logdir string, logConf *LogConf, formatter logx.Formatter) ([]*fileHook, []*RotateWriter, error) {
+	if len(logConf.LogFile) <= 0 {
+		return nil, nil, errors.New("log file is empty")
+	}
+	mainWriter := NewRotateWriter(filepath.Join(logdir, logConf.LogFile), logConf)
+	hooks := []*fileHook{newFileHook(mainWriter, formatter, logx.TraceLevel)}
+	writers := []*RotateWriter{mainWriter}
+
+	levels := make([]string, 0, len(logConf.LevelFiles))
+	for level := range logConf.LevelFiles {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+	for _, level := range levels {
+		minLevel, err := parseLevel(level)
+		if err != nil {
+			return nil, nil, err
+		}
+		writer := NewRotateWriter(filepath.Join(logdir, logConf.LevelFiles[level]), logConf)
+		hooks = append(hooks, newFileHook(writer, formatter, minLevel))
+		writers = append(writers, writer)
+	}
+	return hooks, writers, nil
+}
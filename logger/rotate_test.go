@@ -0,0 +1,164 @@
+/*
+ * Author:slive
+ * DATE:2020/9/22
+ */
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	logx "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gsfly-log")
+	if err != nil {
+		t.Fatalf("create temp dir error:%v", err)
+	}
+	return dir
+}
+
+func listFiles(t *testing.T, dir string) map[string]int64 {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir error:%v", err)
+	}
+	files := make(map[string]int64)
+	for _, info := range infos {
+		files[info.Name()] = info.Size()
+	}
+	return files
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := newTempDir(t)
+	defer os.RemoveAll(dir)
+	w := NewRotateWriter(filepath.Join(dir, "test.log"), &LogConf{MaxSize: 100, MaxRemainCount: 2})
+	line := []byte(strings.Repeat("a", 29) + "\n")
+	for i := 0; i < 20; i++ {
+		_, err := w.Write(line)
+		if err != nil {
+			t.Fatalf("write error:%v", err)
+		}
+	}
+	w.Close()
+
+	files := listFiles(t, dir)
+	if len(files) != 3 {
+		t.Fatalf("should remain 2 backups, files:%v", files)
+	}
+	for name, size := range files {
+		if size > 100 {
+			t.Fatalf("file %v over size:%v", name, size)
+		}
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	dir := newTempDir(t)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.log")
+	w := NewRotateWriter(filename, &LogConf{Compress: true})
+	content := []byte("hello gsfly\n")
+	w.Write(content)
+	err := w.Rotate()
+	if err != nil {
+		t.Fatalf("rotate error:%v", err)
+	}
+	w.Close()
+
+	backups, _ := w.listBackups()
+	if len(backups) != 1 || !strings.HasSuffix(backups[0].path, compressSuffix) {
+		t.Fatalf("should be one compressed backup, files:%v", listFiles(t, dir))
+	}
+	file, _ := os.Open(backups[0].path)
+	defer file.Close()
+	gr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("gzip reader error:%v", err)
+	}
+	data, _ := ioutil.ReadAll(gr)
+	if !bytes.Equal(data, content) {
+		t.Fatalf("unexpected content:%q", data)
+	}
+}
+
+func TestRotateTotalSize(t *testing.T) {
+	dir := newTempDir(t)
+	defer os.RemoveAll(dir)
+	w := NewRotateWriter(filepath.Join(dir, "test.log"), &LogConf{MaxSize: 100, MaxTotalSize: 250})
+	line := []byte(strings.Repeat("b", 49) + "\n")
+	for i := 0; i < 20; i++ {
+		w.Write(line)
+	}
+	w.Close()
+
+	var total int64
+	files := listFiles(t, dir)
+	for _, size := range files {
+		total += size
+	}
+	if total > 250 || len(files) < 2 {
+		t.Fatalf("total size over limit, files:%v", files)
+	}
+}
+
+func TestRotateDaily(t *testing.T) {
+	dir := newTempDir(t)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.log")
+	w := NewRotateWriter(filename, &LogConf{})
+	now := time.Now()
+	w.now = func() time.Time {
+		return now
+	}
+	w.Write([]byte("day1\n"))
+	now = now.Add(time.Hour * 24)
+	w.Write([]byte("day2\n"))
+	w.Close()
+
+	backups, _ := w.listBackups()
+	if len(backups) != 1 {
+		t.Fatalf("should rotate by day, files:%v", listFiles(t, dir))
+	}
+	data, _ := ioutil.ReadFile(filename)
+	if string(data) != "day2\n" {
+		t.Fatalf("unexpected content:%q", data)
+	}
+}
+
+func TestLevelFiles(t *testing.T) {
+	dir := newTempDir(t)
+	defer os.RemoveAll(dir)
+	logConf := &LogConf{LogFile: "all.log", LevelFiles: map[string]string{Level_Error: "error.log"}}
+	hooks, writers, err := newFileHooks(dir, logConf, &logx.JSONFormatter{})
+	if err != nil {
+		t.Fatalf("new file hooks error:%v", err)
+	}
+	logger := logx.New()
+	logger.SetOutput(ioutil.Discard)
+	logger.SetLevel(logx.DebugLevel)
+	for _, hook := range hooks {
+		logger.AddHook(hook)
+	}
+	logger.Info("info msg")
+	logger.Error("error msg")
+	for _, w := range writers {
+		w.Close()
+	}
+
+	all, _ := ioutil.ReadFile(filepath.Join(dir, "all.log"))
+	errLog, _ := ioutil.ReadFile(filepath.Join(dir, "error.log"))
+	if !strings.Contains(string(all), "info msg") || !strings.Contains(string(all), "error msg") {
+		t.Fatalf("unexpected all log:%v", string(all))
+	}
+	if strings.Contains(string(errLog), "info msg") || !strings.Contains(string(errLog), "error msg") {
+		t.Fatalf("unexpected error log:%v", string(errLog))
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * 日志采样，用于每个包都会输出日志的热点路径，避免高负载时日志量过大
+ * Author:slive
+ * DATE:2020/9/22
+ */
+package logger
+
+import (
+	"fmt"
+	logx "github.com/sirupsen/logrus"
+	"github.com/slive/gsfly/common"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sampler 日志采样，按key计数，每个周期内先输出first条，之后每thereafter条输出1条，
+// key应为有限的集合(如日志类型)，不可为channel id等
+type Sampler struct {
+	tick       time.Duration
+	first      uint64
+	thereafter uint64
+	counters   sync.Map
+	suppressed uint64
+
+	// now 当前时间，便于测试
+	now func() time.Time
+}
+
+// sampleCounter 单个key的计数
+type sampleCounter struct {
+	// resetAt 周期结束的时间，单位纳秒
+	resetAt int64
+	count   uint64
+	// suppressed 上次输出后被抑制的条数
+	suppressed uint64
+	// total 累计被抑制的条数
+	total uint64
+}
+
+// NewSampler 创建日志采样
+// tick 计数周期，<=0时不重置，即只输出先first条，之后每thereafter条输出1条
+// first 每个周期先输出的条数
+// thereafter 超过first后每thereafter条输出1条，为0时不再输出
+func NewSampler(tick time.Duration, first uint64, thereafter uint64) *Sampler {
+	return &Sampler{tick: tick, first: first, thereafter: thereafter, now: time.Now}
+}
+
+// NewRateSampler 创建按速率限制的日志采样，每秒每个key最多输出perSecond条
+func NewRateSampler(perSecond uint64) *Sampler {
+	return NewSampler(time.Second, perSecond, 0)
+}
+
+// Allow 该key的日志是否输出，sampler为nil时都输出
+func (s *Sampler) Allow(key string) bool {
+	ret, _ := s.check(key)
+	return ret
+}
+
+// check 返回是否输出，以及输出时上次输出后被抑制的条数
+func (s *Sampler) check(key string) (bool, uint64) {
+	if s == nil {
+		return true, 0
+	}
+	val, ok := s.counters.Load(key)
+	if !ok {
+		val, _ = s.counters.LoadOrStore(key, &sampleCounter{})
+	}
+	counter := val.(*sampleCounter)
+	if s.tick > 0 {
+		now := s.now().UnixNano()
+		resetAt := atomic.LoadInt64(&counter.resetAt)
+		if now >= resetAt && atomic.CompareAndSwapInt64(&counter.resetAt, resetAt, now+int64(s.tick)) {
+			atomic.StoreUint64(&counter.count, 0)
+		}
+	}
+	n := atomic.AddUint64(&counter.count, 1)
+	if n <= s.first || (s.thereafter > 0 && (n-s.first)%s.thereafter == 0) {
+		return true, atomic.SwapUint64(&counter.suppressed, 0)
+	}
+	atomic.AddUint64(&counter.suppressed, 1)
+	atomic.AddUint64(&counter.total, 1)
+	atomic.AddUint64(&s.suppressed, 1)
+	return false, 0
+}
+
+// GetSuppressed 获取累计被抑制的日志条数
+func (s *Sampler) GetSuppressed() uint64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&s.suppressed)
+}
+
+// GetSuppressedByKey 获取各key累计被抑制的日志条数
+func (s *Sampler) GetSuppressedByKey() map[string]uint64 {
+	ret := make(map[string]uint64)
+	if s == nil {
+		return ret
+	}
+	s.counters.Range(func(key, val interface{}) bool {
+		ret[key.(string)] = atomic.LoadUint64(&val.(*sampleCounter).total)
+		return true
+	})
+	return ret
+}
+
+// hotPathSampler 热点路径的日志采样，如每个包的统计日志
+var hotPathSampler atomic.Value
+
+func init() {
+	// 默认每秒每个key先输出10条，之后每1000条输出1条
+	hotPathSampler.Store(&samplerHolder{sampler: NewSampler(time.Second, 10, 1000)})
+}
+
+// samplerHolder atomic.Value需要存储相同的类型
+type samplerHolder struct {
+	sampler *Sampler
+}
+
+// SetHotPathSampler 设置热点路径的日志采样，为nil时不采样
+func SetHotPathSampler(sampler *Sampler) {
+	hotPathSampler.Store(&samplerHolder{sampler: sampler})
+}
+
+// GetHotPathSampler 获取热点路径的日志采样
+func GetHotPathSampler() *Sampler {
+	return hotPathSampler.Load().(*samplerHolder).sampler
+}
+
+// SampleDebugTracef 按采样输出debug日志，见SampleInfoTracef
+func SampleDebugTracef(sampler *Sampler, key string, ctx common.IRunContext, format string, logs ...interface{}) {
+	if isTraceEnabled(logx.DebugLevel, ctx) {
+		sampleOutput(logx.DebugLevel, sampler, key, ctx, format, logs...)
+	}
+}
+
+// SampleInfoTracef 按采样输出info日志，同一key超过采样限制时不输出，只记录被抑制的条数，
+// 输出时在suppressed字段中附加上次输出后被抑制的条数，匹配debug目标的上下文不采样，
+// 参数在输出时才格式化，可传入实现fmt.Stringer的对象以避免不必要的开销
+// sampler 日志采样，如GetHotPathSampler()，为nil时不采样
+// key 采样的key，如日志类型
+func SampleInfoTracef(sampler *Sampler, key string, ctx common.IRunContext, format string, logs ...interface{}) {
+	if isTraceEnabled(logx.InfoLevel, ctx) {
+		sampleOutput(logx.InfoLevel, sampler, key, ctx, format, logs...)
+	}
+}
+
+func sampleOutput(level logx.Level, sampler *Sampler, key string, ctx common.IRunContext, format string, logs ...interface{}) {
+	var suppressed uint64
+	// 匹配debug目标的不采样，也不计数
+	if atomic.LoadInt32(&debugTargetNum) <= 0 || !matchDebugTarget(ctx) {
+		var ok bool
+		ok, suppressed = sampler.check(key)
+		if !ok {
+			return
+		}
+	}
+	fields := newFields(ctx, callerWithSkip(3))
+	if suppressed > 0 {
+		fields[fieldName(Field_Suppressed)] = suppressed
+	}
+	GetLogger().Log(fromLogrusLevel(level), fields, fmt.Sprintf(format, logs...))
+}
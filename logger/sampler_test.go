@@ -0,0 +1,93 @@
+/*
+ * Author:slive
+ * DATE:2020/9/22
+ */
+package logger
+
+import (
+	"github.com/slive/gsfly/common"
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	now := time.Now()
+	sampler := NewSampler(time.Second, 2, 3)
+	sampler.now = func() time.Time {
+		return now
+	}
+	allows := make([]bool, 0)
+	for i := 0; i < 8; i++ {
+		allows = append(allows, sampler.Allow("key"))
+	}
+	// 先输出2条，之后每3条输出1条
+	expects := []bool{true, true, false, false, true, false, false, true}
+	for i, allow := range allows {
+		if allow != expects[i] {
+			t.Fatalf("unexpected allows:%v", allows)
+		}
+	}
+	if sampler.GetSuppressed() != 4 || sampler.GetSuppressedByKey()["key"] != 4 || !sampler.Allow("other") {
+		t.Fatalf("unexpected suppressed:%v", sampler.GetSuppressedByKey())
+	}
+
+	// 新的周期重新计数
+	now = now.Add(time.Second)
+	if !sampler.Allow("key") || !sampler.Allow("key") || sampler.Allow("key") {
+		t.Fatal("counter should be reset in new tick.")
+	}
+
+	var nilSampler *Sampler
+	if !nilSampler.Allow("key") || nilSampler.GetSuppressed() != 0 {
+		t.Fatal("nil sampler should allow all.")
+	}
+}
+
+func TestRateSampler(t *testing.T) {
+	sampler := NewRateSampler(5)
+	num := 0
+	for i := 0; i < 100; i++ {
+		if sampler.Allow("key") {
+			num++
+		}
+	}
+	if num != 5 || sampler.GetSuppressed() != 95 {
+		t.Fatalf("unexpected allow num:%v, suppressed:%v", num, sampler.GetSuppressed())
+	}
+}
+
+func TestSampleInfoTracef(t *testing.T) {
+	oldLogger, oldLevel := GetLogger(), GetLevel()
+	defer func() {
+		SetLogger(oldLogger)
+		SetLevel(oldLevel)
+		ClearDebugTargets()
+	}()
+	recorder := &recordLogger{}
+	SetLogger(recorder)
+	SetLevel(Level_Info)
+
+	ctx := common.NewDefRunContext()
+	ctx.AddValue(common.Key_ChId, "server#tcp#a->b")
+	sampler := NewSampler(0, 1, 3)
+	for i := 0; i < 4; i++ {
+		SampleInfoTracef(sampler, "statis", ctx, "statis:%v", i)
+	}
+	if len(recorder.entries) != 2 {
+		t.Fatalf("unexpected entries:%+v", recorder.entries)
+	}
+	entry := recorder.entries[1]
+	if entry.msg != "statis:3" || entry.fields[Field_Suppressed] != uint64(2) {
+		t.Fatalf("unexpected entry:%+v", entry)
+	}
+
+	// 匹配debug目标的不采样
+	AddDebugTarget(Target_ChId, "server#tcp#a->b")
+	num := len(recorder.entries)
+	for i := 0; i < 3; i++ {
+		SampleInfoTracef(sampler, "statis", ctx, "statis:%v", i)
+	}
+	if len(recorder.entries)-num != 3 || sampler.GetSuppressed() != 2 {
+		t.Fatalf("debug target should not be sampled, entries:%+v", recorder.entries)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ * 以prometheus文本格式输出gsfly的监控指标，可选使用，通过http.Handler对外提供，如：
+ * http.Handle("/metrics", metrics.NewExporter().AddEngine(e))
+ * Author:slive
+ * DATE:2020/9/21
+ */
+package metrics
+
+import (
+	gch "github.com/slive/gsfly/channel"
+	"github.com/slive/gsfly/engine"
+	logx "github.com/slive/gsfly/logger"
+	"github.com/slive/gsfly/socket"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+const (
+	// CONTENT_TYPE prometheus文本格式的content-type
+	CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+	// NAMESPACE 指标名前缀
+	NAMESPACE = "gsfly"
+)
+
+// Exporter 指标采集，每次请求时从统计中实时采集：
+// 1.按协议类型汇总的channel统计(包括客户端)
+// 2.已添加的ServerSocket的channel统计
+// 3.已添加的读协程池和默认读协程池的统计
+// 4.热点路径日志采样被抑制的条数
+type Exporter struct {
+	servers   []socket.IServerSocket
+	engines   []*engine.Engine
+	readPools map[string]*gch.ReadPool
+	mut       sync.RWMutex
+}
+
+// NewExporter 创建指标采集
+func NewExporter() *Exporter {
+	return &Exporter{readPools: make(map[string]*gch.ReadPool)}
+}
+
+// AddServer 添加需要采集的服务端
+func (e *Exporter) AddServer(serverSocket socket.IServerSocket) *Exporter {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.servers = append(e.servers, serverSocket)
+	return e
+}
+
+// AddEngine 添加需要采集的引擎，采集时包括引擎当前所有的服务端和读协程池
+func (e *Exporter) AddEngine(eng *engine.Engine) *Exporter {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.engines = append(e.engines, eng)
+	return e
+}
+
+// AddReadPool 添加需要采集的读协程池
+// name 读协程池名称，作为pool标签
+func (e *Exporter) AddReadPool(name string, readPool *gch.ReadPool) *Exporter {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.readPools[name] = readPool
+	return e
+}
+
+// ServeHTTP 输出指标
+func (e *Exporter) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	writer.Header().Set("Content-Type", CONTENT_TYPE)
+	err := e.Write(writer)
+	if err != nil {
+		logx.Warn("write metrics error:", err)
+	}
+}
+
+// Write 以文本格式输出所有指标
+func (e *Exporter) Write(w io.Writer) error {
+	return e.collect().write(w)
+}
+
+func (e *Exporter) collect() *registry {
+	r := newRegistry()
+
+	// 按协议类型汇总
+	networkSnapshots := gch.GetNetworkStatisSnapshots()
+	networks := make([]string, 0, len(networkSnapshots))
+	for network := range networkSnapshots {
+		networks = append(networks, string(network))
+	}
+	sort.Strings(networks)
+	for _, network := range networks {
+		collectChStatis(r, NAMESPACE+"_", networkSnapshots[gch.Network(network)], Label{"network", network})
+	}
+
+	// 按服务端汇总
+	servers, readPools := e.fetchTargets()
+	for _, serverSocket := range servers {
+		labels := []Label{{"server", serverSocket.GetId()}, {"network", serverSocket.GetConf().GetNetwork().String()}}
+		collectChStatis(r, NAMESPACE+"_server_", serverSocket.GetChStatis().Snapshot(), labels...)
+	}
+
+	names := make([]string, 0, len(readPools))
+	for name := range readPools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		collectReadPool(r, name, readPools[name].GetStatis())
+	}
+
+	suppressed := logx.GetHotPathSampler().GetSuppressedByKey()
+	keys := make([]string, 0, len(suppressed))
+	for key := range suppressed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		r.add(NAMESPACE+"_log_suppressed_total", TYPE_COUNTER, "Total hot path logs suppressed by sampling.",
+			float64(suppressed[key]), Label{"key", key})
+	}
+	return r
+}
+
+// fetchTargets 获取需要采集的服务端和读协程池，包括引擎中的和默认的读协程池，
+// 同一个服务端或者读协程池只采集一次，避免输出标签相同的重复指标
+func (e *Exporter) fetchTargets() ([]socket.IServerSocket, map[string]*gch.ReadPool) {
+	e.mut.RLock()
+	defer e.mut.RUnlock()
+	var servers []socket.IServerSocket
+	serverSet := make(map[socket.IServerSocket]bool)
+	addServers := func(targets []socket.IServerSocket) {
+		for _, serverSocket := range targets {
+			if serverSocket != nil && !serverSet[serverSocket] {
+				serverSet[serverSocket] = true
+				servers = append(servers, serverSocket)
+			}
+		}
+	}
+	readPools := make(map[string]*gch.ReadPool)
+	poolSet := make(map[*gch.ReadPool]bool)
+	addReadPool := func(name string, readPool *gch.ReadPool) {
+		if readPool != nil && !poolSet[readPool] {
+			poolSet[readPool] = true
+			readPools[name] = readPool
+		}
+	}
+
+	addServers(e.servers)
+	for _, eng := range e.engines {
+		addServers(eng.GetServers())
+	}
+	names := make([]string, 0, len(e.readPools))
+	for name := range e.readPools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		addReadPool(name, e.readPools[name])
+	}
+	for _, eng := range e.engines {
+		addReadPool(eng.GetId(), eng.GetReadPool())
+	}
+	addReadPool("default_server", gch.LoadDefReadPool(true))
+	addReadPool("default_client", gch.LoadDefReadPool(false))
+	return servers, readPools
+}
+
+// collectChStatis 采集channel汇总的统计
+func collectChStatis(r *registry, prefix string, snapshot gch.ChannelStatisSnapshot, labels ...Label) {
+	r.add(prefix+"channels_active", TYPE_GAUGE, "Number of active channels.",
+		float64(snapshot.OpenNum-snapshot.CloseNum), labels...)
+	r.add(prefix+"channels_accepted_total", TYPE_COUNTER, "Total number of channels became active.",
+		float64(snapshot.OpenNum), labels...)
+	r.add(prefix+"channels_closed_total", TYPE_COUNTER, "Total number of active channels closed.",
+		float64(snapshot.CloseNum), labels...)
+
+	r.add(prefix+"sent_bytes_total", TYPE_COUNTER, "Total bytes sent.",
+		float64(snapshot.Send.TotalByteNum), labels...)
+	r.add(prefix+"sent_packets_total", TYPE_COUNTER, "Total packets sent.",
+		float64(snapshot.Send.TotalPacketNum), labels...)
+	r.add(prefix+"sent_fail_packets_total", TYPE_COUNTER, "Total packets failed to send.",
+		float64(snapshot.Send.TotalFailPacketNum), labels...)
+	r.add(prefix+"received_bytes_total", TYPE_COUNTER, "Total bytes received.",
+		float64(snapshot.Rev.TotalByteNum), labels...)
+	r.add(prefix+"received_packets_total", TYPE_COUNTER, "Total packets received.",
+		float64(snapshot.Rev.TotalPacketNum), labels...)
+
+	codes := make([]string, 0, len(snapshot.ErrorNums))
+	for code := range snapshot.ErrorNums {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		codeLabels := append(append([]Label{}, labels...), Label{"code", code})
+		r.add(prefix+"handle_errors_total", TYPE_COUNTER, "Total handler errors by error code.",
+			float64(snapshot.ErrorNums[code]), codeLabels...)
+	}
+
+	r.addHistogram(prefix+"write_latency_seconds", "Latency from packet creation to written.",
+		snapshot.Send.Latency, labels...)
+}
+
+// collectReadPool 采集读协程池的统计
+func collectReadPool(r *registry, name string, statis *gch.ReadPoolStatis) {
+	label := Label{"pool", name}
+	prefix := NAMESPACE + "_read_pool_"
+	r.add(prefix+"queue_depth", TYPE_GAUGE, "Number of packets waiting in read queues.", float64(statis.Depth), label)
+	r.add(prefix+"queues", TYPE_GAUGE, "Number of read queues.", float64(statis.QueueNum), label)
+	r.add(prefix+"workers", TYPE_GAUGE, "Number of workers.", float64(statis.WorkerNum), label)
+	r.add(prefix+"idle_workers", TYPE_GAUGE, "Number of idle workers.", float64(statis.IdleNum), label)
+	r.add(prefix+"handled_total", TYPE_COUNTER, "Total packets handled.", float64(statis.HandledNum), label)
+	r.add(prefix+"dropped_total", TYPE_COUNTER, "Total packets dropped.", float64(statis.DropNum), label)
+	r.add(prefix+"rejected_total", TYPE_COUNTER, "Total times the reject policy applied.", float64(statis.RejectNum), label)
+	r.add(prefix+"max_wait_seconds", TYPE_GAUGE, "Max time a packet waited in queue.", statis.MaxWait.Seconds(), label)
+}
@@ -0,0 +1,138 @@
+/*
+ * Author:slive
+ * DATE:2020/9/21
+ */
+package metrics
+
+import (
+	gch "github.com/slive/gsfly/channel"
+	"github.com/slive/gsfly/common"
+	"github.com/slive/gsfly/engine"
+	"github.com/slive/gsfly/socket"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExporter(t *testing.T) {
+	serverConf := socket.NewTcpServerConf("127.0.0.1", 19104)
+	serverSocket := socket.NewServerSocket(nil, serverConf, gch.NewDefChHandle(func(ctx gch.IChHandleContext) {
+		ch := ctx.GetChannel()
+		packet := ch.NewPacket()
+		packet.SetData(ctx.GetPacket().GetData())
+		ch.Write(packet)
+		ctx.SetError(common.NewError2("ERR_BIZ", "biz error"))
+	}))
+	err := serverSocket.Listen()
+	if err != nil {
+		t.Fatalf("listen error:%v", err)
+	}
+	defer serverSocket.Close()
+
+	rev := make(chan struct{}, 1)
+	clientConf := socket.NewTcpClientConf("127.0.0.1", 19104)
+	clientSocket := socket.NewClientSocket(nil, clientConf, gch.NewDefChHandle(func(ctx gch.IChHandleContext) {
+		rev <- struct{}{}
+	}), nil)
+	err = clientSocket.Dial()
+	if err != nil {
+		t.Fatalf("dial error:%v", err)
+	}
+	defer clientSocket.Close()
+	packet := clientSocket.GetChannel().NewPacket()
+	packet.SetData([]byte("hello"))
+	clientSocket.GetChannel().Write(packet)
+	select {
+	case <-rev:
+	case <-time.After(time.Second * 3):
+		t.Fatal("wait echo timeout.")
+	}
+	// 处理方法返回后才记录错误
+	for i := 0; i < 100 && serverSocket.GetChStatis().GetErrorNums()["ERR_BIZ"] <= 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	exporter := NewExporter().AddServer(serverSocket)
+	recorder := httptest.NewRecorder()
+	exporter.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Header().Get("Content-Type") != CONTENT_TYPE {
+		t.Fatalf("unexpected content-type:%v", recorder.Header().Get("Content-Type"))
+	}
+	body := recorder.Body.String()
+
+	serverLabel := `server="` + serverSocket.GetId() + `",network="tcp"`
+	expects := []string{
+		"# TYPE gsfly_channels_active gauge",
+		`gsfly_server_channels_active{` + serverLabel + `} 1`,
+		`gsfly_server_channels_accepted_total{` + serverLabel + `} 1`,
+		`gsfly_server_received_packets_total{` + serverLabel + `} 1`,
+		`gsfly_server_received_bytes_total{` + serverLabel + `} 5`,
+		`gsfly_server_handle_errors_total{` + serverLabel + `,code="ERR_BIZ"} 1`,
+		`gsfly_server_write_latency_seconds_count{` + serverLabel + `} 1`,
+		`gsfly_server_write_latency_seconds_bucket{` + serverLabel + `,le="+Inf"} 1`,
+		`gsfly_read_pool_queue_depth{pool="default_server"}`,
+		"# TYPE gsfly_log_suppressed_total counter",
+	}
+	for _, expect := range expects {
+		if !strings.Contains(body, expect) {
+			t.Fatalf("metrics should contain %v, body:\n%v", expect, body)
+		}
+	}
+
+	// 每个采样行都需符合文本格式
+	sampleReg := regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{([a-zA-Z_][a-zA-Z0-9_]*="([^"\\]|\\.)*",?)*\})? [-+0-9.eE]+(Inf)?$`)
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !sampleReg.MatchString(line) {
+			t.Fatalf("invalid sample line:%v", line)
+		}
+	}
+}
+
+func TestExporterTargets(t *testing.T) {
+	e1 := engine.NewEngine(gch.NewReadPoolConf(1, 1))
+	e2 := engine.NewEngine(gch.NewReadPoolConf(1, 1))
+	if e1.GetId() == e2.GetId() {
+		t.Fatalf("engine id should be unique, id:%v", e1.GetId())
+	}
+	serverSocket := e1.NewServerSocket(socket.NewTcpServerConf("127.0.0.1", 19105), gch.NewDefChHandle(func(ctx gch.IChHandleContext) {}))
+
+	// 同时添加服务端和其所属的引擎，只输出一次
+	exporter := NewExporter().AddServer(serverSocket).AddEngine(e1).AddEngine(e2).AddEngine(e1)
+	builder := &strings.Builder{}
+	if err := exporter.Write(builder); err != nil {
+		t.Fatalf("write error:%v", err)
+	}
+	body := builder.String()
+	for _, eng := range []*engine.Engine{e1, e2} {
+		if !strings.Contains(body, `gsfly_read_pool_workers{pool="`+eng.GetId()+`"}`) {
+			t.Fatalf("metrics should contain pool of %v, body:\n%v", eng.GetId(), body)
+		}
+	}
+	samples := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		series := line[:strings.LastIndex(line, " ")]
+		if samples[series] {
+			t.Fatalf("duplicate series:%v", series)
+		}
+		samples[series] = true
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	r := newRegistry()
+	r.add("test_metric", TYPE_GAUGE, "help\nline", 1.5, Label{"name", "a\"b\\c\nd"})
+	builder := &strings.Builder{}
+	r.write(builder)
+	expect := "# HELP test_metric help\\nline\n# TYPE test_metric gauge\ntest_metric{name=\"a\\\"b\\\\c\\nd\"} 1.5\n"
+	if builder.String() != expect {
+		t.Fatalf("unexpected output:%q", builder.String())
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * prometheus文本格式(0.0.4)的输出，只依赖标准库
+ * Author:slive
+ * DATE:2020/9/21
+ */
+package metrics
+
+import (
+	"bufio"
+	gch "github.com/slive/gsfly/channel"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	TYPE_COUNTER   = "counter"
+	TYPE_GAUGE     = "gauge"
+	TYPE_HISTOGRAM = "histogram"
+)
+
+// Label 指标标签
+type Label struct {
+	Name  string
+	Value string
+}
+
+// sample 指标的一个采样
+type sample struct {
+	// 名称后缀，如直方图的_bucket
+	suffix string
+	labels []Label
+	value  float64
+}
+
+// family 同名指标，输出时同名指标必须连续
+type family struct {
+	name    string
+	help    string
+	typ     string
+	samples []sample
+}
+
+// registry 一次采集的所有指标，按添加顺序输出
+type registry struct {
+	families []*family
+	index    map[string]*family
+}
+
+func newRegistry() *registry {
+	return &registry{index: make(map[string]*family)}
+}
+
+func (r *registry) fetchFamily(name string, typ string, help string) *family {
+	f, ok := r.index[name]
+	if !ok {
+		f = &family{name: name, typ: typ, help: help}
+		r.index[name] = f
+		r.families = append(r.families, f)
+	}
+	return f
+}
+
+// add 添加counter或者gauge
+func (r *registry) add(name string, typ string, help string, value float64, labels ...Label) {
+	f := r.fetchFamily(name, typ, help)
+	f.samples = append(f.samples, sample{labels: labels, value: value})
+}
+
+// addHistogram 添加直方图，Counts为非累计的，输出时转为累计
+func (r *registry) addHistogram(name string, help string, h gch.HistogramSnapshot, labels ...Label) {
+	f := r.fetchFamily(name, TYPE_HISTOGRAM, help)
+	var acc int64
+	for i, bound := range h.Bounds {
+		acc += h.Counts[i]
+		bucketLabels := append(append([]Label{}, labels...), Label{"le", formatFloat(bound)})
+		f.samples = append(f.samples, sample{suffix: "_bucket", labels: bucketLabels, value: float64(acc)})
+	}
+	infLabels := append(append([]Label{}, labels...), Label{"le", "+Inf"})
+	f.samples = append(f.samples, sample{suffix: "_bucket", labels: infLabels, value: float64(h.Count)})
+	f.samples = append(f.samples, sample{suffix: "_sum", labels: labels, value: h.Sum})
+	f.samples = append(f.samples, sample{suffix: "_count", labels: labels, value: float64(h.Count)})
+}
+
+// write 按文本格式输出
+func (r *registry) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range r.families {
+		bw.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+		bw.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+		for _, s := range f.samples {
+			bw.WriteString(f.name + s.suffix)
+			if len(s.labels) > 0 {
+				bw.WriteByte('{')
+				for i, label := range s.labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(label.Name + "=\"" + escapeLabel(label.Value) + "\"")
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteString(" " + formatFloat(s.value) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelReplacer.Replace(value)
+}
@@ -0,0 +1,67 @@
+/*
+ * 并发安全的channel集合，用于服务端保存已接收的channel
+ * Author:slive
+ * DATE:2020/7/31
+ */
+package socket
+
+import (
+	"github.com/emirpasic/gods/maps/hashmap"
+	gch "github.com/slive/gsfly/channel"
+	"sync"
+)
+
+// ChannelMap 以channel id为key的channel集合，接收和释放channel的协程并发访问
+type ChannelMap struct {
+	channels *hashmap.Map
+	mut      sync.RWMutex
+}
+
+// NewChannelMap 创建channel集合
+func NewChannelMap() *ChannelMap {
+	return &ChannelMap{channels: hashmap.New()}
+}
+
+// Put 放入channel
+func (m *ChannelMap) Put(id string, channel gch.IChannel) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.channels.Put(id, channel)
+}
+
+// Get 获取channel，不存在时返回nil
+func (m *ChannelMap) Get(id string) gch.IChannel {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	channel, found := m.channels.Get(id)
+	if !found {
+		return nil
+	}
+	return channel.(gch.IChannel)
+}
+
+// Remove 移除channel
+func (m *ChannelMap) Remove(id string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.channels.Remove(id)
+}
+
+// Size channel数目
+func (m *ChannelMap) Size() int {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return m.channels.Size()
+}
+
+// Values 获取当前所有channel的快照
+func (m *ChannelMap) Values() []gch.IChannel {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	values := m.channels.Values()
+	channels := make([]gch.IChannel, 0, len(values))
+	for _, channel := range values {
+		channels = append(channels, channel.(gch.IChannel))
+	}
+	return channels
+}
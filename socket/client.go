@@ -6,16 +6,18 @@
 package socket
 
 import (
-	"errors"
 	"fmt"
 	gch "github.com/slive/gsfly/channel"
 	"github.com/slive/gsfly/channel/tcpx"
 	"github.com/slive/gsfly/channel/udpx"
 	"github.com/slive/gsfly/channel/udpx/kcpx"
 	logx "github.com/slive/gsfly/logger"
+	"github.com/slive/gsfly/tracex"
 	"github.com/gorilla/websocket"
 	"github.com/xtaci/kcp-go"
 	"net"
+	"net/http"
+	"time"
 )
 
 // IClientSocket 客户端conn
@@ -58,7 +60,22 @@ func (clientSocket *ClientSocket) GetConf() IClientConf {
 	return clientSocket.Conf
 }
 
+// Dial 拨号，配置了读协程池时先创建独享的读协程池
 func (clientSocket *ClientSocket) Dial() error {
+	clientSocket.reopen()
+	err := clientSocket.initReadPool(clientSocket.GetConf().GetReadPoolConf())
+	if err != nil {
+		logx.ErrorTracef(clientSocket, "init readPool error:%v", err)
+		return err
+	}
+	err = clientSocket.dial()
+	if err != nil {
+		clientSocket.releaseReadPool()
+	}
+	return err
+}
+
+func (clientSocket *ClientSocket) dial() error {
 	network := clientSocket.GetConf().GetNetwork()
 	switch network {
 	case gch.NETWORK_WS:
@@ -75,7 +92,6 @@ func (clientSocket *ClientSocket) Dial() error {
 	default:
 		return nil
 	}
-	return errors.New("start clentstrap error.")
 }
 
 // dialWs 拨号实现ws
@@ -105,7 +121,17 @@ func dialWs(cs *ClientSocket) error {
 		}
 	}
 	logx.InfoTracef(cs, "dial ws url:%v", url)
-	conn, response, err := websocket.DefaultDialer.Dial(url, nil)
+	dialer := websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  wsClientConf.GetReadTimeout() * time.Second,
+		ReadBufferSize:    wsClientConf.GetReadBufSize(),
+		WriteBufferSize:   wsClientConf.GetWriteBufSize(),
+		EnableCompression: wsClientConf.IsCompressEnable(),
+	}
+	// 在握手header中注入socket上下文的traceparent，如由http入口传递过来的
+	header := http.Header{}
+	tracex.Inject(cs.GetContext(), tracex.HeaderCarrier(header))
+	conn, response, err := dialer.Dial(url, header)
 	if err != nil {
 		logx.Error("dial ws error:", err)
 		return err
@@ -119,7 +145,7 @@ func dialWs(cs *ClientSocket) error {
 	err = wsCh.Open()
 	if err == nil {
 		cs.Channel = wsCh
-		cs.Closed = false
+		cs.SetClosed(false)
 	}
 	return err
 }
@@ -154,7 +180,7 @@ func dialTcp(cs *ClientSocket) error {
 	err = tcpCh.Open()
 	if err == nil {
 		cs.Channel = tcpCh
-		cs.Closed = false
+		cs.SetClosed(false)
 	}
 	return err
 }
@@ -182,7 +208,7 @@ func dialKcp(cs *ClientSocket) error {
 	err = kcpCh.Open()
 	if err == nil {
 		cs.Channel = kcpCh
-		cs.Closed = false
+		cs.SetClosed(false)
 	}
 	return err
 }
@@ -217,22 +243,30 @@ func dialUdp(cs *ClientSocket) error {
 	err = udpCh.Open()
 	if err == nil {
 		cs.Channel = udpCh
-		cs.Closed = false
+		cs.SetClosed(false)
 	}
 	return err
 }
 
+// Close 释放channel并关闭连接，重复调用时直接返回
 func (clientSocket *ClientSocket) Close() {
-	if !clientSocket.Closed {
-		defer func() {
-			ret := recover()
-			logx.InfoTracef(clientSocket, "finish to stop client, ret:%v", ret)
-		}()
-		logx.InfoTracef(clientSocket, "start to stop client.")
-		clientSocket.Closed = true
-		clientSocket.Exit <- true
-		clientSocket.Channel.Release()
-		clientSocket.Channel = nil
-		clientSocket.Channel.GetConn().Close()
+	if !clientSocket.tryClose() {
+		return
+	}
+	defer func() {
+		ret := recover()
+		clientSocket.Cancel()
+		clientSocket.releaseReadPool()
+		logx.InfoTracef(clientSocket, "finish to stop client, ret:%v", ret)
+	}()
+	logx.InfoTracef(clientSocket, "start to stop client.")
+	channel := clientSocket.Channel
+	if channel != nil {
+		channel.Release()
+		// udp的channel释放时不关闭conn，统一在这里关闭
+		conn := channel.GetConn()
+		if conn != nil {
+			conn.Close()
+		}
 	}
 }
@@ -7,6 +7,7 @@ package socket
 
 import (
 	"github.com/slive/gsfly/channel"
+	"github.com/slive/gsfly/channel/tcpx"
 	"github.com/slive/gsfly/common"
 	"net/url"
 )
@@ -28,6 +29,32 @@ type IServerConf interface {
 
 	// GetListenConfs 监听相关的配置
 	GetListenConfs() []IServerChildConf
+
+	IReadPoolConfHolder
+}
+
+// IReadPoolConfHolder socket独享的读协程池配置
+type IReadPoolConfHolder interface {
+	// GetReadPoolConf 读协程池配置，不为nil时socket创建独享的读协程池，否则使用父节点的或者默认的
+	GetReadPoolConf() *channel.ReadPoolConf
+
+	// SetReadPoolConf 设置读协程池配置
+	SetReadPoolConf(readPoolConf *channel.ReadPoolConf)
+}
+
+// ReadPoolConfHolder socket独享的读协程池配置
+type ReadPoolConfHolder struct {
+	readPoolConf *channel.ReadPoolConf
+}
+
+// GetReadPoolConf 读协程池配置，可为nil
+func (holder *ReadPoolConfHolder) GetReadPoolConf() *channel.ReadPoolConf {
+	return holder.readPoolConf
+}
+
+// SetReadPoolConf 设置读协程池配置
+func (holder *ReadPoolConfHolder) SetReadPoolConf(readPoolConf *channel.ReadPoolConf) {
+	holder.readPoolConf = readPoolConf
 }
 
 type IServerChildConf interface {
@@ -66,6 +93,7 @@ type ServerConf struct {
 	channel.ChannelConf
 	common.Id
 	common.Parent
+	ReadPoolConfHolder
 	maxChannelSize int
 
 	listenConfs []IServerChildConf
@@ -101,11 +129,15 @@ const WS_SUBPROTOCOL_KEY = "WS_SUBPROTOCOL_KEY"
 
 type IWsServerConf interface {
 	IServerConf
+	tcpx.IWsCompressConf
+	tcpx.IWsStreamConf
 	GetScheme() string
 }
 
 type WsServerConf struct {
 	ServerConf
+	tcpx.WsCompressConf
+	tcpx.WsStreamConf
 	scheme string
 }
 
@@ -130,12 +162,14 @@ func (wsServerConf *WsServerConf) GetScheme() string {
 type IClientConf interface {
 	channel.IAddrConf
 	channel.IChannelConf
+	IReadPoolConfHolder
 }
 
 // ClientConf 客户端配置
 type ClientConf struct {
 	channel.AddrConf
 	channel.ChannelConf
+	ReadPoolConfHolder
 }
 
 // NewClientConf 创建客户端配置
@@ -254,6 +288,8 @@ func (wsConf *WsConf) GetSubProtocol() []string {
 type IWsClientConf interface {
 	IClientConf
 	IWsConf
+	tcpx.IWsCompressConf
+	tcpx.IWsStreamConf
 	GetUrlByPath(path string) string
 	GetScheme() string
 }
@@ -261,6 +297,8 @@ type IWsClientConf interface {
 type WsClientConf struct {
 	ClientConf
 	WsConf
+	tcpx.WsCompressConf
+	tcpx.WsStreamConf
 	scheme string
 }
 
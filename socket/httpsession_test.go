@@ -0,0 +1,240 @@
+/*
+ * Author:slive
+ * DATE:2020/9/26
+ */
+package socket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	gch "github.com/slive/gsfly/channel"
+	"github.com/slive/gsfly/channel/httpx"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newHttpSessionServer 创建sse(/sse)和长轮询(/poll)的测试服务，收到的消息加上echo:前缀返回，
+// served在每个请求处理完后通知请求的方法
+func newHttpSessionServer(t *testing.T, readTimeout time.Duration, released chan string) (*httptest.Server, chan string) {
+	serverConf := NewWsServerConf("127.0.0.1", 0, "ws",
+		NewServerChildConf(gch.NETWORK_SSE, "/sse"), NewServerChildConf(gch.NETWORK_POLL, "/poll"))
+	serverConf.ReadTimeout = readTimeout
+	serverConf.CloseRevFailTime = 1
+	serverConf.WriteTimeout = 3
+	handle := gch.NewDefChHandle(func(ctx gch.IChHandleContext) {
+		ch := ctx.GetChannel()
+		packet := ch.NewPacket()
+		packet.SetData([]byte("echo:" + string(ctx.GetPacket().GetData())))
+		ch.Write(packet)
+	})
+	handle.SetOnRelease(func(ctx gch.IChHandleContext) {
+		released <- ctx.GetChannel().(*httpx.HttpChannel).GetSessionId()
+	})
+	ss := NewServerSocket(nil, serverConf, handle)
+	proxy := newProxyHandler(http.NotFoundHandler(), websocket.Upgrader{}, ss, &sync.Map{})
+	served := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		proxy.ServeHTTP(writer, req)
+		served <- req.Method
+	}))
+	t.Cleanup(func() {
+		server.Close()
+		ss.Close()
+	})
+	return server, served
+}
+
+func doHttp(t *testing.T, method string, url string, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("new request error:%v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%v %v error:%v", method, url, err)
+	}
+	return resp
+}
+
+func poll(t *testing.T, url string) *httpx.PollResult {
+	resp := doHttp(t, http.MethodGet, url, "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected poll status:%v", resp.StatusCode)
+	}
+	result := &httpx.PollResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		t.Fatalf("decode poll result error:%v", err)
+	}
+	return result
+}
+
+func waitString(t *testing.T, ch chan string, expect string) {
+	select {
+	case got := <-ch:
+		if got != expect {
+			t.Fatalf("expect:%v, actual:%v", expect, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wait %v timeout.", expect)
+	}
+}
+
+func drainServed(served chan string) {
+	for {
+		select {
+		case <-served:
+		default:
+			return
+		}
+	}
+}
+
+func TestHttpPollSession(t *testing.T) {
+	released := make(chan string, 10)
+	server, served := newHttpSessionServer(t, 10, released)
+	url := server.URL + "/poll"
+
+	// 无sid时创建会话，立即返回sid
+	result := poll(t, url)
+	sid := result.Sid
+	if len(sid) <= 0 || len(result.Msgs) != 0 {
+		t.Fatalf("unexpected create result:%+v", result)
+	}
+	sidUrl := url + "?sid=" + sid
+
+	resp := doHttp(t, http.MethodPost, sidUrl, "hello")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get(httpx.HEADER_SID) != sid {
+		t.Fatalf("unexpected post status:%v", resp.StatusCode)
+	}
+	result = poll(t, sidUrl)
+	if len(result.Msgs) != 1 || result.Msgs[0] != "echo:hello" {
+		t.Fatalf("unexpected poll result:%+v", result)
+	}
+
+	// 未知的sid和缺少sid
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+		resp = doHttp(t, method, url+"?sid=unknown", "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("unknown sid should be not found, method:%v, status:%v", method, resp.StatusCode)
+		}
+	}
+	resp = doHttp(t, http.MethodPost, url, "hello")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("post without sid should be bad request, status:%v", resp.StatusCode)
+	}
+
+	// 客户端断开时，长轮询立即结束，会话保留
+	drainServed(served)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest(http.MethodGet, sidUrl, nil)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	if _, err := http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+		t.Fatal("canceled poll should fail.")
+	}
+	waitString(t, served, http.MethodGet)
+	if time.Since(start) >= 2*time.Second {
+		t.Fatal("poll should end by client disconnect.")
+	}
+	resp = doHttp(t, http.MethodPost, sidUrl, "again")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("session should remain after disconnect, status:%v", resp.StatusCode)
+	}
+
+	// 关闭会话
+	resp = doHttp(t, http.MethodDelete, sidUrl, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected delete status:%v", resp.StatusCode)
+	}
+	waitString(t, released, sid)
+	resp = doHttp(t, http.MethodGet, sidUrl, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("deleted session should be not found, status:%v", resp.StatusCode)
+	}
+}
+
+func TestHttpSseSession(t *testing.T) {
+	released := make(chan string, 10)
+	server, served := newHttpSessionServer(t, 10, released)
+	url := server.URL + "/sse"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("sse error:%v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content-type:%v", resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	readLine := func() string {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read sse error:%v", err)
+		}
+		return strings.TrimSuffix(line, "\n")
+	}
+	if readLine() != "event: open" {
+		t.Fatal("first event should be open.")
+	}
+	sid := strings.TrimPrefix(readLine(), "data: ")
+	if sid != resp.Header.Get(httpx.HEADER_SID) {
+		t.Fatalf("unexpected sid:%v", sid)
+	}
+	readLine()
+
+	sidUrl := url + "?sid=" + sid
+	post := doHttp(t, http.MethodPost, sidUrl, "hello")
+	post.Body.Close()
+	if line := readLine(); line != "data: echo:hello" {
+		t.Fatalf("unexpected sse data:%v", line)
+	}
+	readLine()
+
+	// 客户端断开时sse结束，会话保留
+	drainServed(served)
+	cancel()
+	waitString(t, served, http.MethodGet)
+	post = doHttp(t, http.MethodPost, sidUrl, "again")
+	post.Body.Close()
+	if post.StatusCode != http.StatusNoContent {
+		t.Fatalf("session should remain after disconnect, status:%v", post.StatusCode)
+	}
+
+	del := doHttp(t, http.MethodDelete, sidUrl, "")
+	del.Body.Close()
+	waitString(t, released, sid)
+}
+
+func TestHttpSessionExpire(t *testing.T) {
+	released := make(chan string, 10)
+	// 1s内没有请求即过期
+	server, _ := newHttpSessionServer(t, 1, released)
+	url := server.URL + "/poll"
+	sid := poll(t, url).Sid
+	waitString(t, released, sid)
+	resp := doHttp(t, http.MethodGet, url+"?sid="+sid, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expired session should be not found, status:%v", resp.StatusCode)
+	}
+}
@@ -6,22 +6,24 @@
 package socket
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	gch "github.com/slive/gsfly/channel"
+	"github.com/slive/gsfly/channel/httpx"
 	"github.com/slive/gsfly/channel/tcpx"
 	"github.com/slive/gsfly/channel/udpx"
 	kcpx "github.com/slive/gsfly/channel/udpx/kcpx"
 	logx "github.com/slive/gsfly/logger"
+	"github.com/slive/gsfly/tracex"
 	"github.com/emirpasic/gods/maps/hashmap"
 	"github.com/gorilla/websocket"
 	"github.com/xtaci/kcp-go"
+	"io"
 	"net"
 	http "net/http"
-	"os"
-	"os/signal"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 )
 
@@ -29,7 +31,10 @@ import (
 type IServerSocket interface {
 	ISocket
 	GetConf() IServerConf
+	// GetChannels 获取底层的channel集合，非并发安全，兼容保留，建议使用GetChannelMap
 	GetChannels() *hashmap.Map
+	// GetChannelMap 获取并发安全的channel集合
+	GetChannelMap() *ChannelMap
 	Listen() error
 
 	// GetHttpServer 针对http
@@ -38,17 +43,43 @@ type IServerSocket interface {
 
 	// GetBasePath 监听基本path，符合该规则（有优先级控制）匹配的requestPath都可以进来
 	GetBasePath() string
+
+	// Shutdown 优雅关闭，等待处理完毕直到ctx超时
+	Shutdown(ctx context.Context) error
+
+	// SetOnShutdown 设置优雅关闭时对每个channel的处理方法，如发送告别包
+	SetOnShutdown(onShutdown gch.ChHandleFunc)
+
+	// GetChStatis 获取所有channel汇总的统计
+	GetChStatis() *gch.ChannelStatis
 }
 
 // ServerSocket 服务监听
 type ServerSocket struct {
 	Socket
 	Conf       IServerConf
-	channels   *hashmap.Map
+	channels   *ChannelMap
 	httpServer *http.Server
 	basePath   string
+
+	// 内部创建的监听，关闭时需要释放
+	listener        io.Closer
+	innerHttpServer *http.Server
+
+	// 优雅关闭时对每个channel的处理
+	onShutdown gch.ChHandleFunc
+
+	// 所有channel汇总的统计
+	chStatis *gch.ChannelStatis
 }
 
+const (
+	// ACCEPT_RETRY_DELAY 接收出现临时错误时重试的间隔
+	ACCEPT_RETRY_DELAY = time.Millisecond * 50
+	// SHUTDOWN_CHECK_INTERVAL 优雅关闭时检查是否处理完毕的间隔
+	SHUTDOWN_CHECK_INTERVAL = time.Millisecond * 20
+)
+
 // NewServerSocket 创建服务监听器
 // parent 父类
 // serverConf 服务端配置
@@ -68,33 +99,210 @@ func NewServerSocket(parent interface{}, serverConf IServerConf, chHandle gch.IC
 
 	b := &ServerSocket{
 		Conf:     serverConf,
-		channels: hashmap.New(),
+		channels: NewChannelMap(),
+		chStatis: gch.NewChStatis(),
 	}
 	b.Socket = *NewSocket(parent, chHandle, nil)
 	b.SetId("server#" + b.Conf.GetNetwork().String() + "#" + b.Conf.GetAddrStr())
 	return b
 }
 
+// Close 关闭监听并释放所有channel，重复调用时直接返回
 func (serverSocket *ServerSocket) Close() {
-	if !serverSocket.Closed {
-		defer func() {
-			ret := recover()
-			logx.InfoTracef(serverSocket, "finish to stop listen, ret:%v", ret)
-		}()
-		logx.InfoTracef(serverSocket, "start to stop listen.")
-		serverSocket.Closed = true
-		serverSocket.Exit <- true
-		acceptChannels := serverSocket.GetChannels().Values()
-		for _, ch := range acceptChannels {
-			ch.(gch.IChannel).Release()
+	if !serverSocket.tryClose() {
+		return
+	}
+	defer func() {
+		ret := recover()
+		// 取消上下文，channel已释放，下游调用随之结束
+		serverSocket.Cancel()
+		logx.InfoTracef(serverSocket, "finish to stop listen, ret:%v", ret)
+	}()
+	logx.InfoTracef(serverSocket, "start to stop listen.")
+	serverSocket.stopListen(nil)
+	for _, ch := range serverSocket.GetChannelMap().Values() {
+		ch.Release()
+	}
+	serverSocket.releaseReadPool()
+}
+
+// SetOnShutdown 设置优雅关闭时对每个channel的处理方法，如发送告别包
+func (serverSocket *ServerSocket) SetOnShutdown(onShutdown gch.ChHandleFunc) {
+	serverSocket.onShutdown = onShutdown
+}
+
+// Shutdown 优雅关闭：
+// 1.停止接收新的连接
+// 2.通知所有channel，执行onShutdown(如发送告别包)
+// 3.等待读协程池和待发送的消息处理完毕
+// 4.ws发送关闭帧并等待对端回复
+// 5.强制释放剩余的channel
+// 2-4步骤在ctx超时后直接跳到第5步，返回ctx的错误
+func (serverSocket *ServerSocket) Shutdown(ctx context.Context) error {
+	if !serverSocket.tryClose() {
+		return nil
+	}
+
+	logx.InfoTracef(serverSocket, "start to shutdown.")
+
+	// 停止接收，内部的http服务异步关闭，等待sse等未结束的请求
+	httpDone := serverSocket.stopListen(ctx)
+
+	channels := serverSocket.GetChannelMap().Values()
+	// 通知所有channel
+	onShutdown := serverSocket.onShutdown
+	if onShutdown != nil {
+		for _, ch := range channels {
+			chCtx := gch.NewChHandleContext(ch, nil)
+			func() {
+				defer func() {
+					rec := recover()
+					if rec != nil {
+						logx.WarnTracef(chCtx, "onShutdown error:%v", rec)
+					}
+				}()
+				onShutdown(chCtx)
+			}()
+		}
+	}
+
+	// 等待处理完毕
+	err := waitDrained(ctx, channels)
+	if err == nil {
+		err = closeWsChannels(ctx, channels)
+	}
+
+	// 强制释放剩余的channel
+	for _, ch := range serverSocket.GetChannelMap().Values() {
+		ch.Release()
+	}
+	serverSocket.Cancel()
+
+	if httpDone != nil {
+		select {
+		case <-httpDone:
+		case <-ctx.Done():
+			serverSocket.innerHttpServer.Close()
+		}
+	}
+	serverSocket.releaseReadPool()
+	logx.InfoTracef(serverSocket, "finish to shutdown, err:%v", err)
+	return err
+}
+
+// stopListen 停止监听，内部创建的http服务：
+// ctx为nil时直接关闭，否则异步优雅关闭，返回关闭完成的通知
+func (serverSocket *ServerSocket) stopListen(ctx context.Context) chan struct{} {
+	listener := serverSocket.listener
+	if listener != nil {
+		serverSocket.listener = nil
+		err := listener.Close()
+		if err != nil {
+			logx.WarnTracef(serverSocket, "close listener error:%v", err)
+		}
+	}
+
+	httpServer := serverSocket.innerHttpServer
+	if httpServer == nil {
+		return nil
+	}
+	if ctx == nil {
+		serverSocket.innerHttpServer = nil
+		httpServer.Close()
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := httpServer.Shutdown(ctx)
+		if err != nil {
+			logx.WarnTracef(serverSocket, "shutdown http server error:%v", err)
+		}
+	}()
+	return done
+}
+
+// waitDrained 等待所有channel的读写处理完毕或者ctx超时，期间发送未处理完的channel已缓存的批量包
+func waitDrained(ctx context.Context, channels []gch.IChannel) error {
+	ticker := time.NewTicker(SHUTDOWN_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		drained := true
+		for _, ch := range channels {
+			if !ch.IsClosed() && !ch.IsDrained() {
+				drained = false
+				// 发送已缓存的批量包，失败时按写入异常策略处理
+				ch.Flush()
+			}
+		}
+		if drained {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeWsChannels ws发送关闭帧(CloseGoingAway)，并发等待对端回复或者ctx超时
+func closeWsChannels(ctx context.Context, channels []gch.IChannel) error {
+	wg := sync.WaitGroup{}
+	for _, ch := range channels {
+		wsCh, ok := ch.(*tcpx.WsChannel)
+		if !ok || wsCh.IsClosed() {
+			continue
 		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wsCh.Close(websocket.CloseGoingAway, "server shutdown")
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acceptRetry 接收出错时是否继续，关闭后或者非临时错误时不再继续
+func acceptRetry(ss *ServerSocket, network string, err error) bool {
+	if ss.IsClosed() {
+		logx.InfoTracef(ss, "stop accept %v by close.", network)
+		return false
+	}
+	netErr, ok := err.(net.Error)
+	if ok && netErr.Temporary() {
+		logx.WarnTracef(ss, "accept %v temporary error:%v", network, err)
+		time.Sleep(ACCEPT_RETRY_DELAY)
+		return true
 	}
+	logx.ErrorTracef(ss, "accept %v error:%v", network, err)
+	return false
 }
 
 func (serverSocket *ServerSocket) GetChannels() *hashmap.Map {
+	return serverSocket.channels.channels
+}
+
+func (serverSocket *ServerSocket) GetChannelMap() *ChannelMap {
 	return serverSocket.channels
 }
 
+// GetChStatis 获取所有channel汇总的统计，channel创建时通过父节点汇总
+func (serverSocket *ServerSocket) GetChStatis() *gch.ChannelStatis {
+	return serverSocket.chStatis
+}
+
 func (serverSocket *ServerSocket) GetConf() IServerConf {
 	return serverSocket.Conf
 }
@@ -110,7 +318,7 @@ func (serverSocket *ServerSocket) GetBasePath() string {
 	return serverSocket.basePath
 }
 
-// ConverOnInActiveHandle 转化OnStopHandle方法
+// ConverOnInActiveHandle 转化OnStopHandle方法，channels非并发安全，兼容保留
 func ConverOnInActiveHandler(channels *hashmap.Map, onInActiveHandler gch.ChHandleFunc) func(ctx gch.IChHandleContext) {
 	return func(ctx gch.IChHandleContext) {
 		// 释放现有资源
@@ -123,8 +331,35 @@ func ConverOnInActiveHandler(channels *hashmap.Map, onInActiveHandler gch.ChHand
 	}
 }
 
-// Listen 监听方法，可自定义实现
+// converOnReleaseHandler 转化OnRelease方法，释放时从并发安全的channel集合中移除
+func converOnReleaseHandler(channels *ChannelMap, onReleaseHandler gch.ChHandleFunc) func(ctx gch.IChHandleContext) {
+	return func(ctx gch.IChHandleContext) {
+		// 释放现有资源
+		chId := ctx.GetChannel().GetId()
+		channels.Remove(chId)
+		logx.InfoTracef(ctx, "remove serverchannel, channelSize:%v", channels.Size())
+		if onReleaseHandler != nil {
+			onReleaseHandler(ctx)
+		}
+	}
+}
+
+// Listen 监听方法，可自定义实现，配置了读协程池时先创建独享的读协程池
 func (serverSocket *ServerSocket) Listen() error {
+	serverSocket.reopen()
+	err := serverSocket.initReadPool(serverSocket.GetConf().GetReadPoolConf())
+	if err != nil {
+		logx.ErrorTracef(serverSocket, "init readPool error:%v", err)
+		return err
+	}
+	err = serverSocket.listen()
+	if err != nil {
+		serverSocket.releaseReadPool()
+	}
+	return err
+}
+
+func (serverSocket *ServerSocket) listen() error {
 	network := serverSocket.GetConf().GetNetwork()
 	switch network {
 	case gch.NETWORK_WS:
@@ -142,7 +377,6 @@ func (serverSocket *ServerSocket) Listen() error {
 		logx.InfoTracef(serverSocket, "unsupport network:%v", network)
 		return nil
 	}
-	return errors.New("start serverSocket error, id:" + serverSocket.GetId())
 }
 
 const KEY_HTTP_REQUEST = "http-request"
@@ -171,43 +405,43 @@ func listenWs(ss *ServerSocket) error {
 	wsServerConf := ss.GetConf().(IWsServerConf)
 	// ws依赖http做升级而来
 	upgrader := websocket.Upgrader{
-		HandshakeTimeout: wsServerConf.GetReadTimeout() * time.Second,
-		ReadBufferSize:   wsServerConf.GetReadBufSize(),
-		WriteBufferSize:  wsServerConf.GetWriteBufSize(),
+		HandshakeTimeout:  wsServerConf.GetReadTimeout() * time.Second,
+		ReadBufferSize:    wsServerConf.GetReadBufSize(),
+		WriteBufferSize:   wsServerConf.GetWriteBufSize(),
+		EnableCompression: wsServerConf.IsCompressEnable(),
 	}
 
+	// sse和长轮询的会话，sid->*httpx.HttpChannel
+	sessions := &sync.Map{}
 	addrStr := wsServerConf.GetAddrStr()
 	httpServer := ss.GetHttpServer()
 	if httpServer == nil {
 		// 为空时，根据serverConf的ip/port进行创建监听
+		writeTimeout := wsServerConf.GetWriteTimeout() * time.Second
+		if hasNetworkChild(wsServerConf, gch.NETWORK_SSE) {
+			// sse为长连接持续推送，不能设置写超时
+			writeTimeout = 0
+		}
+		// 每个服务独立的mux，避免多个服务之间相互影响
+		mux := http.NewServeMux()
 		httpServer = &http.Server{
 			Addr:              addrStr,
+			Handler:           mux,
 			TLSConfig:         nil,
 			ReadTimeout:       wsServerConf.GetReadTimeout() * time.Second,
 			ReadHeaderTimeout: wsServerConf.GetReadTimeout() * time.Second,
-			WriteTimeout:      wsServerConf.GetWriteTimeout() * time.Second,
+			WriteTimeout:      writeTimeout,
 			IdleTimeout:       wsServerConf.GetReadTimeout() * time.Second * 3,
 			MaxHeaderBytes:    1 << 20,
 		}
 		// 启动监听
+		ss.innerHttpServer = httpServer
 		go func() {
 			// 异步监听http和ws
 			logx.InfoTracef(ss, "listenAnServe addrStr:%v", addrStr)
 			err := httpServer.ListenAndServe()
-			if err != nil {
+			if err != nil && err != http.ErrServerClosed {
 				logx.ErrorTracef(ss, "listenAnServe error:%v", err)
-				panic(err)
-			}
-		}()
-
-		// 等待关闭操作
-		go func() {
-			s := make(chan os.Signal, 1)
-			signal.Notify(s, os.Kill, os.Interrupt, syscall.SIGABRT, syscall.SIGTERM)
-			select {
-			case sg := <-s:
-				httpServer.Close()
-				logx.InfoTracef(ss, "listenAnServe close, signal:%v", sg)
 			}
 		}()
 
@@ -215,40 +449,64 @@ func listenWs(ss *ServerSocket) error {
 		wsChildren := wsServerConf.GetListenConfs()
 		if wsChildren != nil {
 			for _, child := range wsChildren {
-				network := child.GetNetwork()
-				if len(network) <= 0 {
-					// 子配置没有配置network，则取父节点
-					network = wsServerConf.GetNetwork()
-				}
-				if network == gch.NETWORK_WS {
+				child := child
+				network := getChildNetwork(wsServerConf, child)
+				switch network {
+				case gch.NETWORK_WS:
 					// ws处理事件，针对不同的basePath进行处理
-					http.HandleFunc(child.GetBasePath(), func(writer http.ResponseWriter, req *http.Request) {
+					mux.HandleFunc(child.GetBasePath(), func(writer http.ResponseWriter, req *http.Request) {
 						logx.InfoTracef(ss, "requestWs:%v", req.URL)
 						err := upgradeWs(ss, writer, req, upgrader, child)
 						if err != nil {
 							logx.ErrorTracef(ss, "start ws error:%v", err)
 						}
 					})
+				case gch.NETWORK_SSE, gch.NETWORK_POLL:
+					// sse和长轮询，针对不同的basePath进行处理
+					mux.HandleFunc(child.GetBasePath(), func(writer http.ResponseWriter, req *http.Request) {
+						logx.InfoTracef(ss, "request %v:%v", network, req.URL)
+						serveHttpSession(ss, sessions, writer, req, network)
+					})
 				}
 			}
 		}
 	} else {
 		// 已在外面完成的监听，重写httphandler处理事件，以便通过不同的path分别处理http和ws
 		httpHandler := httpServer.Handler
-		httpServer.Handler = newProxyHandler(httpHandler, upgrader, ss)
+		httpServer.Handler = newProxyHandler(httpHandler, upgrader, ss, sessions)
 	}
-	ss.Closed = false
+	ss.SetClosed(false)
 	return nil
 }
 
-func newProxyHandler(handler http.Handler, upgrader websocket.Upgrader, serverListener IServerSocket) *proxyHandler {
-	return &proxyHandler{handler: handler, upgrader: upgrader, serverSocket: serverListener}
+// getChildNetwork 获取子配置的network，子配置没有配置network，则取父节点
+func getChildNetwork(conf IServerConf, child IServerChildConf) gch.Network {
+	network := child.GetNetwork()
+	if len(network) <= 0 {
+		network = conf.GetNetwork()
+	}
+	return network
+}
+
+// hasNetworkChild 是否有某种network的子配置
+func hasNetworkChild(conf IServerConf, network gch.Network) bool {
+	for _, child := range conf.GetListenConfs() {
+		if getChildNetwork(conf, child) == network {
+			return true
+		}
+	}
+	return false
+}
+
+func newProxyHandler(handler http.Handler, upgrader websocket.Upgrader, serverListener IServerSocket, sessions *sync.Map) *proxyHandler {
+	return &proxyHandler{handler: handler, upgrader: upgrader, serverSocket: serverListener, sessions: sessions}
 }
 
 type proxyHandler struct {
 	handler      http.Handler
 	upgrader     websocket.Upgrader
 	serverSocket IServerSocket
+	sessions     *sync.Map
 }
 
 // ServeHTTP 通过不同的path分别处理http和ws
@@ -262,11 +520,17 @@ func (proxy *proxyHandler) ServeHTTP(writer http.ResponseWriter, req *http.Reque
 	if wsChildren != nil {
 		for _, child := range wsChildren {
 			path := child.GetBasePath()
-			// 优先处理ws的handler
+			// 优先处理ws，sse和长轮询的handler
 			if strings.Contains(uri, path) {
-				err := upgradeWs(serverSocket, writer, req, proxy.upgrader, child)
-				if err != nil {
-					logx.ErrorTracef(serverSocket, "start ws error:%v", err)
+				network := getChildNetwork(conf, child)
+				switch network {
+				case gch.NETWORK_SSE, gch.NETWORK_POLL:
+					serveHttpSession(serverSocket, proxy.sessions, writer, req, network)
+				default:
+					err := upgradeWs(serverSocket, writer, req, proxy.upgrader, child)
+					if err != nil {
+						logx.ErrorTracef(serverSocket, "start ws error:%v", err)
+					}
 				}
 				return
 			}
@@ -279,7 +543,7 @@ func (proxy *proxyHandler) ServeHTTP(writer http.ResponseWriter, req *http.Reque
 
 // listenWs 启动ws处理
 func upgradeWs(ss IServerSocket, writer http.ResponseWriter, req *http.Request, upgr websocket.Upgrader, childConf IServerChildConf) error {
-	acceptChannels := ss.GetChannels()
+	acceptChannels := ss.GetChannelMap()
 	serverConf := ss.GetConf().(IWsServerConf)
 	connLen := acceptChannels.Size()
 	maxAcceptSize := serverConf.GetMaxChannelSize()
@@ -324,20 +588,111 @@ func upgradeWs(ss IServerSocket, writer http.ResponseWriter, req *http.Request,
 	}
 	addHttpRequest(ss, req)
 
-	chHandle := ss.GetChHandle().(*gch.ChHandle)
+	// 复制一份handle，避免相互覆盖
+	chHandle := gch.CopyChHandle(ss.GetChHandle())
 	// OnInActiveHandle重新包装，以便释放资源
-	chHandle.SetOnRelease(ConverOnInActiveHandler(acceptChannels, chHandle.GetOnRelease()))
+	chHandle.SetOnRelease(converOnReleaseHandler(acceptChannels, chHandle.GetOnRelease()))
 	wsCh := tcpx.NewWsChannel(ss, conn, serverConf, chHandle, params, true)
 	// 设置为请求过来的path
 	wsCh.SetRelativePath(req.URL.Path)
+	// 提取握手header中的traceparent，连接的span为其子span
+	wsCh.SetContext(tracex.Extract(wsCh.GetContext(), tracex.HeaderCarrier(req.Header)))
+	// 先放入再打开，打开后随即释放时也能正确移除
+	acceptChannels.Put(wsCh.GetId(), wsCh)
 	err = wsCh.Open()
-	if err == nil {
-		// TODO 线程安全？
-		acceptChannels.Put(wsCh.GetId(), wsCh)
+	if err != nil {
+		acceptChannels.Remove(wsCh.GetId())
 	}
 	return err
 }
 
+// serveHttpSession 处理sse和长轮询的请求，通过请求参数sid关联会话：
+// GET 无sid时创建会话，sse方式持续推送消息，长轮询方式等待返回消息
+// POST 上行一条消息，消息体为消息内容
+// DELETE 关闭会话
+func serveHttpSession(ss IServerSocket, sessions *sync.Map, writer http.ResponseWriter, req *http.Request, network gch.Network) {
+	var httpCh *httpx.HttpChannel
+	sid := req.URL.Query().Get(httpx.KEY_SID)
+	if len(sid) > 0 {
+		val, found := sessions.Load(sid)
+		if !found {
+			http.Error(writer, "session not found, sid:"+sid, http.StatusNotFound)
+			return
+		}
+		httpCh = val.(*httpx.HttpChannel)
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		// 等待时间需小于http的写超时
+		waitTime := ss.GetConf().GetWriteTimeout() * time.Second * 2 / 3
+		if httpCh == nil {
+			var err error
+			httpCh, err = newHttpSession(ss, sessions, req, network)
+			if err != nil {
+				logx.ErrorTracef(ss, "start %v session error:%v", network, err)
+				http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			// 新建的会话立即返回sid
+			waitTime = 0
+		}
+		if network == gch.NETWORK_SSE {
+			httpCh.ServeSse(writer, req)
+		} else {
+			httpCh.ServePoll(writer, req, waitTime)
+		}
+	case http.MethodPost:
+		if httpCh == nil {
+			http.Error(writer, "sid is required.", http.StatusBadRequest)
+			return
+		}
+		httpCh.ReceiveRequest(writer, req)
+	case http.MethodDelete:
+		if httpCh == nil {
+			http.Error(writer, "sid is required.", http.StatusBadRequest)
+			return
+		}
+		httpCh.Release()
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// newHttpSession 创建sse或者长轮询的会话channel
+func newHttpSession(ss IServerSocket, sessions *sync.Map, req *http.Request, network gch.Network) (*httpx.HttpChannel, error) {
+	acceptChannels := ss.GetChannelMap()
+	serverConf := ss.GetConf()
+	connLen := acceptChannels.Size()
+	maxAcceptSize := serverConf.GetMaxChannelSize()
+	if maxAcceptSize > 0 && connLen >= maxAcceptSize {
+		return nil, errors.New("max accept size:" + fmt.Sprintf("%v", maxAcceptSize))
+	}
+	addHttpRequest(ss, req)
+
+	sid := httpx.NewSessionId()
+	// 复制一份handle，释放时同时移除会话
+	chHandle := gch.CopyChHandle(ss.GetChHandle())
+	onRelease := converOnReleaseHandler(acceptChannels, chHandle.GetOnRelease())
+	chHandle.SetOnRelease(func(ctx gch.IChHandleContext) {
+		sessions.Delete(sid)
+		onRelease(ctx)
+	})
+	httpCh := httpx.NewHttpChannel(ss, network, sid, serverConf, chHandle, req)
+	httpCh.SetRelativePath(req.URL.Path)
+	sessions.Store(sid, httpCh)
+	acceptChannels.Put(httpCh.GetId(), httpCh)
+	err := httpCh.Open()
+	if err != nil {
+		sessions.Delete(sid)
+		acceptChannels.Remove(httpCh.GetId())
+		return nil, err
+	}
+	logx.InfoTracef(ss, "start %v session, sid:%v", network, sid)
+	return httpCh, nil
+}
+
 func addHttpRequest(serverListener IServerSocket, req *http.Request) {
 	serverListener.AddAttach(KEY_HTTP_REQUEST, req)
 }
@@ -366,31 +721,35 @@ func listenKcp(ss *ServerSocket) error {
 		}
 	}()
 
-	kcpChannels := ss.GetChannels()
+	ss.listener = listKcp
+	kcpChannels := ss.GetChannelMap()
 	go func() {
 		for {
 			kcpConn, err := listKcp.AcceptKCP()
 			if err != nil {
-				logx.ErrorTracef(ss, "accept kcp error:%v", err)
+				if acceptRetry(ss, "kcp", err) {
+					continue
+				}
 				listKcp.Close()
-				panic(err)
+				return
 			}
 
 			schHandle := ss.GetChHandle().(*gch.ChHandle)
 			// 复制一份handle，避免相互覆盖
 			chHandle := gch.CopyChHandle(schHandle)
 			// OnInActiveHandle重新包装，以便释放资源
-			chHandle.SetOnRelease(ConverOnInActiveHandler(kcpChannels, chHandle.GetOnRelease()))
+			chHandle.SetOnRelease(converOnReleaseHandler(kcpChannels, chHandle.GetOnRelease()))
 			kcpCh := kcpx.NewKcpChannel(ss, kcpConn, kcpServerConf, chHandle, true)
+			kcpChannels.Put(kcpCh.GetId(), kcpCh)
 			err = kcpCh.Open()
-			if err == nil {
-				kcpChannels.Put(kcpCh.GetId(), kcpCh)
+			if err != nil {
+				kcpChannels.Remove(kcpCh.GetId())
 			}
 		}
 	}()
 
 	if err == nil {
-		ss.Closed = false
+		ss.SetClosed(false)
 	}
 	return err
 }
@@ -421,26 +780,34 @@ func listenTcp(ss *ServerSocket) error {
 		}
 	}()
 
-	channels := ss.GetChannels()
+	ss.listener = listenTCP
+	channels := ss.GetChannelMap()
 	go func() {
 		for {
 			tcpConn, err := listenTCP.AcceptTCP()
 			if err != nil {
-				logx.ErrorTracef(ss, "accept tcp error:%v", err)
+				if acceptRetry(ss, "tcp", err) {
+					continue
+				}
 				listenTCP.Close()
-				panic(err)
+				return
 			}
-			chHandle := ss.GetChHandle().(*gch.ChHandle)
+			// 复制一份handle，避免相互覆盖
+			chHandle := gch.CopyChHandle(ss.GetChHandle())
 			// OnInActiveHandle重新包装，以便释放资源
-			chHandle.SetOnRelease(ConverOnInActiveHandler(channels, chHandle.GetOnRelease()))
+			chHandle.SetOnRelease(converOnReleaseHandler(channels, chHandle.GetOnRelease()))
 			tcpCh := tcpx.NewTcpChannel(ss, tcpConn, serverConf, chHandle, true)
+			channels.Put(tcpCh.GetId(), tcpCh)
 			err = tcpCh.Open()
-			if err == nil {
-				channels.Put(tcpCh.GetId(), tcpCh)
+			if err != nil {
+				channels.Remove(tcpCh.GetId())
 			}
 		}
 	}()
 
+	if err == nil {
+		ss.SetClosed(false)
+	}
 	return err
 }
 
@@ -475,7 +842,11 @@ func listenUdp(ss *ServerSocket) error {
 		readBufSize = udpx.Max_UDP_Buf
 	}
 	readbf := make([]byte, readBufSize)
-	channels := ss.GetChannels()
+	ss.listener = udpConn
+	channels := ss.GetChannelMap()
+	// 远端地址对应的channel，channels以channel的id为key，无法直接通过地址查找
+	addrChannels := &sync.Map{}
+	schHandle := ss.GetChHandle().(*gch.ChHandle)
 	go func() {
 		for {
 			// TODO 是否有性能问题？
@@ -484,26 +855,38 @@ func listenUdp(ss *ServerSocket) error {
 				continue
 			}
 			if err != nil {
-				logx.ErrorTracef(ss, "read udp error:%v", err)
+				if acceptRetry(ss, "udp", err) {
+					continue
+				}
 				udpConn.Close()
-				panic(err)
+				return
 			}
 
 			var udpCh *udpx.UdpChannel
-			buf := readbf[0:readNum]
+			// readbf复用，每个数据包从缓冲池复制
+			buf := gch.CopyBuf(readbf[0:readNum])
 			udpChId := udpx.FetchUdpId(udpConn, addr)
-			channel, found := channels.Get(udpChId)
+			channel, found := addrChannels.Load(udpChId)
 			if !found {
 				// 第一次生成一个channel
-				chHandle := ss.GetChHandle().(*gch.ChHandle)
+				chHandle := gch.CopyChHandle(schHandle)
 				// OnInActiveHandle重新包装，以便释放资源
-				chHandle.SetOnRelease(ConverOnInActiveHandler(channels, chHandle.GetOnRelease()))
+				onRelease := converOnReleaseHandler(channels, chHandle.GetOnRelease())
+				chHandle.SetOnRelease(func(ctx gch.IChHandleContext) {
+					addrChannels.Delete(udpChId)
+					onRelease(ctx)
+				})
 				udpCh = udpx.NewUdpChannel(ss, udpConn, serverConf, chHandle, addr, true)
+				addrChannels.Store(udpChId, udpCh)
+				channels.Put(udpCh.GetId(), udpCh)
 				err = udpCh.Open()
-				if err == nil {
-					channels.Put(udpCh.GetId(), udpCh)
+				if err != nil {
+					logx.WarnTracef(ss, "open udp channel error:%v", err)
+					addrChannels.Delete(udpChId)
+					channels.Remove(udpCh.GetId())
+					buf.Release()
+					udpCh = nil
 				}
-				udpCh.CacheServerRead(buf)
 			} else {
 				// 已存在直接缓存
 				udpCh = channel.(*udpx.UdpChannel)
@@ -517,7 +900,7 @@ func listenUdp(ss *ServerSocket) error {
 	}()
 
 	if err == nil {
-		ss.Closed = false
+		ss.SetClosed(false)
 	}
 	return err
 }
@@ -3,3 +3,189 @@
  * DATE:2021/1/1
  */
 package socket
+
+import (
+	"context"
+	"github.com/slive/gsfly/channel"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerOwnReadPool(t *testing.T) {
+	serverConf := NewTcpServerConf("127.0.0.1", 19102)
+	handle := channel.NewDefChHandle(func(ctx channel.IChHandleContext) {})
+
+	// 工作协程数超过上限，校验不通过
+	serverConf.SetReadPoolConf(channel.NewReadPoolConf(channel.MAX_READ_POOL_LIMIT+1, channel.MAX_READ_QUEUE_SIZE))
+	serverSocket := NewServerSocket(nil, serverConf, handle)
+	if serverSocket.Listen() == nil {
+		t.Fatal("listen should fail with invalid readPoolConf.")
+	}
+	if serverSocket.GetReadPool() != nil {
+		t.Fatal("readPool should not be created.")
+	}
+
+	serverConf.SetReadPoolConf(channel.NewReadPoolConf(2, channel.MAX_READ_QUEUE_SIZE))
+	serverSocket = NewServerSocket(nil, serverConf, handle)
+	err := serverSocket.Listen()
+	if err != nil {
+		t.Fatalf("listen error:%v", err)
+	}
+	if serverSocket.GetReadPool() == nil {
+		t.Fatal("readPool should be created by conf.")
+	}
+	serverSocket.Close()
+	if serverSocket.GetReadPool() != nil {
+		t.Fatal("own readPool should be released after close.")
+	}
+}
+
+func TestServerConcurrentOpenWriteClose(t *testing.T) {
+	serverConf := NewTcpServerConf("127.0.0.1", 19103)
+	serverSocket := NewServerSocket(nil, serverConf, channel.NewDefChHandle(func(ctx channel.IChHandleContext) {
+		ch := ctx.GetChannel()
+		packet := ch.NewPacket()
+		packet.SetData(ctx.GetPacket().GetData())
+		ch.Write(packet)
+	}))
+	err := serverSocket.Listen()
+	if err != nil {
+		t.Fatalf("listen error:%v", err)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clientConf := NewTcpClientConf("127.0.0.1", 19103)
+			clientSocket := NewClientSocket(nil, clientConf, channel.NewDefChHandle(func(ctx channel.IChHandleContext) {}), nil)
+			if clientSocket.Dial() != nil {
+				return
+			}
+			clientCh := clientSocket.GetChannel()
+			writeWg := sync.WaitGroup{}
+			for j := 0; j < 4; j++ {
+				writeWg.Add(1)
+				go func() {
+					defer writeWg.Done()
+					for k := 0; k < 20; k++ {
+						packet := clientCh.NewPacket()
+						packet.SetData([]byte("hello"))
+						clientCh.Write(packet)
+					}
+				}()
+			}
+			// 写的同时重复关闭
+			for j := 0; j < 2; j++ {
+				writeWg.Add(1)
+				go func() {
+					defer writeWg.Done()
+					clientSocket.Close()
+				}()
+			}
+			writeWg.Wait()
+			if clientCh.GetState() != channel.CH_STATE_CLOSED {
+				t.Errorf("unexpected client state:%v", clientCh.GetState())
+			}
+		}()
+	}
+	wg.Wait()
+
+	go serverSocket.Close()
+	serverSocket.Close()
+	<-serverSocket.Exit
+	time.Sleep(time.Millisecond * 100)
+	if serverSocket.GetChannelMap().Size() != 0 {
+		t.Fatalf("channels should be released, size:%v", serverSocket.GetChannelMap().Size())
+	}
+}
+
+func TestServerShutdownBatch(t *testing.T) {
+	serverChan := make(chan channel.IChannel, 1)
+	serverConf := NewTcpServerConf("127.0.0.1", 19107)
+	serverSocket := NewServerSocket(nil, serverConf, channel.NewDefChHandle(func(ctx channel.IChHandleContext) {
+		// 只缓存批量包，不主动Flush
+		ch := ctx.GetChannel()
+		packet := ch.NewPacket()
+		packet.SetData(append([]byte(nil), ctx.GetPacket().GetData()...))
+		ch.WriteBatch(packet)
+		serverChan <- ch
+	}))
+	if err := serverSocket.Listen(); err != nil {
+		t.Fatalf("listen error:%v", err)
+	}
+	defer serverSocket.Close()
+
+	revChan := make(chan string, 1)
+	clientSocket := NewClientSocket(nil, NewTcpClientConf("127.0.0.1", 19107), channel.NewDefChHandle(func(ctx channel.IChHandleContext) {
+		revChan <- string(ctx.GetPacket().GetData())
+	}), nil)
+	if err := clientSocket.Dial(); err != nil {
+		t.Fatalf("dial error:%v", err)
+	}
+	defer clientSocket.Close()
+	packet := clientSocket.GetChannel().NewPacket()
+	packet.SetData([]byte("hello"))
+	clientSocket.GetChannel().Write(packet)
+
+	select {
+	case serverCh := <-serverChan:
+		// 读取的包已处理完，但还有未发送的批量包
+		time.Sleep(50 * time.Millisecond)
+		if serverCh.IsDrained() {
+			t.Fatal("channel with batched packets should not be drained.")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait server handle timeout.")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := serverSocket.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown error:%v", err)
+	}
+	select {
+	case msg := <-revChan:
+		if msg != "hello" {
+			t.Fatalf("unexpected msg:%v", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("batched packet should be sent before shutdown.")
+	}
+}
+
+func TestServerRelisten(t *testing.T) {
+	serverConf := NewTcpServerConf("127.0.0.1", 19106)
+	serverSocket := NewServerSocket(nil, serverConf, channel.NewDefChHandle(func(ctx channel.IChHandleContext) {}))
+	for i := 0; i < 2; i++ {
+		if err := serverSocket.Listen(); err != nil {
+			t.Fatalf("listen error:%v", err)
+		}
+		if serverSocket.IsClosed() || serverSocket.Closed {
+			t.Fatal("socket should be open after listen.")
+		}
+		// 重新监听后上下文重新创建，trace保留
+		if err := serverSocket.GetContext().Err(); err != nil || serverSocket.GetTrace() != serverSocket.GetId() {
+			t.Fatalf("context should be renewed after listen, err:%v, trace:%v", err, serverSocket.GetTrace())
+		}
+		// 重新监听后Exit重新创建，关闭前不会收到通知
+		select {
+		case <-serverSocket.Exit:
+			t.Fatal("exit should not be closed before close.")
+		default:
+		}
+		if serverSocket.GetChannels() != serverSocket.GetChannelMap().channels {
+			t.Fatal("GetChannels should return the underlying map.")
+		}
+		serverSocket.Close()
+		select {
+		case <-serverSocket.Exit:
+		case <-time.After(time.Second):
+			t.Fatal("exit should be closed after close.")
+		}
+		if serverSocket.GetContext().Err() != context.Canceled {
+			t.Fatal("context should be canceled after close.")
+		}
+	}
+}
@@ -8,6 +8,7 @@ package socket
 import (
 	gch "github.com/slive/gsfly/channel"
 	cmm "github.com/slive/gsfly/common"
+	"sync"
 )
 
 // ISocket socket接口
@@ -27,6 +28,12 @@ type ISocket interface {
 	// GetInputParams 建立socketconn所需的参数
 	GetInputParams() map[string]interface{}
 
+	// GetReadPool 获取读协程池，未设置时取父节点的，可为nil(使用默认的)
+	GetReadPool() *gch.ReadPool
+
+	// SetReadPool 设置读协程池
+	SetReadPool(readPool *gch.ReadPool)
+
 	cmm.IRunContext
 }
 
@@ -36,10 +43,18 @@ type Socket struct {
 	cmm.Parent
 	cmm.Attact
 	cmm.Id
+	// Closed 是否已关闭，监听、拨号和关闭的协程并发访问，读取请使用IsClosed
 	Closed        bool
 	channelHandle gch.IChHandle
-	Exit          chan bool
-	params        map[string]interface{}
+	// Exit 关闭时close，可用于等待socket关闭，重新监听或者拨号时重新创建
+	Exit   chan bool
+	exited bool
+	// 保护Closed、Exit以及readPool和ownReadPool，接收、拨号和关闭的协程并发访问
+	closeMut sync.Mutex
+	params   map[string]interface{}
+	readPool *gch.ReadPool
+	// 是否为根据配置创建的独享读协程池，关闭socket时一并关闭
+	ownReadPool bool
 
 	cmm.RunContext
 }
@@ -56,7 +71,8 @@ func NewSocket(parent interface{}, chHandle gch.IChHandle, inputParams map[strin
 	b.Parent = *cmm.NewParent(parent)
 	b.Attact = *cmm.NewAttact()
 	b.params = inputParams
-	b.RunContext = *cmm.NewRunContextByParent(parent)
+	// 关闭时取消，由socket创建的channel的上下文随之取消
+	b.RunContext = *cmm.NewCancelRunContextByParent(parent)
 	return b
 }
 
@@ -66,9 +82,44 @@ func (socket *Socket) SetId(id string) {
 }
 
 func (socket *Socket) IsClosed() bool {
+	socket.closeMut.Lock()
+	defer socket.closeMut.Unlock()
 	return socket.Closed
 }
 
+// SetClosed 设置是否已关闭，监听或者拨号成功后设置为false
+func (socket *Socket) SetClosed(closed bool) {
+	socket.closeMut.Lock()
+	defer socket.closeMut.Unlock()
+	socket.Closed = closed
+}
+
+// tryClose 由打开变更为关闭，并通知Exit，只有一个调用者返回true
+func (socket *Socket) tryClose() bool {
+	socket.closeMut.Lock()
+	defer socket.closeMut.Unlock()
+	if socket.Closed {
+		return false
+	}
+	socket.Closed = true
+	if !socket.exited {
+		socket.exited = true
+		close(socket.Exit)
+	}
+	return true
+}
+
+// reopen 关闭后重新监听或者拨号前调用，重新创建已关闭的Exit和已取消的上下文
+func (socket *Socket) reopen() {
+	socket.closeMut.Lock()
+	defer socket.closeMut.Unlock()
+	if socket.Closed && socket.exited {
+		socket.Exit = make(chan bool, 1)
+		socket.exited = false
+		socket.Renew(socket.GetParent())
+	}
+}
+
 func (socket *Socket) GetChHandle() gch.IChHandle {
 	return socket.channelHandle
 }
@@ -77,3 +128,55 @@ func (socket *Socket) GetChHandle() gch.IChHandle {
 func (socket *Socket) GetInputParams() map[string]interface{} {
 	return socket.params
 }
+
+// GetReadPool 获取读协程池，未设置时取父节点的，可为nil(使用默认的)
+func (socket *Socket) GetReadPool() *gch.ReadPool {
+	socket.closeMut.Lock()
+	readPool := socket.readPool
+	socket.closeMut.Unlock()
+	if readPool != nil {
+		return readPool
+	}
+	holder, ok := socket.GetParent().(gch.IReadPoolHolder)
+	if ok {
+		return holder.GetReadPool()
+	}
+	return nil
+}
+
+// SetReadPool 设置读协程池
+func (socket *Socket) SetReadPool(readPool *gch.ReadPool) {
+	socket.closeMut.Lock()
+	defer socket.closeMut.Unlock()
+	socket.readPool = readPool
+}
+
+// initReadPool 按配置创建独享的读协程池，配置为nil或者已设置读协程池时不创建
+func (socket *Socket) initReadPool(readPoolConf *gch.ReadPoolConf) error {
+	socket.closeMut.Lock()
+	defer socket.closeMut.Unlock()
+	if readPoolConf == nil || socket.readPool != nil {
+		return nil
+	}
+	readPool, err := gch.NewReadPoolByConf(readPoolConf)
+	if err != nil {
+		return err
+	}
+	socket.readPool = readPool
+	socket.ownReadPool = true
+	return nil
+}
+
+// releaseReadPool 关闭独享的读协程池，非独享的不处理
+func (socket *Socket) releaseReadPool() {
+	socket.closeMut.Lock()
+	readPool := socket.readPool
+	if !socket.ownReadPool || readPool == nil {
+		socket.closeMut.Unlock()
+		return
+	}
+	socket.readPool = nil
+	socket.ownReadPool = false
+	socket.closeMut.Unlock()
+	readPool.Close()
+}
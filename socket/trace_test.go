@@ -0,0 +1,64 @@
+/*
+ * Author:slive
+ * DATE:2020/9/25
+ */
+package socket
+
+import (
+	"github.com/slive/gsfly/channel"
+	"github.com/slive/gsfly/tracex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWsTraceparent(t *testing.T) {
+	exporter := tracex.NewMemoryExporter()
+	tracex.SetTracer(tracex.NewTracer(exporter))
+	defer tracex.SetTracer(nil)
+
+	childConf := NewServerChildConf(channel.NETWORK_WS, "/trace")
+	serverConf := NewWsServerConf("127.0.0.1", 19105, "ws", childConf)
+	serverSocket := NewServerSocket(nil, serverConf, channel.NewDefChHandle(func(ctx channel.IChHandleContext) {}))
+	err := serverSocket.Listen()
+	if err != nil {
+		t.Fatalf("listen error:%v", err)
+	}
+	defer serverSocket.Close()
+
+	// 客户端的上下文带有入口传递过来的traceparent
+	remote := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	clientConf := NewWsClientConf("127.0.0.1", 19105, "ws", "/trace")
+	clientSocket := NewClientSocket(nil, clientConf, channel.NewDefChHandle(func(ctx channel.IChHandleContext) {}), nil)
+	clientSocket.SetContext(tracex.ExtractTraceparent(clientSocket.GetContext(), remote))
+	// ws服务异步启动监听
+	for i := 0; i < 100; i++ {
+		err = clientSocket.Dial()
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("dial error:%v", err)
+	}
+	defer clientSocket.Close()
+
+	var serverConnect *tracex.SpanData
+	for i := 0; i < 100 && serverConnect == nil; i++ {
+		for _, span := range exporter.GetSpansByName(tracex.Span_Connect) {
+			chId, _ := span.Attrs["chId"].(string)
+			if strings.HasPrefix(chId, "server#") {
+				serverConnect = span
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if serverConnect == nil {
+		t.Fatal("wait server connect span timeout.")
+	}
+	if serverConnect.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || serverConnect.ParentSpanId != "00f067aa0ba902b7" ||
+		!serverConnect.RemoteParent {
+		t.Fatalf("server connect span should be child of traceparent in ws header, span:%+v", serverConnect)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * W3C trace context的传递，格式为：version-traceId-spanId-flags，如：
+ * 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+ * Author:slive
+ * DATE:2020/9/25
+ */
+package tracex
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TRACEPARENT W3C trace context的header名，也作为包附件的key
+const TRACEPARENT = "traceparent"
+
+const (
+	traceparentVersion = "00"
+	flagSampled        = 0x01
+)
+
+// SpanContext span的上下文，可跨进程传递
+type SpanContext struct {
+	// TraceId 32位小写16进制
+	TraceId string
+	// SpanId 16位小写16进制
+	SpanId string
+	// Sampled 是否采样，未采样的span不导出，但仍传递
+	Sampled bool
+	// Remote 是否为从远端提取的
+	Remote bool
+}
+
+// IsValid traceId和spanId是否有效，全为0时无效
+func (sc SpanContext) IsValid() bool {
+	return isValidId(sc.TraceId, 32) && isValidId(sc.SpanId, 16)
+}
+
+// FormatTraceparent 格式化为traceparent，无效时返回空字符串
+func FormatTraceparent(sc SpanContext) string {
+	if !sc.IsValid() {
+		return ""
+	}
+	var flags byte
+	if sc.Sampled {
+		flags |= flagSampled
+	}
+	return fmt.Sprintf("%v-%v-%v-%02x", traceparentVersion, sc.TraceId, sc.SpanId, flags)
+}
+
+// ParseTraceparent 解析traceparent，兼容更高版本时多出的字段
+func ParseTraceparent(traceparent string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent:%v", traceparent)
+	}
+	version := parts[0]
+	if !isHex(version, 2) || version == "ff" || (version == traceparentVersion && len(parts) != 4) {
+		return SpanContext{}, fmt.Errorf("invalid traceparent version:%v", traceparent)
+	}
+	if !isHex(parts[3], 2) {
+		return SpanContext{}, fmt.Errorf("invalid traceparent flags:%v", traceparent)
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	sc := SpanContext{
+		TraceId: parts[1],
+		SpanId:  parts[2],
+		Sampled: flags[0]&flagSampled == flagSampled,
+		Remote:  true,
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, errors.New("invalid traceparent id:" + traceparent)
+	}
+	return sc, nil
+}
+
+// isHex 是否为指定长度的小写16进制
+func isHex(s string, size int) bool {
+	if len(s) != size {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isValidId(id string, size int) bool {
+	return isHex(id, size) && strings.Trim(id, "0") != ""
+}
+
+// ICarrier traceparent的载体，如http header，包的附件
+type ICarrier interface {
+	Get(key string) string
+
+	Set(key string, val string)
+}
+
+// HeaderCarrier http header的载体，如ws握手的header
+type HeaderCarrier http.Header
+
+func (c HeaderCarrier) Get(key string) string {
+	return http.Header(c).Get(key)
+}
+
+func (c HeaderCarrier) Set(key string, val string) {
+	http.Header(c).Set(key, val)
+}
+
+// MapCarrier map的载体，如自定义协议的包头
+type MapCarrier map[string]string
+
+func (c MapCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c MapCarrier) Set(key string, val string) {
+	c[key] = val
+}
+
+// Inject 将ctx中的span上下文注入载体，没有时不处理
+func Inject(ctx context.Context, carrier ICarrier) {
+	sc, ok := SpanContextFromContext(ctx)
+	if ok {
+		carrier.Set(TRACEPARENT, FormatTraceparent(sc))
+	}
+}
+
+// Extract 从载体中提取span上下文，返回带有该上下文的context，没有或者无效时返回原ctx
+func Extract(ctx context.Context, carrier ICarrier) context.Context {
+	return ExtractTraceparent(ctx, carrier.Get(TRACEPARENT))
+}
+
+// ExtractTraceparent 解析traceparent，返回带有该上下文的context，为空或者无效时返回原ctx
+func ExtractTraceparent(ctx context.Context, traceparent string) context.Context {
+	if len(traceparent) <= 0 {
+		return ctx
+	}
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
@@ -0,0 +1,192 @@
+/*
+ * 默认的追踪实现，结束的span交给IExporter导出
+ * Author:slive
+ * DATE:2020/9/25
+ */
+package tracex
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/slive/gsfly/common"
+	"sync"
+	"time"
+)
+
+// ctxAttrKeys 开始span时从上下文中复制为属性的值，如channel id
+var ctxAttrKeys = []string{common.Key_ChId, common.Key_Network, common.Key_RemoteAddr, common.Key_RelativePath}
+
+// SpanData 结束的span
+type SpanData struct {
+	Name         string
+	TraceId      string
+	SpanId       string
+	ParentSpanId string
+	// RemoteParent 父span是否为远端提取的
+	RemoteParent bool
+	StartTime    time.Time
+	EndTime      time.Time
+	Attrs        map[string]interface{}
+	Err          error
+}
+
+// GetDuration 获取耗时
+func (data *SpanData) GetDuration() time.Duration {
+	return data.EndTime.Sub(data.StartTime)
+}
+
+// IExporter span的导出
+type IExporter interface {
+	Export(data *SpanData)
+}
+
+// Tracer 默认的追踪实现，父span已采样或者新建trace时采样
+type Tracer struct {
+	exporter IExporter
+}
+
+// NewTracer 创建追踪
+// exporter span的导出，为nil时panic
+func NewTracer(exporter IExporter) *Tracer {
+	if exporter == nil {
+		panic("exporter is nil.")
+	}
+	return &Tracer{exporter: exporter}
+}
+
+func (t *Tracer) Start(parent context.Context, name string, startTime time.Time) (context.Context, ISpan) {
+	if parent == nil {
+		parent = context.TODO()
+	}
+	data := &SpanData{
+		Name:      name,
+		SpanId:    newId(8),
+		StartTime: startTime,
+		Attrs:     make(map[string]interface{}),
+	}
+	sampled := true
+	parentSc, ok := SpanContextFromContext(parent)
+	if ok {
+		data.TraceId = parentSc.TraceId
+		data.ParentSpanId = parentSc.SpanId
+		data.RemoteParent = parentSc.Remote
+		sampled = parentSc.Sampled
+	} else {
+		data.TraceId = newId(16)
+	}
+	for _, key := range ctxAttrKeys {
+		val := common.ContextValue(parent, key)
+		if val != nil {
+			data.Attrs[key] = val
+		}
+	}
+
+	s := &span{
+		data:     data,
+		sc:       SpanContext{TraceId: data.TraceId, SpanId: data.SpanId, Sampled: sampled},
+		exporter: t.exporter,
+	}
+	return ContextWithSpanContext(parent, s.sc), s
+}
+
+// span 默认的span实现
+type span struct {
+	data     *SpanData
+	sc       SpanContext
+	exporter IExporter
+	ended    bool
+	mut      sync.Mutex
+}
+
+func (s *span) GetSpanContext() SpanContext {
+	return s.sc
+}
+
+func (s *span) SetAttr(key string, val interface{}) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if !s.ended {
+		s.data.Attrs[key] = val
+	}
+}
+
+func (s *span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if !s.ended {
+		s.data.Err = err
+	}
+}
+
+func (s *span) End() {
+	s.mut.Lock()
+	if s.ended {
+		s.mut.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	s.mut.Unlock()
+	if s.sc.Sampled {
+		s.exporter.Export(s.data)
+	}
+}
+
+// newId 生成随机的16进制id，size为字节数，不会全为0
+func newId(size int) string {
+	buf := make([]byte, size)
+	for {
+		rand.Read(buf)
+		for _, b := range buf {
+			if b != 0 {
+				return hex.EncodeToString(buf)
+			}
+		}
+	}
+}
+
+// MemoryExporter 保存在内存中的导出，用于测试
+type MemoryExporter struct {
+	spans []*SpanData
+	mut   sync.Mutex
+}
+
+// NewMemoryExporter 创建内存中的导出
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+func (e *MemoryExporter) Export(data *SpanData) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.spans = append(e.spans, data)
+}
+
+// GetSpans 获取所有已结束的span，按结束顺序
+func (e *MemoryExporter) GetSpans() []*SpanData {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return append([]*SpanData{}, e.spans...)
+}
+
+// GetSpansByName 获取该名称的span，如Span_Read
+func (e *MemoryExporter) GetSpansByName(name string) []*SpanData {
+	ret := make([]*SpanData, 0)
+	for _, data := range e.GetSpans() {
+		if data.Name == name {
+			ret = append(ret, data)
+		}
+	}
+	return ret
+}
+
+// Reset 清除所有的span
+func (e *MemoryExporter) Reset() {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.spans = nil
+}
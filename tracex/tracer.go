@@ -0,0 +1,148 @@
+/*
+ * 分布式追踪，对channel的连接，读取，处理，写入和关闭生成span，通过SetTracer设置实现，默认不追踪，
+ * 上下文通过W3C traceparent在ws的header或者包中传递，见propagation.go
+ * Author:slive
+ * DATE:2020/9/25
+ */
+package tracex
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Span_Connect 连接，从打开channel到激活
+	Span_Connect = "connect"
+	// Span_Read 读取，从读取到包到放入读协程池
+	Span_Read = "read"
+	// Span_Handle 处理，执行读处理方法
+	Span_Handle = "handle"
+	// Span_Write 写入，从写之前的处理到发送(批量时为缓存)
+	Span_Write = "write"
+	// Span_Close 关闭，从开始关闭到执行完onRelease
+	Span_Close = "close"
+)
+
+const (
+	// Attr_Size 包的字节数
+	Attr_Size = "size"
+	// Attr_Batch 是否为批量写入
+	Attr_Batch = "batch"
+)
+
+// ISpan 追踪的一个操作，End后不可再修改
+type ISpan interface {
+	// GetSpanContext 获取span的上下文，用于传递
+	GetSpanContext() SpanContext
+
+	// SetAttr 设置属性
+	SetAttr(key string, val interface{})
+
+	// SetError 设置错误，为nil时不处理
+	SetError(err error)
+
+	// End 结束span，重复调用只结束一次
+	End()
+}
+
+// ITracer 追踪的实现，如对接opentelemetry等，默认为NopTracer
+type ITracer interface {
+	// Start 开始span，parent中有span上下文时作为其子span，否则新建trace
+	// startTime 开始时间
+	Start(parent context.Context, name string, startTime time.Time) (context.Context, ISpan)
+}
+
+// tracerHolder atomic.Value需要存储相同的类型
+type tracerHolder struct {
+	tracer ITracer
+}
+
+var curTracer atomic.Value
+
+// enabled 是否已设置非Nop的实现，为0时跳过追踪的处理
+var enabled int32
+
+func init() {
+	SetTracer(nil)
+}
+
+// SetTracer 设置追踪的实现，为nil时不追踪
+func SetTracer(tracer ITracer) {
+	_, isNop := tracer.(*NopTracer)
+	if tracer == nil || isNop {
+		tracer = nopTracer
+		atomic.StoreInt32(&enabled, 0)
+	} else {
+		atomic.StoreInt32(&enabled, 1)
+	}
+	curTracer.Store(&tracerHolder{tracer: tracer})
+}
+
+// GetTracer 获取追踪的实现
+func GetTracer() ITracer {
+	return curTracer.Load().(*tracerHolder).tracer
+}
+
+// IsEnabled 是否开启追踪
+func IsEnabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// StartSpan 以当前时间开始span，返回带有该span上下文的context
+func StartSpan(parent context.Context, name string) (context.Context, ISpan) {
+	return StartSpanAt(parent, name, time.Now())
+}
+
+// StartSpanAt 以指定时间开始span，如包的读取时间
+func StartSpanAt(parent context.Context, name string, startTime time.Time) (context.Context, ISpan) {
+	if !IsEnabled() {
+		return parent, nopSpan
+	}
+	return GetTracer().Start(parent, name, startTime)
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext 返回带有span上下文的context，如提取到的远端span上下文
+func ContextWithSpanContext(parent context.Context, sc SpanContext) context.Context {
+	return context.WithValue(parent, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext 获取context中的span上下文
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// NopTracer 不追踪的实现
+type NopTracer struct {
+}
+
+var nopTracer = &NopTracer{}
+
+func (t *NopTracer) Start(parent context.Context, name string, startTime time.Time) (context.Context, ISpan) {
+	return parent, nopSpan
+}
+
+type noopSpan struct {
+}
+
+var nopSpan = &noopSpan{}
+
+func (s *noopSpan) GetSpanContext() SpanContext {
+	return SpanContext{}
+}
+
+func (s *noopSpan) SetAttr(key string, val interface{}) {
+}
+
+func (s *noopSpan) SetError(err error) {
+}
+
+func (s *noopSpan) End() {
+}
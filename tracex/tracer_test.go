@@ -0,0 +1,140 @@
+/*
+ * Author:slive
+ * DATE:2020/9/25
+ */
+package tracex
+
+import (
+	"context"
+	"errors"
+	"github.com/slive/gsfly/common"
+	"net/http"
+	"testing"
+)
+
+func TestTraceparent(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		t.Fatalf("parse error:%v", err)
+	}
+	if sc.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanId != "00f067aa0ba902b7" || !sc.Sampled || !sc.Remote {
+		t.Fatalf("unexpected span context:%+v", sc)
+	}
+	if FormatTraceparent(sc) != traceparent {
+		t.Fatalf("unexpected format:%v", FormatTraceparent(sc))
+	}
+
+	sc, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future")
+	if err != nil || sc.Sampled {
+		t.Fatalf("higher version should be compatible, err:%v", err)
+	}
+
+	invalids := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",
+	}
+	for _, invalid := range invalids {
+		_, err := ParseTraceparent(invalid)
+		if err == nil {
+			t.Fatalf("should be invalid:%v", invalid)
+		}
+	}
+	if FormatTraceparent(SpanContext{}) != "" {
+		t.Fatal("invalid span context should format to empty.")
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	header := http.Header{}
+	header.Set("Traceparent", traceparent)
+	ctx := Extract(context.TODO(), HeaderCarrier(header))
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok || sc.SpanId != "00f067aa0ba902b7" {
+		t.Fatalf("extract error:%+v", sc)
+	}
+
+	carrier := MapCarrier{}
+	Inject(ctx, carrier)
+	if carrier[TRACEPARENT] != traceparent {
+		t.Fatalf("unexpected inject:%v", carrier)
+	}
+
+	// 无效或者没有时返回原ctx
+	if ExtractTraceparent(ctx, "invalid") != ctx || Extract(ctx, MapCarrier{}) != ctx {
+		t.Fatal("invalid traceparent should keep ctx.")
+	}
+	carrier = MapCarrier{}
+	Inject(context.TODO(), carrier)
+	if len(carrier) != 0 {
+		t.Fatal("should not inject without span context.")
+	}
+}
+
+func TestTracer(t *testing.T) {
+	defer SetTracer(nil)
+	if IsEnabled() {
+		t.Fatal("should be disabled by default.")
+	}
+	ctx, span := StartSpan(context.TODO(), Span_Connect)
+	if ctx != context.TODO() || span.GetSpanContext().IsValid() {
+		t.Fatal("nop tracer should not create span.")
+	}
+	span.End()
+
+	exporter := NewMemoryExporter()
+	SetTracer(NewTracer(exporter))
+	if !IsEnabled() {
+		t.Fatal("should be enabled.")
+	}
+
+	rootCtx := common.ContextWithValue(context.TODO(), common.Key_ChId, "ch1")
+	rootCtx, root := StartSpan(rootCtx, Span_Connect)
+	childCtx, child := StartSpan(rootCtx, Span_Handle)
+	child.SetAttr(Attr_Size, 5)
+	child.SetError(errors.New("handle error"))
+	child.End()
+	child.End()
+	child.SetAttr("late", true)
+	root.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != Span_Handle || spans[1].Name != Span_Connect {
+		t.Fatalf("unexpected spans:%v", spans)
+	}
+	rootData, childData := spans[1], spans[0]
+	if childData.TraceId != rootData.TraceId || childData.ParentSpanId != rootData.SpanId || rootData.ParentSpanId != "" {
+		t.Fatalf("child should be in the same trace, root:%+v, child:%+v", rootData, childData)
+	}
+	if childData.Attrs[common.Key_ChId] != "ch1" || childData.Attrs[Attr_Size] != 5 || childData.Attrs["late"] != nil {
+		t.Fatalf("unexpected attrs:%v", childData.Attrs)
+	}
+	if childData.Err == nil || childData.GetDuration() < 0 {
+		t.Fatalf("unexpected child:%+v", childData)
+	}
+	sc, _ := SpanContextFromContext(childCtx)
+	if sc.SpanId != childData.SpanId || len(sc.TraceId) != 32 || len(sc.SpanId) != 16 {
+		t.Fatalf("unexpected span context:%+v", sc)
+	}
+
+	// 未采样的父span，子span也不导出
+	exporter.Reset()
+	unsampled := ExtractTraceparent(context.TODO(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span = StartSpan(unsampled, Span_Read)
+	span.End()
+	if len(exporter.GetSpans()) != 0 || span.GetSpanContext().TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatal("unsampled span should not be exported.")
+	}
+
+	SetTracer(&NopTracer{})
+	if IsEnabled() {
+		t.Fatal("nop tracer should disable.")
+	}
+}